@@ -0,0 +1,578 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/googleapis/gax-go/v2"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// States of a pending transaction.
+const (
+	approvalPending    = "PENDING"
+	approvalApproved   = "APPROVED"
+	approvalSubmitting = "SUBMITTING"
+	approvalSubmitted  = "SUBMITTED"
+	approvalRejected   = "REJECTED"
+	approvalExpired    = "EXPIRED"
+	approvalFailed     = "FAILED"
+)
+
+const (
+	// pendingTransactionPrefix is the resource name prefix of pending transactions.
+	pendingTransactionPrefix = "pendingTransactions/"
+
+	// approvalSweepInterval is how often pending transactions are checked for expiry.
+	approvalSweepInterval = time.Minute
+
+	// approvalRetention is how long finished pending transactions are kept in the store.
+	approvalRetention = 30 * 24 * time.Hour
+)
+
+var (
+	// approvalStoreFile enables the approval workflow and persists pending transactions.
+	approvalStoreFile = flag.String("approval-store", "", "path to the JSON file persisting pending transactions; enables multi-party approvals")
+
+	// approvalThresholds are the per-asset amounts above which transactions need approval.
+	approvalThresholds = flag.String("approval-thresholds", "", "comma-separated asset=amount pairs, in base units, above which transactions need approval, e.g. native=1000000000000000000")
+
+	// approvalQuorum is the number of distinct approvers a pending transaction needs.
+	approvalQuorum = flag.Int("approval-quorum", 2, "number of distinct approvers needed to submit a pending transaction")
+
+	// approvalTTL is how long a pending transaction may wait for its quorum.
+	approvalTTL = flag.Duration("approval-ttl", 24*time.Hour, "how long a pending transaction may wait for approval before it expires")
+)
+
+// approval is a single approval or rejection of a pending transaction.
+type approval struct {
+	Approver string    `json:"approver"`
+	Time     time.Time `json:"time"`
+	Comment  string    `json:"comment,omitempty"`
+}
+
+// review is the optional body of the approve and reject routes.
+type review struct {
+	Comment string `json:"comment"`
+}
+
+// pendingTransaction is a CreateMPCTransaction request held for approval.
+type pendingTransaction struct {
+	Name       string          `json:"name"`
+	State      string          `json:"state"`
+	Parent     string          `json:"parent"`
+	Request    json.RawMessage `json:"request"`
+	Asset      string          `json:"asset,omitempty"`
+	Amount     string          `json:"amount,omitempty"`
+	Requester  string          `json:"requester"`
+	Approvals  []approval      `json:"approvals"`
+	Rejection  *approval       `json:"rejection,omitempty"`
+	CreateTime time.Time       `json:"createTime"`
+	ExpireTime time.Time       `json:"expireTime"`
+	UpdateTime time.Time       `json:"updateTime"`
+	Operation  string          `json:"operation,omitempty"`
+	Error      *errorResponse  `json:"error,omitempty"`
+}
+
+// approvalStoreContents is the JSON file persisting the pending transactions.
+type approvalStoreContents struct {
+	PendingTransactions []*pendingTransaction `json:"pendingTransactions"`
+}
+
+// submitFunc forwards an approved CreateMPCTransaction request upstream.
+type submitFunc func(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error)
+
+// approvalStore holds transactions above the approval thresholds until a quorum of
+// distinct approvers accepts them, persisting every change to a JSON file.
+type approvalStore struct {
+	path       string
+	thresholds map[string]*big.Int
+	quorum     int
+	ttl        time.Duration
+	submit     submitFunc
+
+	mu      sync.Mutex
+	pending map[string]*pendingTransaction
+}
+
+// newApprovalStore loads the pending transactions persisted at path, resumes the
+// submissions interrupted by a restart, and expires pending transactions until ctx is done.
+func newApprovalStore(ctx context.Context, path, thresholds string, quorum int, ttl time.Duration, submit submitFunc) (*approvalStore, error) {
+	if quorum < 1 {
+		return nil, fmt.Errorf("-approval-quorum must be positive, got %d", quorum)
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("-approval-ttl must be positive, got %v", ttl)
+	}
+
+	s := &approvalStore{path: path, quorum: quorum, ttl: ttl, submit: submit, pending: map[string]*pendingTransaction{}}
+
+	var err error
+	if s.thresholds, err = parseApprovalThresholds(thresholds); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("cannot read approval store: %v", err)
+	default:
+		var contents approvalStoreContents
+		if err := json.Unmarshal(data, &contents); err != nil {
+			return nil, fmt.Errorf("cannot parse approval store %q: %v", path, err)
+		}
+		for _, pt := range contents.PendingTransactions {
+			s.pending[pt.Name] = pt
+		}
+	}
+
+	for _, pt := range s.pending {
+		if pt.State == approvalSubmitting {
+			logger.Info("Resuming submission of pending transaction", zap.String("pendingTransaction", pt.Name))
+			go s.resubmit(pt.Name)
+		}
+	}
+	go s.sweep(ctx)
+
+	return s, nil
+}
+
+// parseApprovalThresholds parses a comma-separated list of asset=amount pairs.
+func parseApprovalThresholds(value string) (map[string]*big.Int, error) {
+	thresholds := map[string]*big.Int{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		asset, amount, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("approval threshold %q must have the form asset=amount", pair)
+		}
+		threshold, err := parseAmount(amount)
+		if err != nil {
+			return nil, fmt.Errorf("approval threshold %q: %v", pair, err)
+		}
+		thresholds[strings.ToLower(asset)] = threshold
+	}
+	return thresholds, nil
+}
+
+// requiresApproval reports whether req transfers more than the threshold of its asset.
+// Requests whose input cannot be inspected always require approval.
+func (s *approvalStore) requiresApproval(req *mpcTransactions.CreateMPCTransactionRequest) bool {
+	if s == nil {
+		return false
+	}
+
+	tx, err := transactionFactsFrom(req)
+	if err != nil || !tx.inspectable {
+		return true
+	}
+	threshold, ok := s.thresholds[tx.asset]
+	return ok && tx.amount.Cmp(threshold) > 0
+}
+
+// create holds req for approval on behalf of requester.
+func (s *approvalStore) create(req *mpcTransactions.CreateMPCTransactionRequest, requester string) (*pendingTransaction, error) {
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	name := pendingTransactionPrefix + id
+
+	// The upstream request ID makes resubmission after a restart idempotent.
+	if req.RequestId == "" {
+		req.RequestId = id
+	}
+	request, err := protojson.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	pt := &pendingTransaction{
+		Name:       name,
+		State:      approvalPending,
+		Parent:     req.GetParent(),
+		Request:    request,
+		Requester:  requester,
+		Approvals:  []approval{},
+		CreateTime: now,
+		ExpireTime: now.Add(s.ttl),
+		UpdateTime: now,
+	}
+	if tx, err := transactionFactsFrom(req); err == nil && tx.inspectable {
+		pt.Asset = tx.asset
+		pt.Amount = tx.amount.String()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[name] = pt
+	if err := s.save(); err != nil {
+		delete(s.pending, name)
+		return nil, err
+	}
+	return pt.copy(), nil
+}
+
+// list returns the pending transactions in state, or all of them if state is empty,
+// oldest first.
+func (s *approvalStore) list(state string) []*pendingTransaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked(time.Now())
+	list := []*pendingTransaction{}
+	for _, pt := range s.pending {
+		if state == "" || pt.State == state {
+			list = append(list, pt.copy())
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreateTime.Before(list[j].CreateTime) })
+	return list
+}
+
+// get returns the named pending transaction.
+func (s *approvalStore) get(name string) (*pendingTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked(time.Now())
+	pt, ok := s.pending[name]
+	if !ok {
+		return nil, errPendingTransactionNotFound(name)
+	}
+	return pt.copy(), nil
+}
+
+// approve records the approval of the named pending transaction by approver, unless
+// policy denies the transaction. When the quorum is met the transaction moves to
+// SUBMITTING and its request is returned for the caller to submit. Approving an APPROVED
+// transaction submits it again.
+func (s *approvalStore) approve(name, approver, comment string, policy *policyEngine) (*pendingTransaction, *mpcTransactions.CreateMPCTransactionRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pt, err := s.reviewableLocked(name, approver)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The policy may have changed since the transaction was requested.
+	req, err := pt.request()
+	if err != nil {
+		return nil, nil, err
+	}
+	if violation := policy.checkTransaction(req); violation != nil {
+		return nil, nil, violation
+	}
+
+	now := time.Now().UTC()
+	if pt.State == approvalPending {
+		pt.Approvals = append(pt.Approvals, approval{Approver: approver, Time: now, Comment: comment})
+	}
+	pt.UpdateTime = now
+
+	if pt.State == approvalPending && len(pt.Approvals) < s.quorum {
+		req = nil
+	} else {
+		pt.State = approvalSubmitting
+	}
+
+	if err := s.save(); err != nil {
+		return nil, nil, err
+	}
+	return pt.copy(), req, nil
+}
+
+// reject rejects the named pending transaction on behalf of approver.
+func (s *approvalStore) reject(name, approver, comment string) (*pendingTransaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pt, err := s.reviewableLocked(name, approver)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	pt.Rejection = &approval{Approver: approver, Time: now, Comment: comment}
+	pt.State = approvalRejected
+	pt.UpdateTime = now
+
+	if err := s.save(); err != nil {
+		return nil, err
+	}
+	return pt.copy(), nil
+}
+
+// reviewableLocked returns the named pending transaction if approver may still approve
+// or reject it. The requester may not, nor may previous approvers while it is PENDING.
+func (s *approvalStore) reviewableLocked(name, approver string) (*pendingTransaction, error) {
+	s.expireLocked(time.Now())
+	pt, ok := s.pending[name]
+	if !ok {
+		return nil, errPendingTransactionNotFound(name)
+	}
+
+	if pt.State != approvalPending && pt.State != approvalApproved {
+		return nil, &approvalError{code: codes.FailedPrecondition, message: fmt.Sprintf("%s is %s", name, pt.State)}
+	}
+	if approver == pt.Requester {
+		return nil, &approvalError{code: codes.PermissionDenied, message: "requesters cannot review their own transactions"}
+	}
+	if pt.State != approvalPending {
+		return pt, nil
+	}
+	for _, a := range pt.Approvals {
+		if a.Approver == approver {
+			return nil, &approvalError{code: codes.FailedPrecondition, message: fmt.Sprintf("%s was already approved by %s", name, approver)}
+		}
+	}
+	return pt, nil
+}
+
+// finishSubmission records the outcome of submitting the named pending transaction.
+// Submissions WaaS did not answer leave the transaction APPROVED, to be approved again
+// to retry; its request ID keeps the retry from duplicating a submission that went through.
+func (s *approvalStore) finishSubmission(name string, op createMPCTransactionOperation, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pt, ok := s.pending[name]
+	if !ok {
+		return
+	}
+	if err != nil {
+		_, pt.Error = translateError(err)
+		switch pt.Error.Code {
+		case codeName(codes.Unavailable), codeName(codes.DeadlineExceeded):
+			pt.State = approvalApproved
+		default:
+			pt.State = approvalFailed
+		}
+	} else {
+		pt.State = approvalSubmitted
+		pt.Operation = op.Name()
+		pt.Error = nil
+	}
+	pt.UpdateTime = time.Now().UTC()
+
+	if err := s.save(); err != nil {
+		logger.Error("Error saving approval store", zap.Error(err))
+	}
+}
+
+// submitApproved submits the named pending transaction once its quorum is met and
+// records the outcome. The submission is detached from the deadline and cancellation of
+// parent, the approver's request, so that it completes even if they disconnect, but keeps
+// its request ID and trace.
+func (s *approvalStore) submitApproved(parent context.Context, name string, req *mpcTransactions.CreateMPCTransactionRequest) (createMPCTransactionOperation, error) {
+	ctx := trace.ContextWithSpan(context.Background(), trace.SpanFromContext(parent))
+	if md, ok := metadata.FromOutgoingContext(parent); ok {
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+	ctx, cancel := context.WithTimeout(withRPC(ctx, operationCreateMPCTransaction), routeTimeout(operationCreateMPCTransaction))
+	defer cancel()
+
+	op, err := s.submit(ctx, req)
+	s.finishSubmission(name, op, err)
+	return op, err
+}
+
+// resubmit submits the named pending transaction whose submission was interrupted, outside
+// of any request.
+func (s *approvalStore) resubmit(name string) {
+	s.mu.Lock()
+	req, err := s.pending[name].request()
+	s.mu.Unlock()
+	if err != nil {
+		s.finishSubmission(name, nil, err)
+		return
+	}
+	s.submitApproved(context.Background(), name, req)
+}
+
+// sweep expires pending transactions and drops old finished ones until ctx is done.
+func (s *approvalStore) sweep(ctx context.Context) {
+	ticker := time.NewTicker(approvalSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			changed := s.expireLocked(now)
+			for name, pt := range s.pending {
+				if pt.State != approvalPending && pt.State != approvalApproved && pt.State != approvalSubmitting && now.Sub(pt.UpdateTime) > approvalRetention {
+					delete(s.pending, name)
+					changed = true
+				}
+			}
+			if changed {
+				if err := s.save(); err != nil {
+					logger.Error("Error saving approval store", zap.Error(err))
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// expireLocked expires the pending and unsubmitted approved transactions past their
+// expire time and reports whether any were. Callers persisting other changes save the
+// expiries along with them.
+func (s *approvalStore) expireLocked(now time.Time) bool {
+	expired := false
+	for _, pt := range s.pending {
+		if (pt.State == approvalPending || pt.State == approvalApproved) && now.After(pt.ExpireTime) {
+			pt.State = approvalExpired
+			pt.UpdateTime = now.UTC()
+			expired = true
+		}
+	}
+	return expired
+}
+
+// save writes the store to a temporary file and renames it into place, so that a crash
+// never leaves a truncated store behind.
+func (s *approvalStore) save() error {
+	contents := approvalStoreContents{PendingTransactions: make([]*pendingTransaction, 0, len(s.pending))}
+	for _, pt := range s.pending {
+		contents.PendingTransactions = append(contents.PendingTransactions, pt)
+	}
+	sort.Slice(contents.PendingTransactions, func(i, j int) bool {
+		return contents.PendingTransactions[i].CreateTime.Before(contents.PendingTransactions[j].CreateTime)
+	})
+
+	data, err := json.MarshalIndent(contents, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("cannot save approval store: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot save approval store: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot save approval store: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot save approval store: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("cannot save approval store: %v", err)
+	}
+	return nil
+}
+
+// bindReview parses the optional review body of the approve and reject routes, of up to
+// -max-request-body bytes.
+func bindReview(c *gin.Context) (*review, error) {
+	r := &review{}
+	if c.Request.ContentLength == 0 {
+		return r, nil
+	}
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, *maxRequestBody)
+	if err := json.NewDecoder(body).Decode(r); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("cannot parse review: %w", err)
+	}
+	return r, nil
+}
+
+// request decodes the held CreateMPCTransaction request.
+func (pt *pendingTransaction) request() (*mpcTransactions.CreateMPCTransactionRequest, error) {
+	req := &mpcTransactions.CreateMPCTransactionRequest{}
+	if err := protojson.Unmarshal(pt.Request, req); err != nil {
+		return nil, fmt.Errorf("cannot decode %s: %v", pt.Name, err)
+	}
+	return req, nil
+}
+
+// copy returns a copy of pt that is safe to use without holding the store lock.
+func (pt *pendingTransaction) copy() *pendingTransaction {
+	c := *pt
+	c.Approvals = append([]approval{}, pt.Approvals...)
+	return &c
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b), nil
+}
+
+// formatUUID formats the 16 bytes of a UUID in its canonical form.
+func formatUUID(b []byte) string {
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+// approvalError is an approval workflow error with the code to respond with.
+type approvalError struct {
+	code    codes.Code
+	message string
+}
+
+func (e *approvalError) Error() string {
+	return e.message
+}
+
+// errPendingTransactionNotFound returns the NOT_FOUND error for the named pending transaction.
+func errPendingTransactionNotFound(name string) error {
+	return &approvalError{code: codes.NotFound, message: fmt.Sprintf("%s not found", name)}
+}
+
+// writeApprovalError writes the error envelope for err, an approvalError, a
+// policyViolation or a store error.
+func writeApprovalError(c *gin.Context, err error) {
+	var approvalErr *approvalError
+	var violation *policyViolation
+	switch {
+	case errors.As(err, &approvalErr):
+		abortWithCode(c, approvalErr.code, approvalErr.message)
+		return
+	case errors.As(err, &violation):
+		enforcePolicy(c, violation)
+		return
+	}
+	abortWithCode(c, codes.Internal, err.Error())
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestApprovalStore returns a store persisted at path needing two approvals above
+// 1000 native units, whose submissions fail with UNAVAILABLE and are sent to submitted.
+func newTestApprovalStore(t *testing.T, path string, ttl time.Duration, submitted chan<- *mpcTransactions.CreateMPCTransactionRequest) *approvalStore {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	submit := func(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error) {
+		if submitted != nil {
+			submitted <- req
+		}
+		return nil, status.Error(codes.Unavailable, "upstream unavailable")
+	}
+
+	store, err := newApprovalStore(ctx, path, "native=1000", 2, ttl, submit)
+	if err != nil {
+		t.Fatalf("newApprovalStore() = %v", err)
+	}
+	return store
+}
+
+func TestParseApprovalThresholds(t *testing.T) {
+	thresholds, err := parseApprovalThresholds(" native=1000, 0xABC=0x10 ,")
+	if err != nil || len(thresholds) != 2 || thresholds["native"].Int64() != 1000 || thresholds["0xabc"].Int64() != 16 {
+		t.Errorf("parseApprovalThresholds() = %v, %v, want native and 0xabc", thresholds, err)
+	}
+	for _, value := range []string{"native", "native=lots", "native=-1"} {
+		if _, err := parseApprovalThresholds(value); err == nil {
+			t.Errorf("parseApprovalThresholds(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestRequiresApproval(t *testing.T) {
+	store := newTestApprovalStore(t, filepath.Join(t.TempDir(), "approvals.json"), time.Hour, nil)
+
+	for _, tt := range []struct {
+		name string
+		req  *mpcTransactions.CreateMPCTransactionRequest
+		want bool
+	}{
+		{name: "below threshold", req: newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "1000", "1", nil)},
+		{name: "above threshold", req: newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "1001", "1", nil), want: true},
+		{name: "no threshold", req: newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testToken, "0", "1", transferData(testRecipient, 0xff))},
+		{name: "raw input", req: &mpcTransactions.CreateMPCTransactionRequest{Parent: "pools/p/mpcWallets/w"}, want: true},
+	} {
+		if got := store.requiresApproval(tt.req); got != tt.want {
+			t.Errorf("requiresApproval(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	var disabled *approvalStore
+	if disabled.requiresApproval(&mpcTransactions.CreateMPCTransactionRequest{}) {
+		t.Error("requiresApproval() without an approval store = true, want false")
+	}
+}
+
+func TestApprovalWorkflow(t *testing.T) {
+	submitted := make(chan *mpcTransactions.CreateMPCTransactionRequest, 1)
+	store := newTestApprovalStore(t, filepath.Join(t.TempDir(), "approvals.json"), time.Hour, submitted)
+
+	pt, err := store.create(newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "5000", "1", nil), "requester")
+	if err != nil {
+		t.Fatalf("create() = %v", err)
+	}
+	if pt.State != approvalPending || pt.Asset != nativeAsset || pt.Amount != "5000" || !strings.HasPrefix(pt.Name, pendingTransactionPrefix) {
+		t.Errorf("create() = %+v, want a pending native transfer of 5000", pt)
+	}
+
+	checkCode := func(err error, want codes.Code) {
+		t.Helper()
+		approvalErr, ok := err.(*approvalError)
+		if !ok || approvalErr.code != want {
+			t.Errorf("error = %v, want %v", err, want)
+		}
+	}
+	_, _, err = store.approve(pt.Name, "requester", "", nil)
+	checkCode(err, codes.PermissionDenied)
+	_, _, err = store.approve(pendingTransactionPrefix+"unknown", "approver-1", "", nil)
+	checkCode(err, codes.NotFound)
+
+	pt, req, err := store.approve(pt.Name, "approver-1", "looks fine", nil)
+	if err != nil || req != nil || pt.State != approvalPending || len(pt.Approvals) != 1 || pt.Approvals[0].Comment != "looks fine" {
+		t.Fatalf("first approve() = %+v, %v, %v, want one approval", pt, req, err)
+	}
+	_, _, err = store.approve(pt.Name, "approver-1", "", nil)
+	checkCode(err, codes.FailedPrecondition)
+
+	pt, req, err = store.approve(pt.Name, "approver-2", "", nil)
+	if err != nil || req == nil || pt.State != approvalSubmitting {
+		t.Fatalf("second approve() = %+v, %v, %v, want the request to submit", pt, req, err)
+	}
+	// The held request carries a request ID, making its submission idempotent upstream.
+	if req.GetRequestId() == "" || req.GetParent() != "pools/p/mpcWallets/w" {
+		t.Errorf("approved request = %v, want the held request with a request ID", req)
+	}
+	_, err = store.reject(pt.Name, "approver-3", "")
+	checkCode(err, codes.FailedPrecondition)
+
+	if _, err := store.submitApproved(context.Background(), pt.Name, req); status.Code(err) != codes.Unavailable {
+		t.Errorf("submitApproved() = %v, want UNAVAILABLE", err)
+	}
+	<-submitted
+	if pt, _ := store.get(pt.Name); pt.State != approvalApproved || pt.Error == nil || pt.Error.Code != "UNAVAILABLE" {
+		t.Errorf("get() after an unavailable submission = %+v, want APPROVED", pt)
+	}
+
+	// Approving it again, even by a previous approver, retries the submission.
+	_, _, err = store.approve(pt.Name, "requester", "", nil)
+	checkCode(err, codes.PermissionDenied)
+	if retried, req, err := store.approve(pt.Name, "approver-1", "", nil); err != nil || req == nil || retried.State != approvalSubmitting || len(retried.Approvals) != 2 {
+		t.Fatalf("approve() of an APPROVED transaction = %+v, %v, %v, want the request to submit", retried, req, err)
+	}
+	store.finishSubmission(pt.Name, nil, status.Error(codes.InvalidArgument, "invalid transaction"))
+	if pt, _ := store.get(pt.Name); pt.State != approvalFailed || pt.Error.Code != "INVALID_ARGUMENT" {
+		t.Errorf("get() after a rejected submission = %+v, want FAILED", pt)
+	}
+
+	other, _ := store.create(newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "5000", "1", nil), "requester")
+	if other, err := store.reject(other.Name, "approver-1", "too much"); err != nil || other.State != approvalRejected || other.Rejection.Comment != "too much" {
+		t.Errorf("reject() = %+v, %v, want REJECTED", other, err)
+	}
+
+	if list := store.list(approvalRejected); len(list) != 1 || list[0].Name != other.Name {
+		t.Errorf("list(REJECTED) = %v, want %s", list, other.Name)
+	}
+	if list := store.list(""); len(list) != 2 || list[0].Name != pt.Name {
+		t.Errorf("list() = %v, want both transactions oldest first", list)
+	}
+}
+
+func TestApprovalPolicy(t *testing.T) {
+	store := newTestApprovalStore(t, filepath.Join(t.TempDir(), "approvals.json"), time.Hour, nil)
+	policy := newTestPolicyEngine(t, `
+rules:
+  - name: mainnet-only
+    networks: ["ethereum-mainnet"]
+`)
+
+	// Transactions the policy denies since they were requested cannot be approved.
+	pt, _ := store.create(newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "5000", "1", nil), "requester")
+	_, _, err := store.approve(pt.Name, "approver-1", "", policy)
+	if violation, ok := err.(*policyViolation); !ok || violation.Rule != "mainnet-only" {
+		t.Errorf("approve() of a denied transaction = %v, want a violation of mainnet-only", err)
+	}
+	if pt, _ := store.get(pt.Name); pt.State != approvalPending || len(pt.Approvals) != 0 {
+		t.Errorf("get() after a denied approval = %+v, want no approvals", pt)
+	}
+}
+
+func TestApprovalStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "approvals.json")
+	store := newTestApprovalStore(t, path, time.Hour, nil)
+
+	pending, _ := store.create(newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "5000", "1", nil), "requester")
+	submitting, _ := store.create(newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "6000", "1", nil), "requester")
+	store.approve(submitting.Name, "approver-1", "", nil)
+	store.approve(submitting.Name, "approver-2", "", nil)
+
+	// A restart resumes the interrupted submission and keeps the pending transaction.
+	submitted := make(chan *mpcTransactions.CreateMPCTransactionRequest, 1)
+	restarted := newTestApprovalStore(t, path, time.Hour, submitted)
+	select {
+	case req := <-submitted:
+		if req.GetParent() != "pools/p/mpcWallets/w" {
+			t.Errorf("resubmitted request = %v, want the approved transaction", req)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the interrupted submission was not resumed")
+	}
+	if pt, err := restarted.get(pending.Name); err != nil || pt.State != approvalPending || pt.Requester != "requester" {
+		t.Errorf("get() after a restart = %+v, %v, want the pending transaction", pt, err)
+	}
+
+	if _, err := newApprovalStore(context.Background(), writeTestFile(t, t.TempDir(), "bad.json", "{"), "", 2, time.Hour, nil); err == nil {
+		t.Error("newApprovalStore() of a malformed store succeeded, want an error")
+	}
+}
+
+func TestApprovalExpiry(t *testing.T) {
+	store := newTestApprovalStore(t, filepath.Join(t.TempDir(), "approvals.json"), time.Millisecond, nil)
+
+	pt, _ := store.create(newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "5000", "1", nil), "requester")
+	time.Sleep(5 * time.Millisecond)
+	if pt, _ := store.get(pt.Name); pt.State != approvalExpired {
+		t.Errorf("get() past the expire time = %s, want EXPIRED", pt.State)
+	}
+	if _, _, err := store.approve(pt.Name, "approver-1", "", nil); err == nil {
+		t.Error("approve() of an expired transaction succeeded, want an error")
+	}
+}
+
+func TestBindReview(t *testing.T) {
+	for _, tt := range []struct {
+		body        string
+		wantComment string
+		wantErr     bool
+	}{
+		{},
+		{body: `{"comment": "ok"}`, wantComment: "ok"},
+		{body: `{"comment": `, wantErr: true},
+	} {
+		c, _ := newTestContext(tt.body)
+		r, err := bindReview(c)
+		if (err != nil) != tt.wantErr || (err == nil && r.Comment != tt.wantComment) {
+			t.Errorf("bindReview(%q) = %+v, %v, want comment %q, error %v", tt.body, r, err, tt.wantComment, tt.wantErr)
+		}
+	}
+
+	defer func(limit int64) { *maxRequestBody = limit }(*maxRequestBody)
+	*maxRequestBody = 16
+	c, w := newTestContext(`{"comment": "` + strings.Repeat("a", 32) + `"}`)
+	_, err := bindReview(c)
+	if err == nil {
+		t.Fatal("bindReview() of a body above -max-request-body succeeded, want an error")
+	}
+	writeBadRequest(c, err)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("writeBadRequest(%v) = %d, want 413", err, w.Code)
+	}
+}
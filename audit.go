@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// auditGenesisHash is the previous hash of the first record of an audit log.
+	auditGenesisHash = "0000000000000000000000000000000000000000000000000000000000000000"
+
+	// maxAuditCapture caps how much of a response is buffered to find its result name.
+	maxAuditCapture = 64 << 10
+
+	// maxAuditLine caps the length of a record read back from an audit log.
+	maxAuditLine = 1 << 20
+)
+
+// auditLogFile is the append-only JSON-lines audit log.
+var auditLogFile = flag.String("audit-log", "", "path to the append-only JSON-lines audit log of mutating calls")
+
+// auditRecord is a single entry of the audit log. Each record holds the hash of the
+// previous one, so that altering, removing or reordering records breaks the chain.
+type auditRecord struct {
+	Seq         uint64    `json:"seq"`
+	Time        time.Time `json:"time"`
+	Identity    string    `json:"identity,omitempty"`
+	Method      string    `json:"method"`
+	Route       string    `json:"route"`
+	RPC         string    `json:"rpc,omitempty"`
+	Path        string    `json:"path"`
+	Query       string    `json:"query,omitempty"`
+	Resource    string    `json:"resource,omitempty"`
+	RequestHash string    `json:"requestHash"`
+	Status      int       `json:"status"`
+	Outcome     string    `json:"outcome"`
+	Result      string    `json:"result,omitempty"`
+	PrevHash    string    `json:"prevHash"`
+	Hash        string    `json:"hash,omitempty"`
+}
+
+// computeHash returns the hash of the record, covering every field but Hash itself.
+func (r auditRecord) computeHash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// auditLog appends hash-chained records to a JSON-lines file.
+type auditLog struct {
+	mu       sync.Mutex
+	file     *os.File
+	seq      uint64
+	lastHash string
+}
+
+// openAuditLog opens the audit log at path for appending, verifying the existing chain
+// so that new records extend it.
+func openAuditLog(path string) (*auditLog, error) {
+	l := &auditLog{lastHash: auditGenesisHash}
+
+	if existing, err := os.Open(path); err == nil {
+		last, err := verifyAuditLog(existing, nil)
+		existing.Close()
+		if err != nil {
+			return nil, fmt.Errorf("audit log %q is corrupt: %v", path, err)
+		}
+		if last != nil {
+			l.seq = last.Seq
+			l.lastHash = last.Hash
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot read audit log: %v", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %v", err)
+	}
+	l.file = file
+	return l, nil
+}
+
+// append chains record to the log and writes it durably.
+func (l *auditLog) append(record *auditRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	record.Seq = l.seq + 1
+	record.PrevHash = l.lastHash
+	hash, err := record.computeHash()
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+
+	l.seq = record.Seq
+	l.lastHash = record.Hash
+	return nil
+}
+
+// captureWriter records up to limit bytes of a response as it is written.
+type captureWriter struct {
+	gin.ResponseWriter
+	limit     int
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *captureWriter) capture(data []byte) {
+	if remaining := w.limit - w.body.Len(); len(data) > remaining {
+		data = data[:remaining]
+		w.truncated = true
+	}
+	w.body.Write(data)
+}
+
+// audit returns middleware recording every POST request in the audit log, including the
+// ones rejected by the proxy. A nil log disables auditing.
+func audit(l *auditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l == nil || c.Request.Method != http.MethodPost {
+			return
+		}
+
+		body, ok := readRequestBody(c)
+		if !ok {
+			return
+		}
+		sum := sha256.Sum256(body)
+
+		writer := &captureWriter{ResponseWriter: c.Writer, limit: maxAuditCapture}
+		c.Writer = writer
+		c.Next()
+
+		record := &auditRecord{
+			Time:        time.Now().UTC(),
+			Method:      c.Request.Method,
+			Route:       c.FullPath(),
+			RPC:         c.GetString(rpcContextKey),
+			Path:        c.Request.URL.Path,
+			Query:       c.Request.URL.RawQuery,
+			Resource:    auditResource(c),
+			RequestHash: "sha256:" + hex.EncodeToString(sum[:]),
+			Status:      writer.Status(),
+		}
+		if identity := identityFromContext(c); identity != nil {
+			record.Identity = identity.ID
+		}
+		record.Outcome, record.Result = auditOutcome(record.Status, writer.body.Bytes())
+
+		if err := l.append(record); err != nil {
+			requestLogger(c).Error("Error writing audit record", zap.String("method", record.Method), zap.String("path", record.Path), zap.Error(err))
+		}
+	}
+}
+
+// auditResource returns the name of the resource addressed by the path parameters of the
+// route, e.g. "pools/{pool_id}/mpcWallets/{mpc_wallet_id}", or by the poolId query
+// parameter of CreatePool.
+func auditResource(c *gin.Context) string {
+	segments := strings.Split(strings.Trim(c.FullPath(), "/"), "/")
+	// Drop the service and version prefix, e.g. "mpc_wallets/v1".
+	if len(segments) > 2 {
+		segments = segments[2:]
+	}
+
+	var name []string
+	for i := 0; i+1 < len(segments); i++ {
+		if strings.HasPrefix(segments[i+1], ":") {
+			name = append(name, segments[i], c.Param(segments[i+1][1:]))
+			i++
+		}
+	}
+	if len(name) == 0 && c.Query("poolId") != "" {
+		return "pools/" + c.Query("poolId")
+	}
+	return strings.Join(name, "/")
+}
+
+// auditOutcome returns the error code of a failed response, or "OK" and the name of the
+// resource or operation in a successful response.
+func auditOutcome(status int, body []byte) (string, string) {
+	var response struct {
+		Name string `json:"name"`
+		Code string `json:"code"`
+	}
+	json.Unmarshal(body, &response)
+
+	if status >= http.StatusBadRequest {
+		if response.Code != "" {
+			return response.Code, ""
+		}
+		return http.StatusText(status), ""
+	}
+	return "OK", response.Name
+}
+
+// verifyAuditLog checks the hash chain of the audit log read from r, calling visit for
+// every record if set, and returns the last record.
+func verifyAuditLog(r io.Reader, visit func(*auditRecord) error) (*auditRecord, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxAuditLine)
+
+	var last *auditRecord
+	prevHash := auditGenesisHash
+	for line := 1; scanner.Scan(); line++ {
+		record := &auditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return last, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		if want := uint64(line); record.Seq != want {
+			return last, fmt.Errorf("line %d: sequence number %d, want %d", line, record.Seq, want)
+		}
+		if record.PrevHash != prevHash {
+			return last, fmt.Errorf("line %d: record %d does not chain to the previous record", line, record.Seq)
+		}
+		hash, err := record.computeHash()
+		if err != nil {
+			return last, fmt.Errorf("line %d: %v", line, err)
+		}
+		if record.Hash != hash {
+			return last, fmt.Errorf("line %d: record %d was modified", line, record.Seq)
+		}
+
+		if visit != nil {
+			if err := visit(record); err != nil {
+				return last, err
+			}
+		}
+		last = record
+		prevHash = record.Hash
+	}
+	return last, scanner.Err()
+}
+
+// runAuditCommand runs the "audit verify" and "audit export" commands and returns the
+// process exit code.
+func runAuditCommand(args []string) int {
+	if len(args) == 0 || (args[0] != "verify" && args[0] != "export") {
+		fmt.Fprintln(os.Stderr, "usage: proxy audit verify|export -file=<audit log> [flags]")
+		return 2
+	}
+
+	flags := flag.NewFlagSet("audit "+args[0], flag.ContinueOnError)
+	file := flags.String("file", "", "path to the audit log")
+	since := flags.String("since", "", "with export, only records at or after this RFC 3339 time")
+	until := flags.String("until", "", "with export, only records before this RFC 3339 time")
+	identity := flags.String("identity", "", "with export, only records of this caller")
+	resource := flags.String("resource", "", "with export, only records of resources with this name prefix")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "-file is required")
+		return 2
+	}
+
+	var sinceTime, untilTime time.Time
+	for _, t := range []struct {
+		value  string
+		parsed *time.Time
+	}{{*since, &sinceTime}, {*until, &untilTime}} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, t.value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cannot parse time %q: %v\n", t.value, err)
+			return 2
+		}
+		*t.parsed = parsed
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot open audit log: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	var visit func(*auditRecord) error
+	if args[0] == "export" {
+		out := bufio.NewWriter(os.Stdout)
+		defer out.Flush()
+		encoder := json.NewEncoder(out)
+
+		visit = func(record *auditRecord) error {
+			switch {
+			case !sinceTime.IsZero() && record.Time.Before(sinceTime),
+				!untilTime.IsZero() && !record.Time.Before(untilTime),
+				*identity != "" && record.Identity != *identity,
+				*resource != "" && !strings.HasPrefix(record.Resource, *resource) && !strings.HasPrefix(record.Result, *resource):
+				return nil
+			}
+			return encoder.Encode(record)
+		}
+	}
+
+	last, err := verifyAuditLog(f, visit)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "audit log verification failed: %v\n", err)
+		return 1
+	}
+	if args[0] == "verify" {
+		count, hash := uint64(0), auditGenesisHash
+		if last != nil {
+			count, hash = last.Seq, last.Hash
+		}
+		fmt.Printf("audit log OK: %d records, last hash %s\n", count, hash)
+	}
+	return 0
+}
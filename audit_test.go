@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+)
+
+// readAuditLog verifies the audit log at path and returns its records.
+func readAuditLog(t *testing.T, path string) ([]*auditRecord, error) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []*auditRecord
+	_, err = verifyAuditLog(f, func(record *auditRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func TestAuditLogChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	l, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("openAuditLog() = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := l.append(&auditRecord{Method: http.MethodPost, Path: fmt.Sprintf("/pools/%d", i)}); err != nil {
+			t.Fatalf("append() = %v", err)
+		}
+	}
+	l.file.Close()
+
+	// A reopened log extends the existing chain.
+	l, err = openAuditLog(path)
+	if err != nil {
+		t.Fatalf("openAuditLog() of an existing log = %v", err)
+	}
+	if err := l.append(&auditRecord{Method: http.MethodPost, Path: "/pools/3"}); err != nil {
+		t.Fatalf("append() = %v", err)
+	}
+	l.file.Close()
+
+	records, err := readAuditLog(t, path)
+	if err != nil || len(records) != 4 {
+		t.Fatalf("verifyAuditLog() = %d records, %v, want 4 records", len(records), err)
+	}
+	if records[0].PrevHash != auditGenesisHash || records[3].Seq != 4 || records[3].PrevHash != records[2].Hash {
+		t.Errorf("verifyAuditLog() = %+v, want a chain from the genesis hash", records)
+	}
+}
+
+func TestAuditLogConcurrentAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("openAuditLog() = %v", err)
+	}
+	defer l.file.Close()
+
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := l.append(&auditRecord{Method: http.MethodPost, Path: fmt.Sprintf("/pools/%d", i)}); err != nil {
+				t.Errorf("append() = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	records, err := readAuditLog(t, path)
+	if err != nil || len(records) != writers {
+		t.Fatalf("verifyAuditLog() = %d records, %v, want %d chained records", len(records), err, writers)
+	}
+	paths := map[string]bool{}
+	for _, record := range records {
+		paths[record.Path] = true
+	}
+	if len(paths) != writers {
+		t.Errorf("verifyAuditLog() found %d distinct records, want %d", len(paths), writers)
+	}
+}
+
+func TestAuditLogTamperDetection(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	l, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("openAuditLog() = %v", err)
+	}
+	for _, identity := range []string{"alice", "bob", "carol"} {
+		l.append(&auditRecord{Identity: identity, Method: http.MethodPost, Path: "/pools", Status: http.StatusOK})
+	}
+	l.file.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	lines[len(lines)-1] += "\n"
+
+	for _, tt := range []struct {
+		name  string
+		lines []string
+	}{
+		{name: "modified", lines: []string{lines[0], strings.Replace(lines[1], `"bob"`, `"mallory"`, 1), lines[2]}},
+		{name: "removed", lines: []string{lines[0], lines[2]}},
+		{name: "reordered", lines: []string{lines[1], lines[0], lines[2]}},
+		{name: "truncated head", lines: []string{lines[1], lines[2]}},
+		{name: "malformed", lines: []string{lines[0], "{\n"}},
+	} {
+		tampered := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-")+".jsonl")
+		if err := os.WriteFile(tampered, []byte(strings.Join(tt.lines, "")), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readAuditLog(t, tampered); err == nil {
+			t.Errorf("verifyAuditLog(%s) succeeded, want an error", tt.name)
+		}
+		if _, err := openAuditLog(tampered); err == nil {
+			t.Errorf("openAuditLog(%s) succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := openAuditLog(path)
+	if err != nil {
+		t.Fatalf("openAuditLog() = %v", err)
+	}
+	defer l.file.Close()
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(identityContextKey, &Identity{ID: "team-a"}) }, audit(l))
+	router.POST("/mpc_wallets/v1/pools/:poolId/mpcWallets", func(c *gin.Context) {
+		c.Set(rpcContextKey, "CreateMPCWallet")
+		c.JSON(http.StatusAccepted, gin.H{"name": "operations/op-1"})
+	})
+	router.POST("/pools/v1/pools", func(c *gin.Context) {
+		abortWithCode(c, codes.InvalidArgument, "pool.display_name is required")
+	})
+	router.GET("/pools/v1/pools", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	body := `{"deviceGroup": "pools/pool-1/deviceGroups/g"}`
+	for _, r := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/mpc_wallets/v1/pools/pool-1/mpcWallets?wait=true", strings.NewReader(body)),
+		httptest.NewRequest(http.MethodPost, "/pools/v1/pools?poolId=pool-2", strings.NewReader("{}")),
+		httptest.NewRequest(http.MethodGet, "/pools/v1/pools", nil),
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+	}
+
+	records, err := readAuditLog(t, path)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("verifyAuditLog() = %d records, %v, want the two POST requests", len(records), err)
+	}
+
+	sum := sha256.Sum256([]byte(body))
+	want := auditRecord{
+		Seq:         1,
+		Identity:    "team-a",
+		Method:      http.MethodPost,
+		Route:       "/mpc_wallets/v1/pools/:poolId/mpcWallets",
+		RPC:         "CreateMPCWallet",
+		Path:        "/mpc_wallets/v1/pools/pool-1/mpcWallets",
+		Query:       "wait=true",
+		Resource:    "pools/pool-1",
+		RequestHash: "sha256:" + hex.EncodeToString(sum[:]),
+		Status:      http.StatusAccepted,
+		Outcome:     "OK",
+		Result:      "operations/op-1",
+	}
+	got := *records[0]
+	got.Time, got.PrevHash, got.Hash = want.Time, "", ""
+	if got != want {
+		t.Errorf("audit record = %+v, want %+v", got, want)
+	}
+	// CreatePool names the pool it creates in its query.
+	if rejected := records[1]; rejected.Status != http.StatusBadRequest || rejected.Outcome != "INVALID_ARGUMENT" || rejected.Result != "" || rejected.Resource != "pools/pool-2" {
+		t.Errorf("audit record of a rejected request = %+v, want INVALID_ARGUMENT for pools/pool-2", rejected)
+	}
+}
+
+func TestAuditOutcome(t *testing.T) {
+	for _, tt := range []struct {
+		status      int
+		body        string
+		wantOutcome string
+		wantResult  string
+	}{
+		{status: http.StatusOK, body: `{"name": "pools/p"}`, wantOutcome: "OK", wantResult: "pools/p"},
+		{status: http.StatusOK, body: `[]`, wantOutcome: "OK"},
+		{status: http.StatusNotFound, body: `{"code": "NOT_FOUND"}`, wantOutcome: "NOT_FOUND"},
+		{status: http.StatusBadGateway, body: `bad gateway`, wantOutcome: "Bad Gateway"},
+	} {
+		outcome, result := auditOutcome(tt.status, []byte(tt.body))
+		if outcome != tt.wantOutcome || result != tt.wantResult {
+			t.Errorf("auditOutcome(%d, %s) = %q, %q, want %q, %q", tt.status, tt.body, outcome, result, tt.wantOutcome, tt.wantResult)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+)
+
+// Scopes granted to proxy API keys and required by the routes.
+const (
+	scopeAll                   = "*"
+	scopeBlockchainRead        = "blockchain:read"
+	scopeDevicesCreate         = "devices:create"
+	scopeKeysRead              = "keys:read"
+	scopeKeysCreate            = "keys:create"
+	scopeSignaturesCreate      = "signatures:create"
+	scopeTransactionsRead      = "transactions:read"
+	scopeTransactionsCreate    = "transactions:create"
+	scopeTransactionsConstruct = "transactions:construct"
+	scopeTransactionsBroadcast = "transactions:broadcast"
+	scopeWalletsRead           = "wallets:read"
+	scopeWalletsCreate         = "wallets:create"
+	scopePoolsRead             = "pools:read"
+	scopePoolsAdmin            = "pools:admin"
+	scopeOperationsRead        = "operations:read"
+	scopeApprovalsRead         = "approvals:read"
+	scopeApprovalsReview       = "approvals:review"
+	scopeMetricsRead           = "metrics:read"
+)
+
+// identityContextKey is the gin context key holding the caller's *Identity.
+const identityContextKey = "identity"
+
+var (
+	// authKeysFile is the JSON file holding the hashed proxy API keys.
+	authKeysFile = flag.String("auth-keys", "", "path to the JSON file of hashed proxy API keys")
+
+	// authDisabled turns off caller authentication, for local development only.
+	authDisabled = flag.Bool("auth-disabled", false, "serve requests without authenticating callers (local development only)")
+)
+
+// Identity is an authenticated proxy caller.
+type Identity struct {
+	ID     string
+	Scopes []string
+
+	// Pools and MPCWallets are glob patterns of the Pool and MPCWallet IDs the caller
+	// may act on. A nil list is unrestricted.
+	Pools      []string
+	MPCWallets []string
+}
+
+// HasScope reports whether the identity was granted scope, either directly, through
+// a resource wildcard such as "wallets:*", or through the "*" scope.
+func (i *Identity) HasScope(scope string) bool {
+	resource, _, _ := strings.Cut(scope, ":")
+	for _, granted := range i.Scopes {
+		if granted == scope || granted == scopeAll || granted == resource+":*" {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKeyConfig is a single entry of the API keys file. Callers authenticate with the key
+// matching Hash, or over mutual TLS with a client certificate whose subject common name
+// or a subject alternative name equals ClientCert.
+type apiKeyConfig struct {
+	ID         string   `json:"id"`
+	Hash       string   `json:"hash"`
+	ClientCert string   `json:"clientCert"`
+	Scopes     []string `json:"scopes"`
+	Pools      []string `json:"pools"`
+	MPCWallets []string `json:"mpcWallets"`
+}
+
+// apiKeysFile is the API keys file, holding SHA-256 hashes of the keys rather than the keys themselves.
+type apiKeysFile struct {
+	Keys []apiKeyConfig `json:"keys"`
+}
+
+// keyStore resolves presented API keys and client certificates to identities.
+type keyStore struct {
+	byHash       map[string]*Identity
+	byClientCert map[string]*Identity
+}
+
+// loadKeyStore reads the API keys file at path.
+func loadKeyStore(path string) (*keyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read API keys file: %v", err)
+	}
+
+	var file apiKeysFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("cannot parse API keys file %q: %v", path, err)
+	}
+
+	store := &keyStore{byHash: map[string]*Identity{}, byClientCert: map[string]*Identity{}}
+	for _, key := range file.Keys {
+		if key.ID == "" {
+			return nil, errors.New("API key with empty id")
+		}
+		if key.Hash == "" && key.ClientCert == "" {
+			return nil, fmt.Errorf("API key %q: either hash or clientCert must be set", key.ID)
+		}
+
+		hash := strings.ToLower(strings.TrimPrefix(key.Hash, "sha256:"))
+		if key.Hash != "" {
+			if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+				return nil, fmt.Errorf("API key %q: hash must be a hex-encoded SHA-256 digest", key.ID)
+			}
+			if _, ok := store.byHash[hash]; ok {
+				return nil, fmt.Errorf("API key %q: duplicate hash", key.ID)
+			}
+		}
+		if _, ok := store.byClientCert[key.ClientCert]; ok && key.ClientCert != "" {
+			return nil, fmt.Errorf("API key %q: duplicate clientCert", key.ID)
+		}
+
+		if err := validateGrant(key.Pools); err != nil {
+			return nil, fmt.Errorf("API key %q: pools: %v", key.ID, err)
+		}
+		if err := validateGrant(key.MPCWallets); err != nil {
+			return nil, fmt.Errorf("API key %q: mpcWallets: %v", key.ID, err)
+		}
+
+		identity := &Identity{ID: key.ID, Scopes: key.Scopes, Pools: key.Pools, MPCWallets: key.MPCWallets}
+		if key.Hash != "" {
+			store.byHash[hash] = identity
+		}
+		if key.ClientCert != "" {
+			store.byClientCert[key.ClientCert] = identity
+		}
+	}
+
+	return store, nil
+}
+
+// lookup returns the identity owning key, or nil.
+func (s *keyStore) lookup(key string) *Identity {
+	sum := sha256.Sum256([]byte(key))
+	return s.byHash[hex.EncodeToString(sum[:])]
+}
+
+// lookupClientCert returns the identity owning the verified client certificate of the
+// connection, or nil.
+func (s *keyStore) lookupClientCert(state *tls.ConnectionState) *Identity {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	cert := state.VerifiedChains[0][0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	for _, name := range names {
+		if identity, ok := s.byClientCert[name]; ok && name != "" {
+			return identity
+		}
+	}
+	return nil
+}
+
+// authenticate returns middleware that resolves the caller's API key, sent as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>", to an Identity. Callers sending
+// no key are identified by their verified TLS client certificate, if any. A nil store
+// disables authentication and treats every caller as an anonymous identity with all scopes.
+func authenticate(store *keyStore) gin.HandlerFunc {
+	anonymous := &Identity{ID: "anonymous", Scopes: []string{scopeAll}}
+
+	return func(c *gin.Context) {
+		if store == nil {
+			c.Set(identityContextKey, anonymous)
+			return
+		}
+
+		key := c.GetHeader("X-API-Key")
+		if authorization := c.GetHeader("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+			key = strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+		}
+		if key == "" {
+			if identity := store.lookupClientCert(c.Request.TLS); identity != nil {
+				c.Set(identityContextKey, identity)
+				return
+			}
+			abortWithCode(c, codes.Unauthenticated, "missing API key")
+			return
+		}
+
+		identity := store.lookup(key)
+		if identity == nil {
+			abortWithCode(c, codes.Unauthenticated, "invalid API key")
+			return
+		}
+
+		c.Set(identityContextKey, identity)
+	}
+}
+
+// requireScope returns middleware rejecting callers whose identity lacks scope.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := identityFromContext(c)
+		if identity == nil || !identity.HasScope(scope) {
+			abortWithCode(c, codes.PermissionDenied, fmt.Sprintf("API key lacks scope %q", scope))
+			return
+		}
+	}
+}
+
+// identityFromContext returns the caller's identity, or nil if the request is unauthenticated.
+func identityFromContext(c *gin.Context) *Identity {
+	value, ok := c.Get(identityContextKey)
+	if !ok {
+		return nil
+	}
+	identity, _ := value.(*Identity)
+	return identity
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hashKey returns the hex-encoded SHA-256 digest of key, as stored in the API keys file.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestHasScope(t *testing.T) {
+	for _, tt := range []struct {
+		granted []string
+		scope   string
+		want    bool
+	}{
+		{granted: []string{scopeWalletsRead}, scope: scopeWalletsRead, want: true},
+		{granted: []string{scopeWalletsRead}, scope: scopeWalletsCreate},
+		{granted: []string{"wallets:*"}, scope: scopeWalletsCreate, want: true},
+		{granted: []string{"wallets:*"}, scope: scopePoolsRead},
+		{granted: []string{"transactions:*"}, scope: scopeTransactionsBroadcast, want: true},
+		{granted: []string{scopeAll}, scope: scopePoolsAdmin, want: true},
+		{granted: []string{"wallets"}, scope: scopeWalletsRead},
+		{granted: []string{"*:read"}, scope: scopeWalletsRead},
+		{scope: scopeBlockchainRead},
+	} {
+		identity := &Identity{ID: "caller", Scopes: tt.granted}
+		if got := identity.HasScope(tt.scope); got != tt.want {
+			t.Errorf("HasScope(%v, %s) = %v, want %v", tt.granted, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestLoadKeyStore(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "keys.json", `{"keys": [
+		{"id": "reader", "hash": "`+hashKey("reader-key")+`", "scopes": ["pools:read"]},
+		{"id": "admin", "hash": "sha256:`+strings.ToUpper(hashKey("admin-key"))+`", "scopes": ["*"]}
+	]}`)
+
+	store, err := loadKeyStore(path)
+	if err != nil {
+		t.Fatalf("loadKeyStore() = %v", err)
+	}
+	if identity := store.lookup("reader-key"); identity == nil || identity.ID != "reader" {
+		t.Errorf("lookup(reader-key) = %+v, want reader", identity)
+	}
+	if identity := store.lookup("admin-key"); identity == nil || identity.ID != "admin" {
+		t.Errorf("lookup(admin-key) = %+v, want admin", identity)
+	}
+	for _, key := range []string{"", "wrong-key", hashKey("reader-key")} {
+		if identity := store.lookup(key); identity != nil {
+			t.Errorf("lookup(%q) = %+v, want no identity", key, identity)
+		}
+	}
+}
+
+func TestLoadKeyStoreErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"malformed.json": `{"keys": [`,
+		"no-id.json":     `{"keys": [{"hash": "` + hashKey("key") + `"}]}`,
+		"short.json":     `{"keys": [{"id": "a", "hash": "abcd"}]}`,
+		"plain.json":     `{"keys": [{"id": "a", "hash": "not-a-hash"}]}`,
+		"duplicate.json": `{"keys": [{"id": "a", "hash": "` + hashKey("key") + `"}, {"id": "b", "hash": "` + hashKey("key") + `"}]}`,
+	} {
+		if _, err := loadKeyStore(writeTestFile(t, dir, name, contents)); err == nil {
+			t.Errorf("loadKeyStore(%s) succeeded, want an error", name)
+		}
+	}
+	if _, err := loadKeyStore(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("loadKeyStore() of a missing file succeeded, want an error")
+	}
+}
+
+func TestAuthenticateMiddleware(t *testing.T) {
+	store := &keyStore{byHash: map[string]*Identity{
+		hashKey("reader-key"): {ID: "reader", Scopes: []string{scopePoolsRead}},
+		hashKey("wallet-key"): {ID: "wallets", Scopes: []string{"wallets:*"}},
+	}}
+
+	router := gin.New()
+	router.Use(authenticate(store))
+	router.GET("/pools", requireScope(scopePoolsRead), func(c *gin.Context) { c.String(http.StatusOK, identityFromContext(c).ID) })
+	router.POST("/wallets", requireScope(scopeWalletsCreate), func(c *gin.Context) { c.String(http.StatusOK, identityFromContext(c).ID) })
+
+	for _, tt := range []struct {
+		name       string
+		method     string
+		path       string
+		header     string
+		value      string
+		wantStatus int
+	}{
+		{name: "bearer key", method: http.MethodGet, path: "/pools", header: "Authorization", value: "Bearer reader-key", wantStatus: http.StatusOK},
+		{name: "X-API-Key", method: http.MethodGet, path: "/pools", header: "X-API-Key", value: "reader-key", wantStatus: http.StatusOK},
+		{name: "missing key", method: http.MethodGet, path: "/pools", wantStatus: http.StatusUnauthorized},
+		{name: "wrong key", method: http.MethodGet, path: "/pools", header: "Authorization", value: "Bearer wrong-key", wantStatus: http.StatusUnauthorized},
+		{name: "hash as key", method: http.MethodGet, path: "/pools", header: "X-API-Key", value: hashKey("reader-key"), wantStatus: http.StatusUnauthorized},
+		{name: "basic auth", method: http.MethodGet, path: "/pools", header: "Authorization", value: "Basic cmVhZGVyLWtleQ==", wantStatus: http.StatusUnauthorized},
+		{name: "missing scope", method: http.MethodPost, path: "/wallets", header: "X-API-Key", value: "reader-key", wantStatus: http.StatusForbidden},
+		{name: "wildcard scope", method: http.MethodPost, path: "/wallets", header: "X-API-Key", value: "wallet-key", wantStatus: http.StatusOK},
+		{name: "other resource", method: http.MethodGet, path: "/pools", header: "X-API-Key", value: "wallet-key", wantStatus: http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set(tt.header, tt.value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: %s %s = %d %s, want %d", tt.name, tt.method, tt.path, w.Code, w.Body, tt.wantStatus)
+		}
+	}
+
+	// Without a key store every caller is an anonymous identity holding every scope.
+	router = gin.New()
+	router.Use(authenticate(nil))
+	router.POST("/wallets", requireScope(scopeWalletsCreate), func(c *gin.Context) { c.String(http.StatusOK, identityFromContext(c).ID) })
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/wallets", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "anonymous" {
+		t.Errorf("POST /wallets without authentication = %d %s, want the anonymous identity", w.Code, w.Body)
+	}
+}
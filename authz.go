@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// grantAllows reports whether id matches one of the glob patterns in grant, such as
+// "team-a-*". A nil grant is unrestricted.
+func grantAllows(grant []string, id string) bool {
+	if grant == nil {
+		return true
+	}
+	for _, pattern := range grant {
+		if ok, err := path.Match(pattern, id); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateGrant rejects malformed glob patterns.
+func validateGrant(grant []string) error {
+	for _, pattern := range grant {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Restricted reports whether the identity is limited to specific pools or wallets.
+func (i *Identity) Restricted() bool {
+	return i.Pools != nil || i.MPCWallets != nil
+}
+
+// CanAccessPool reports whether the identity may act on the Pool with the given ID.
+func (i *Identity) CanAccessPool(poolID string) bool {
+	return grantAllows(i.Pools, poolID)
+}
+
+// CanAccessPoolResources reports whether the identity may act on the Pool with the given
+// ID as a whole: its device groups, keys and signatures, and creating wallets in it.
+// Identities restricted to MPCWallets may only act on those wallets.
+func (i *Identity) CanAccessPoolResources(poolID string) bool {
+	return i.MPCWallets == nil && i.CanAccessPool(poolID)
+}
+
+// CanAccessWallet reports whether the identity may act on the MPCWallet with the given
+// ID in the Pool with the given ID. Wallet grants match "{pool_id}/{mpc_wallet_id}".
+func (i *Identity) CanAccessWallet(poolID, walletID string) bool {
+	return i.CanAccessPool(poolID) && grantAllows(i.MPCWallets, poolID+"/"+walletID)
+}
+
+// CanAccessResource reports whether the identity may act on the named resource, e.g.
+// "pools/{pool_id}/mpcWallets/{mpc_wallet_id}". Names outside of a Pool are always allowed.
+func (i *Identity) CanAccessResource(name string) bool {
+	segments := strings.Split(name, "/")
+	if len(segments) < 2 || segments[0] != "pools" {
+		return true
+	}
+	if len(segments) >= 4 && segments[2] == "mpcWallets" {
+		return i.CanAccessWallet(segments[1], segments[3])
+	}
+	return i.CanAccessPoolResources(segments[1])
+}
+
+// walletListingRoutes are the routes naming a Pool but no MPCWallet that identities
+// restricted to MPCWallets may call, as their results are filtered to the granted wallets.
+var walletListingRoutes = map[string]bool{
+	http.MethodGet + " /mpc_wallets/v1/pools/:poolId/mpcWallets": true,
+}
+
+// authorizeResources returns middleware rejecting requests whose :poolId or :mpcWalletId
+// path parameters fall outside the caller's grant. Callers restricted to MPCWallets may
+// only reach the routes of those wallets and walletListingRoutes.
+func authorizeResources() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity := identityFromContext(c)
+		if identity == nil || !identity.Restricted() {
+			return
+		}
+
+		poolID := c.Param("poolId")
+		walletID := c.Param("mpcWalletId")
+		switch {
+		case walletID != "":
+			if !identity.CanAccessWallet(poolID, walletID) {
+				abortWithCode(c, codes.PermissionDenied, fmt.Sprintf("API key is not granted access to pools/%s/mpcWallets/%s", poolID, walletID))
+			}
+		case walletListingRoutes[c.Request.Method+" "+c.FullPath()]:
+			if !identity.CanAccessPool(poolID) {
+				abortWithCode(c, codes.PermissionDenied, fmt.Sprintf("API key is not granted access to pools/%s", poolID))
+			}
+		case poolID != "" && !identity.CanAccessPoolResources(poolID):
+			abortWithCode(c, codes.PermissionDenied, fmt.Sprintf("API key is not granted access to pools/%s", poolID))
+		}
+	}
+}
+
+// authorizeResource writes a PERMISSION_DENIED error and returns false if the caller
+// may not act on the named resource.
+func authorizeResource(c *gin.Context, name string) bool {
+	identity := identityFromContext(c)
+	if identity != nil && identity.CanAccessResource(name) {
+		return true
+	}
+
+	abortWithCode(c, codes.PermissionDenied, fmt.Sprintf("API key is not granted access to %s", name))
+	return false
+}
+
+// filterAuthorized drops the items whose resource name, as returned by name, the caller
+// may not act on.
+func filterAuthorized[T any](c *gin.Context, items []T, name func(T) string) []T {
+	identity := identityFromContext(c)
+	if identity == nil || !identity.Restricted() {
+		return items
+	}
+
+	filtered := items[:0]
+	for _, item := range items {
+		if identity.CanAccessResource(name(item)) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+// authorizedIterator is an itemIterator skipping the items the caller may not act on.
+type authorizedIterator[T proto.Message] struct {
+	iter     itemIterator[T]
+	identity *Identity
+	name     func(T) string
+}
+
+// filterAuthorizedIterator wraps iter to skip the items whose resource name, as returned
+// by name, the caller may not act on.
+func filterAuthorizedIterator[T proto.Message](c *gin.Context, iter itemIterator[T], name func(T) string) itemIterator[T] {
+	identity := identityFromContext(c)
+	if identity == nil || !identity.Restricted() {
+		return iter
+	}
+	return &authorizedIterator[T]{iter: iter, identity: identity, name: name}
+}
+
+// Next returns the next item the caller may act on.
+func (a *authorizedIterator[T]) Next() (T, error) {
+	for {
+		item, err := a.iter.Next()
+		if err != nil || a.identity.CanAccessResource(a.name(item)) {
+			return item, err
+		}
+	}
+}
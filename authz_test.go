@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+)
+
+func TestCanAccessResource(t *testing.T) {
+	poolGrant := &Identity{ID: "team-a", Pools: []string{"team-a-*"}}
+	walletGrant := &Identity{ID: "team-a-hot", Pools: []string{"team-a-*"}, MPCWallets: []string{"*/hot-*", "team-a-1/cold"}}
+	walletOnly := &Identity{ID: "wallet-1", MPCWallets: []string{"pool-1/wallet-1"}}
+
+	for _, tt := range []struct {
+		identity *Identity
+		name     string
+		want     bool
+	}{
+		{identity: poolGrant, name: "pools/team-a-1", want: true},
+		{identity: poolGrant, name: "pools/team-b-1"},
+		{identity: poolGrant, name: "pools/team-a-1/deviceGroups/group/mpcKeys/key", want: true},
+		{identity: poolGrant, name: "pools/team-b-1/deviceGroups/group"},
+		{identity: poolGrant, name: "pools/team-a-1/mpcWallets/any", want: true},
+		{identity: walletGrant, name: "pools/team-a-1/mpcWallets/hot-1", want: true},
+		{identity: walletGrant, name: "pools/team-a-2/mpcWallets/hot-1", want: true},
+		{identity: walletGrant, name: "pools/team-a-1/mpcWallets/cold/mpcTransactions/tx", want: true},
+		{identity: walletGrant, name: "pools/team-a-2/mpcWallets/cold"},
+		{identity: walletGrant, name: "pools/team-a-1/mpcWallets/warm"},
+		{identity: walletGrant, name: "pools/team-b-1/mpcWallets/hot-1"},
+		{identity: walletGrant, name: "pools/team-a-1"},
+		{identity: walletGrant, name: "pools/team-a-1/deviceGroups/group/mpcKeys/key"},
+		{identity: walletOnly, name: "pools/pool-1/mpcWallets/wallet-1", want: true},
+		{identity: walletOnly, name: "pools/pool-2/mpcWallets/wallet-1"},
+		{identity: walletOnly, name: "pools/pool-1"},
+		{identity: walletOnly, name: "pools/pool-1/deviceGroups/group-1"},
+		{identity: walletOnly, name: "networks/ethereum-goerli", want: true},
+		{identity: walletOnly, name: "devices/device-1", want: true},
+	} {
+		if got := tt.identity.CanAccessResource(tt.name); got != tt.want {
+			t.Errorf("%s: CanAccessResource(%s) = %v, want %v", tt.identity.ID, tt.name, got, tt.want)
+		}
+	}
+
+	unrestricted := &Identity{ID: "admin"}
+	if unrestricted.Restricted() || !unrestricted.CanAccessResource("pools/any/mpcWallets/any") {
+		t.Error("an identity without grants is restricted, want unrestricted")
+	}
+	if empty := (&Identity{ID: "none", Pools: []string{}}); !empty.Restricted() || empty.CanAccessPool("any") {
+		t.Error("an identity with an empty pools grant can access a pool, want none")
+	}
+}
+
+func TestLoadKeyStoreGrants(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := loadKeyStore(writeTestFile(t, dir, "keys.json", `{"keys": [
+		{"id": "team-a", "hash": "`+hashKey("team-a-key")+`", "scopes": ["*"], "pools": ["team-a-*"], "mpcWallets": ["*/hot-*"]}
+	]}`))
+	if err != nil {
+		t.Fatalf("loadKeyStore() = %v", err)
+	}
+	if identity := store.lookup("team-a-key"); identity == nil || !identity.CanAccessWallet("team-a-1", "hot-1") || identity.CanAccessPool("team-b") {
+		t.Errorf("lookup(team-a-key) = %+v, want the team-a grant", identity)
+	}
+
+	if _, err := loadKeyStore(writeTestFile(t, dir, "bad.json", `{"keys": [{"id": "bad", "hash": "`+hashKey("bad-key")+`", "pools": ["[team"]}]}`)); err == nil {
+		t.Error("loadKeyStore() with a malformed pool pattern succeeded, want an error")
+	}
+}
+
+// newGrantRouter returns a router authenticating identity and serving pool and wallet
+// routes behind authorizeResources.
+func newGrantRouter(identity *Identity) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(identityContextKey, identity) }, authorizeResources())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/pools/v1/pools/:poolId", ok)
+	router.POST("/mpc_keys/v1/pools/:poolId/deviceGroups", ok)
+	router.GET("/mpc_wallets/v1/pools/:poolId/mpcWallets", ok)
+	router.POST("/mpc_wallets/v1/pools/:poolId/mpcWallets", ok)
+	router.GET("/mpc_wallets/v1/pools/:poolId/mpcWallets/:mpcWalletId", ok)
+	router.GET("/blockchain/v1/networks/:networkId", ok)
+	return router
+}
+
+func TestAuthorizeResources(t *testing.T) {
+	walletGrant := newGrantRouter(&Identity{ID: "team-a-hot", Pools: []string{"team-a"}, MPCWallets: []string{"team-a/hot"}})
+	poolGrant := newGrantRouter(&Identity{ID: "team-a", Pools: []string{"team-a"}})
+	walletOnly := newGrantRouter(&Identity{ID: "wallet-1", MPCWallets: []string{"pool-1/wallet-1"}})
+
+	for _, tt := range []struct {
+		router     *gin.Engine
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{router: poolGrant, path: "/pools/v1/pools/team-a", wantStatus: http.StatusOK},
+		{router: poolGrant, path: "/pools/v1/pools/team-b", wantStatus: http.StatusForbidden},
+		{router: poolGrant, method: http.MethodPost, path: "/mpc_keys/v1/pools/team-a/deviceGroups", wantStatus: http.StatusOK},
+		{router: poolGrant, method: http.MethodPost, path: "/mpc_wallets/v1/pools/team-a/mpcWallets", wantStatus: http.StatusOK},
+		{router: walletGrant, path: "/mpc_wallets/v1/pools/team-a/mpcWallets/hot", wantStatus: http.StatusOK},
+		{router: walletGrant, path: "/mpc_wallets/v1/pools/team-a/mpcWallets/cold", wantStatus: http.StatusForbidden},
+		{router: walletGrant, path: "/mpc_wallets/v1/pools/team-b/mpcWallets/hot", wantStatus: http.StatusForbidden},
+		{router: walletGrant, path: "/mpc_wallets/v1/pools/team-a/mpcWallets", wantStatus: http.StatusOK},
+		{router: walletGrant, path: "/pools/v1/pools/team-a", wantStatus: http.StatusForbidden},
+		// Callers restricted to wallets cannot act on the pool as a whole.
+		{router: walletOnly, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets/wallet-1", wantStatus: http.StatusOK},
+		{router: walletOnly, path: "/mpc_wallets/v1/pools/pool-2/mpcWallets/wallet-1", wantStatus: http.StatusForbidden},
+		{router: walletOnly, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets", wantStatus: http.StatusOK},
+		{router: walletOnly, method: http.MethodPost, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets", wantStatus: http.StatusForbidden},
+		{router: walletOnly, method: http.MethodPost, path: "/mpc_keys/v1/pools/pool-1/deviceGroups", wantStatus: http.StatusForbidden},
+		{router: walletOnly, path: "/pools/v1/pools/pool-1", wantStatus: http.StatusForbidden},
+		{router: walletOnly, path: "/blockchain/v1/networks/ethereum-goerli", wantStatus: http.StatusOK},
+	} {
+		method := tt.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		w := httptest.NewRecorder()
+		tt.router.ServeHTTP(w, httptest.NewRequest(method, tt.path, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s %s = %d %s, want %d", method, tt.path, w.Code, w.Body, tt.wantStatus)
+		}
+	}
+}
+
+func TestFilterAuthorized(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(identityContextKey, &Identity{ID: "team-a", Pools: []string{"team-a-*"}})
+
+	items := []*pools.Pool{{Name: "pools/team-a-1"}, {Name: "pools/team-b-1"}, {Name: "pools/team-a-2"}}
+	filtered := filterAuthorized(c, items, (*pools.Pool).GetName)
+	if len(filtered) != 2 || filtered[0].GetName() != "pools/team-a-1" || filtered[1].GetName() != "pools/team-a-2" {
+		t.Errorf("filterAuthorized() = %v, want the team-a pools", filtered)
+	}
+
+	iter := filterAuthorizedIterator[*pools.Pool](c, &poolIterator{names: []string{"pools/team-b-1", "pools/team-a-1", "pools/team-b-2"}}, (*pools.Pool).GetName)
+	var names []string
+	for {
+		pool, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() = %v", err)
+		}
+		names = append(names, pool.GetName())
+	}
+	if len(names) != 1 || names[0] != "pools/team-a-1" {
+		t.Errorf("filterAuthorizedIterator() yielded %v, want pools/team-a-1", names)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/codes"
+)
+
+var (
+	// breakerFailureThreshold is the number of consecutive failed attempts opening a breaker.
+	breakerFailureThreshold = flag.Int("breaker-failure-threshold", 5, "consecutive failed upstream attempts opening a WaaS service's circuit breaker; 0 disables the breakers")
+
+	// breakerOpenTimeout is how long an open breaker fails calls fast before probing again.
+	breakerOpenTimeout = flag.Duration("breaker-open-timeout", 30*time.Second, "how long an open circuit breaker fails calls before letting a probe through")
+
+	// upstreamMaxConcurrency is the default number of concurrent calls to each WaaS service.
+	upstreamMaxConcurrency = flag.Int("upstream-max-concurrency", 64, "concurrent calls allowed to each WaaS service, beyond which calls fail fast; 0 is unlimited")
+
+	// upstreamConcurrencyFlag overrides upstreamMaxConcurrency for individual services.
+	upstreamConcurrencyFlag = flag.String("upstream-concurrency", "", "comma-separated per-service limits overriding -upstream-max-concurrency, e.g. mpc_transaction=16,blockchain=128")
+)
+
+// upstreamConcurrency holds the parsed -upstream-concurrency overrides.
+var upstreamConcurrency map[string]int
+
+var (
+	upstreamRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_rejections_total",
+		Help:      "WaaS calls failed fast by the proxy, by service and reason.",
+	}, []string{"service", "reason"})
+
+	upstreamBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_breaker_state",
+		Help:      "State of the circuit breaker of each WaaS service: 0 closed, 1 open, 2 half-open.",
+	}, []string{"service"})
+)
+
+// Reasons of calls failed fast by the proxy, reported in their ErrorInfo.
+const (
+	reasonCircuitOpen      = "CIRCUIT_OPEN"
+	reasonConcurrencyLimit = "CONCURRENCY_LIMIT"
+)
+
+// serviceLabel returns the short name of a WaaS service, e.g. "mpc_transaction" for
+// "waas_mpc_transaction_service".
+func serviceLabel(service string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(service, "waas_"), "_service")
+}
+
+// parseUpstreamConcurrency parses a comma-separated list of service=limit pairs.
+func parseUpstreamConcurrency(value string) (map[string]int, error) {
+	limits := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		service, limit, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("upstream concurrency %q must have the form service=limit", pair)
+		}
+		switch service {
+		case serviceLabel(blockchainService), serviceLabel(mpcKeyService), serviceLabel(mpcTransactionService),
+			serviceLabel(mpcWalletService), serviceLabel(poolService), serviceLabel(protocolService):
+		default:
+			return nil, fmt.Errorf("upstream concurrency %q: unknown service %q", pair, service)
+		}
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("upstream concurrency %q must be a non-negative integer", pair)
+		}
+		limits[service] = n
+	}
+	return limits, nil
+}
+
+// serviceGuard isolates the calls to one WaaS service: its bulkhead caps their
+// concurrency and its breaker fails them fast while the service is unhealthy.
+type serviceGuard struct {
+	label    string
+	breaker  *circuitBreaker
+	bulkhead chan struct{}
+}
+
+// newServiceGuard returns the guard of the named WaaS service, configured by the flags.
+func newServiceGuard(service string) *serviceGuard {
+	label := serviceLabel(service)
+	guard := &serviceGuard{
+		label:   label,
+		breaker: &circuitBreaker{service: label, threshold: *breakerFailureThreshold, openTimeout: *breakerOpenTimeout},
+	}
+	upstreamBreakerState.WithLabelValues(label).Set(float64(breakerClosed))
+
+	limit := *upstreamMaxConcurrency
+	if n, ok := upstreamConcurrency[label]; ok {
+		limit = n
+	}
+	if limit > 0 {
+		guard.bulkhead = make(chan struct{}, limit)
+	}
+	return guard
+}
+
+// acquire takes a slot of the bulkhead and admits the call through the breaker. If the
+// call is refused, it returns the 503 response to fail it with instead.
+func (g *serviceGuard) acquire(req *http.Request) *http.Response {
+	if g.bulkhead != nil {
+		select {
+		case g.bulkhead <- struct{}{}:
+		default:
+			upstreamRejections.WithLabelValues(g.label, reasonConcurrencyLimit).Inc()
+			return unavailableResponse(req, reasonConcurrencyLimit, g.label, fmt.Sprintf("too many concurrent calls to the %s service", g.label), 0)
+		}
+	}
+
+	if ok, retryAfter := g.breaker.allow(); !ok {
+		g.release()
+		upstreamRejections.WithLabelValues(g.label, reasonCircuitOpen).Inc()
+		return unavailableResponse(req, reasonCircuitOpen, g.label, fmt.Sprintf("the %s service is unavailable", g.label), retryAfter)
+	}
+	return nil
+}
+
+// release frees the bulkhead slot taken by acquire.
+func (g *serviceGuard) release() {
+	if g.bulkhead != nil {
+		<-g.bulkhead
+	}
+}
+
+// unavailableResponse returns a 503 UNAVAILABLE response in the WaaS REST error format,
+// so that the client surfaces it like any upstream error.
+func unavailableResponse(req *http.Request, reason, service, message string, retryAfter time.Duration) *http.Response {
+	details := []map[string]interface{}{{
+		"@type":    "type.googleapis.com/google.rpc.ErrorInfo",
+		"reason":   reason,
+		"domain":   errorDomain,
+		"metadata": map[string]string{"service": service},
+	}}
+	if retryAfter > 0 {
+		details = append(details, map[string]interface{}{
+			"@type":      "type.googleapis.com/google.rpc.RetryInfo",
+			"retryDelay": strconv.FormatFloat(retryAfter.Seconds(), 'f', 3, 64) + "s",
+		})
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    http.StatusServiceUnavailable,
+			"message": message,
+			"status":  codeName(codes.Unavailable),
+			"details": details,
+		},
+	})
+	return &http.Response{
+		Status:        "503 Service Unavailable",
+		StatusCode:    http.StatusServiceUnavailable,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// attemptOutcome classifies the outcome of an upstream attempt for the breaker.
+type attemptOutcome int
+
+const (
+	attemptSucceeded attemptOutcome = iota
+	attemptFailed
+	// attemptIgnored is an attempt telling nothing about the service's health, such as
+	// one cancelled by the caller.
+	attemptIgnored
+)
+
+// classifyAttempt returns the outcome of an attempt of a call made with ctx. Server
+// errors and connection failures count against the service; client errors do not.
+func classifyAttempt(ctx context.Context, resp *http.Response, err error) attemptOutcome {
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.Canceled):
+		return attemptIgnored
+	case err != nil:
+		return attemptFailed
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return attemptFailed
+	}
+	return attemptSucceeded
+}
+
+// breakerState is the state of a circuit breaker.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker opens after threshold consecutive failed attempts, failing calls fast
+// for openTimeout. It then lets a single probe through, closing again if it succeeds.
+type circuitBreaker struct {
+	service     string
+	threshold   int
+	openTimeout time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// allow reports whether a call may proceed and, if not, how long until the breaker lets
+// a probe through.
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	if b.threshold <= 0 {
+		return true, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if wait := b.openTimeout - time.Since(b.openedAt); wait > 0 {
+			return false, wait
+		}
+		b.setState(breakerHalfOpen)
+		b.probing = true
+	case breakerHalfOpen:
+		if b.probing {
+			return false, 0
+		}
+		b.probing = true
+	}
+	return true, 0
+}
+
+// closed reports whether calls are flowing normally, so that failed ones may be retried.
+func (b *circuitBreaker) closed() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerClosed
+}
+
+// record updates the breaker with the outcome of an attempt.
+func (b *circuitBreaker) record(outcome attemptOutcome) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch outcome {
+	case attemptSucceeded:
+		b.setState(breakerClosed)
+		b.failures = 0
+		b.probing = false
+	case attemptFailed:
+		switch b.state {
+		case breakerClosed:
+			if b.failures++; b.failures >= b.threshold {
+				b.setState(breakerOpen)
+			}
+		case breakerHalfOpen:
+			b.setState(breakerOpen)
+			b.probing = false
+		}
+	case attemptIgnored:
+		b.probing = false
+	}
+}
+
+// setState moves the breaker to state. The caller must hold b.mu.
+func (b *circuitBreaker) setState(state breakerState) {
+	if state == breakerOpen {
+		b.openedAt = time.Now()
+	}
+	b.state = state
+	upstreamBreakerState.WithLabelValues(b.service).Set(float64(state))
+}
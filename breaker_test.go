@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	const openTimeout = time.Minute
+
+	// step is an allow call, an elapsed open timeout, or the recorded outcome of an attempt.
+	type step struct {
+		allow     bool
+		wantAllow bool
+		elapse    bool
+		record    attemptOutcome
+
+		checkState bool
+		wantState  breakerState
+	}
+	allowed := step{allow: true, wantAllow: true}
+	refused := step{allow: true}
+	elapse := step{elapse: true}
+	succeeded := step{record: attemptSucceeded}
+	failed := step{record: attemptFailed}
+	ignored := step{record: attemptIgnored}
+	state := func(s step, want breakerState) step { s.checkState, s.wantState = true, want; return s }
+
+	for _, tt := range []struct {
+		name  string
+		steps []step
+	}{
+		{name: "opens after threshold consecutive failures", steps: []step{
+			allowed, failed, allowed, state(failed, breakerClosed), allowed, state(failed, breakerOpen), refused,
+		}},
+		{name: "success resets the failure count", steps: []step{
+			failed, failed, succeeded, failed, state(failed, breakerClosed), allowed,
+		}},
+		{name: "ignored attempts do not count", steps: []step{
+			failed, failed, ignored, ignored, state(ignored, breakerClosed), allowed,
+		}},
+		{name: "closes after a successful probe", steps: []step{
+			failed, failed, state(failed, breakerOpen), refused, elapse, allowed, state(refused, breakerHalfOpen),
+			state(succeeded, breakerClosed), allowed, allowed,
+		}},
+		{name: "reopens after a failed probe", steps: []step{
+			failed, failed, failed, elapse, allowed, refused, state(failed, breakerOpen), refused, elapse, state(allowed, breakerHalfOpen),
+		}},
+		{name: "lets another probe through after an ignored one", steps: []step{
+			failed, failed, failed, elapse, allowed, refused, state(ignored, breakerHalfOpen), allowed, refused,
+		}},
+	} {
+		b := &circuitBreaker{threshold: 3, openTimeout: openTimeout}
+		for i, s := range tt.steps {
+			switch {
+			case s.allow:
+				if ok, _ := b.allow(); ok != s.wantAllow {
+					t.Errorf("%s: step %d: allow() = %v, want %v", tt.name, i, ok, s.wantAllow)
+				}
+			case s.elapse:
+				b.openedAt = b.openedAt.Add(-openTimeout)
+			default:
+				b.record(s.record)
+			}
+			if s.checkState && b.state != s.wantState {
+				t.Errorf("%s: step %d: state = %d, want %d", tt.name, i, b.state, s.wantState)
+			}
+		}
+	}
+
+	// An open breaker reports how long until it lets a probe through.
+	b := &circuitBreaker{threshold: 1, openTimeout: openTimeout}
+	b.record(attemptFailed)
+	if ok, wait := b.allow(); ok || wait <= 0 || wait > openTimeout {
+		t.Errorf("allow() of an open breaker = %v, %v, want a wait within %v", ok, wait, openTimeout)
+	}
+
+	disabled := &circuitBreaker{openTimeout: openTimeout}
+	for i := 0; i < 10; i++ {
+		disabled.record(attemptFailed)
+	}
+	if ok, _ := disabled.allow(); !ok || !disabled.closed() {
+		t.Error("a breaker with a zero threshold opened, want it disabled")
+	}
+}
+
+func TestCircuitBreakerProbeExclusivity(t *testing.T) {
+	b := &circuitBreaker{threshold: 1, openTimeout: time.Minute}
+	b.record(attemptFailed)
+	b.openedAt = b.openedAt.Add(-time.Minute)
+
+	allowed := make(chan bool, 20)
+	for i := 0; i < cap(allowed); i++ {
+		go func() {
+			ok, _ := b.allow()
+			allowed <- ok
+		}()
+	}
+	probes := 0
+	for i := 0; i < cap(allowed); i++ {
+		if <-allowed {
+			probes++
+		}
+	}
+	if probes != 1 {
+		t.Errorf("half-open breaker let %d concurrent probes through, want 1", probes)
+	}
+}
+
+func TestClassifyAttempt(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		resp *http.Response
+		err  error
+		want attemptOutcome
+	}{
+		{name: "ok", ctx: context.Background(), resp: &http.Response{StatusCode: http.StatusOK}, want: attemptSucceeded},
+		{name: "not found", ctx: context.Background(), resp: &http.Response{StatusCode: http.StatusNotFound}, want: attemptSucceeded},
+		{name: "too many requests", ctx: context.Background(), resp: &http.Response{StatusCode: http.StatusTooManyRequests}, want: attemptSucceeded},
+		{name: "internal", ctx: context.Background(), resp: &http.Response{StatusCode: http.StatusInternalServerError}, want: attemptFailed},
+		{name: "unavailable", ctx: context.Background(), resp: &http.Response{StatusCode: http.StatusServiceUnavailable}, want: attemptFailed},
+		{name: "connection refused", ctx: context.Background(), err: errors.New("connection refused"), want: attemptFailed},
+		{name: "cancelled", ctx: cancelled, err: context.Canceled, want: attemptIgnored},
+	} {
+		if got := classifyAttempt(tt.ctx, tt.resp, tt.err); got != tt.want {
+			t.Errorf("classifyAttempt(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseUpstreamConcurrency(t *testing.T) {
+	limits, err := parseUpstreamConcurrency(" mpc_transaction=16, blockchain=0,")
+	if err != nil || len(limits) != 2 || limits["mpc_transaction"] != 16 || limits["blockchain"] != 0 {
+		t.Errorf("parseUpstreamConcurrency() = %v, %v, want mpc_transaction and blockchain", limits, err)
+	}
+	for _, value := range []string{"mpc_transaction", "mpc_transactions=1", "pool=-1", "pool=many"} {
+		if _, err := parseUpstreamConcurrency(value); err == nil {
+			t.Errorf("parseUpstreamConcurrency(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+// rejectionReason returns the ErrorInfo reason of a 503 response failed fast by a guard.
+func rejectionReason(t *testing.T, resp *http.Response) string {
+	t.Helper()
+
+	defer resp.Body.Close()
+	var body struct {
+		Error struct {
+			Details []struct {
+				Reason string `json:"reason"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || resp.StatusCode != http.StatusServiceUnavailable || len(body.Error.Details) == 0 {
+		t.Fatalf("response = %d, %v, want a 503 failed fast", resp.StatusCode, err)
+	}
+	return body.Error.Details[0].Reason
+}
+
+func TestBulkhead(t *testing.T) {
+	defer func(limits map[string]int) { upstreamConcurrency = limits }(upstreamConcurrency)
+	upstreamConcurrency = map[string]int{serviceLabel(mpcTransactionService): 1}
+
+	release := make(chan struct{})
+	received := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/slow") {
+			received <- struct{}{}
+			<-release
+		}
+		io.WriteString(w, `{}`)
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	transactions := newTestTransport(t)
+	transactions.service, transactions.guard = mpcTransactionService, newServiceGuard(mpcTransactionService)
+	blockchain := newTestTransport(t)
+	blockchain.service, blockchain.guard = blockchainService, newServiceGuard(blockchainService)
+
+	// A call holding the only slot of the mpc_transaction service saturates it.
+	go func() {
+		req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/slow", nil)
+		if resp, err := transactions.RoundTrip(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-received
+
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/fast", nil)
+	start := time.Now()
+	resp, err := transactions.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() = %v", err)
+	}
+	if reason := rejectionReason(t, resp); reason != reasonConcurrencyLimit || time.Since(start) > time.Second {
+		t.Errorf("call to a saturated service failed with %s, want %s immediately", reason, reasonConcurrencyLimit)
+	}
+
+	// Other services, such as the blockchain service serving GetNetwork, are not affected.
+	ctx := context.WithValue(context.Background(), rpcKey{}, "GetNetwork")
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL+"/v1/networks/ethereum-goerli", nil)
+	resp, err = blockchain.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("GetNetwork while mpc_transaction is saturated = %v, %v, want 200", resp, err)
+	}
+	resp.Body.Close()
+}
+
+func TestServiceGuardOpensBreaker(t *testing.T) {
+	defer func(threshold int) { *breakerFailureThreshold = threshold }(*breakerFailureThreshold)
+	*breakerFailureThreshold = 2
+
+	upstream := newTestUpstream(t, unavailable(""))
+	transport := newTestTransport(t)
+
+	// The breaker opens on the second attempt, ending the retries early.
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() = %v", err)
+	}
+	resp.Body.Close()
+	if upstream.calls() != 2 || transport.guard.breaker.state != breakerOpen {
+		t.Errorf("RoundTrip() made %d calls leaving the breaker in state %d, want 2 calls and an open breaker", upstream.calls(), transport.guard.breaker.state)
+	}
+
+	resp, err = transport.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() = %v", err)
+	}
+	if reason := rejectionReason(t, resp); reason != reasonCircuitOpen || upstream.calls() != 2 {
+		t.Errorf("RoundTrip() with an open breaker made %d calls, want %s without calling upstream", upstream.calls(), reasonCircuitOpen)
+	}
+}
+
+func TestServiceGuardProbeFailingLocally(t *testing.T) {
+	upstream := newTestUpstream(t, upstreamOK)
+	transport := newTestTransport(t)
+	breaker := transport.guard.breaker
+	breaker.threshold = 1
+	breaker.record(attemptFailed)
+	breaker.openedAt = breaker.openedAt.Add(-breaker.openTimeout)
+
+	// The probe fails before reaching WaaS, which says nothing of the service's health.
+	req, _ := http.NewRequest(http.MethodPost, upstream.URL, strings.NewReader("{}"))
+	req.GetBody = func() (io.ReadCloser, error) { return nil, errors.New("body unavailable") }
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("RoundTrip() with an unreadable body succeeded, want an error")
+	}
+	if breaker.state != breakerHalfOpen || upstream.calls() != 0 {
+		t.Fatalf("breaker state = %d after %d calls, want half-open without calling upstream", breaker.state, upstream.calls())
+	}
+
+	// Another probe is let through, and closes the breaker.
+	req, _ = http.NewRequest(http.MethodGet, upstream.URL, nil)
+	resp, err := transport.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("RoundTrip() after a probe failing locally = %v, %v, want 200", resp, err)
+	}
+	resp.Body.Close()
+	if breaker.state != breakerClosed {
+		t.Errorf("breaker state = %d after a successful probe, want closed", breaker.state)
+	}
+}
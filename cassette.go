@@ -0,0 +1,939 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	v1clients "github.com/coinbase/waas-client-library-go/clients/v1"
+	blockchain "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/blockchain/v1"
+	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	mpcWallet "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_wallets/v1"
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	protocols "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/v1"
+	v1types "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/types/v1"
+	"github.com/googleapis/gax-go/v2"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	// cassettePageSize is the page size recorded for the List RPCs iterated without one.
+	cassettePageSize = 100
+
+	// cassetteKeyLength is the number of hex digits of the request hash keying an interaction.
+	cassetteKeyLength = 16
+)
+
+var (
+	// cassetteDir is the directory of the cassettes recorded and replayed.
+	cassetteDir = flag.String("cassette-dir", "cassettes", "directory of the cassettes recorded with -record-cassettes and replayed with -backend=replay")
+
+	// recordCassettes records the traffic of the backend into -cassette-dir.
+	recordCassettes = flag.Bool("record-cassettes", false, "record every WaaS RPC of the backend, with secrets scrubbed, into -cassette-dir")
+)
+
+// cassetteInteraction is a request recorded in a cassette and the responses it got, in
+// order.
+type cassetteInteraction struct {
+	Key       string              `json:"key"`
+	Request   json.RawMessage     `json:"request"`
+	Responses []*cassetteResponse `json:"responses"`
+}
+
+// cassetteResponse is a recorded response: a message, a page of items, a snapshot of a
+// long-running operation, or an error.
+type cassetteResponse struct {
+	Response      json.RawMessage   `json:"response,omitempty"`
+	Items         []json.RawMessage `json:"items,omitempty"`
+	NextPageToken string            `json:"nextPageToken,omitempty"`
+	Operation     *cassetteSnapshot `json:"operation,omitempty"`
+	Error         *cassetteError    `json:"error,omitempty"`
+}
+
+// cassetteSnapshot is the state of a long-running operation seen by a call.
+type cassetteSnapshot struct {
+	Name     string          `json:"name"`
+	Done     bool            `json:"done"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Result   json.RawMessage `json:"result,omitempty"`
+}
+
+// cassetteError is a recorded error, replayed as a gRPC status.
+type cassetteError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *cassetteError) err() error {
+	code := codes.Unknown
+	if err := code.UnmarshalJSON([]byte(`"` + e.Code + `"`)); err != nil {
+		code = codes.Unknown
+	}
+	return status.Error(code, e.Message)
+}
+
+// cassette holds the interactions recorded for each RPC method, saved as one JSON file
+// per method. An interaction is keyed by a hash of its full request, less the random
+// request ID, so that requests differing only in secrets are told apart although the
+// recorded requests and responses are scrubbed of them.
+type cassette struct {
+	dir string
+
+	mu           sync.Mutex
+	interactions map[string][]*cassetteInteraction
+	// rerecorded holds the interactions recorded again since the cassette was loaded,
+	// whose responses of earlier sessions were dropped.
+	rerecorded map[*cassetteInteraction]bool
+	// served counts the responses replayed for each interaction.
+	served map[*cassetteInteraction]int
+}
+
+// loadCassette loads the cassettes of dir, creating it if create is set.
+func loadCassette(dir string, create bool) (*cassette, error) {
+	if create {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("cannot create cassette directory: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("cannot open cassette directory: %v", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list cassettes: %v", err)
+	}
+
+	c := &cassette{
+		dir:          dir,
+		interactions: map[string][]*cassetteInteraction{},
+		rerecorded:   map[*cassetteInteraction]bool{},
+		served:       map[*cassetteInteraction]int{},
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read cassette: %v", err)
+		}
+		var interactions []*cassetteInteraction
+		if err := json.Unmarshal(data, &interactions); err != nil {
+			return nil, fmt.Errorf("cannot parse cassette %s: %v", path, err)
+		}
+		c.interactions[strings.TrimSuffix(filepath.Base(path), ".json")] = interactions
+	}
+	return c, nil
+}
+
+// cassetteKey returns the key of the interactions of req.
+func cassetteKey(req proto.Message) (string, error) {
+	req = proto.Clone(req)
+	clearRequestID(req.ProtoReflect())
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(req.ProtoReflect().Descriptor().FullName()+"\n"), data...))
+	return hex.EncodeToString(sum[:])[:cassetteKeyLength], nil
+}
+
+// clearRequestID clears the request ID of m, generated afresh for each call.
+func clearRequestID(m protoreflect.Message) {
+	if fd := m.Descriptor().Fields().ByName("request_id"); fd != nil {
+		m.Clear(fd)
+	}
+}
+
+// scrubMessage replaces the secret fields of m, as found by their JSON name like in the
+// logs: secret strings are redacted and other secret values cleared.
+func scrubMessage(m protoreflect.Message) {
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+
+	for _, fd := range fields {
+		value := m.Get(fd)
+		switch {
+		case redactedKeys[strings.ToLower(strings.ReplaceAll(fd.JSONName(), "_", ""))]:
+			if fd.Kind() == protoreflect.StringKind && fd.Cardinality() != protoreflect.Repeated {
+				m.Set(fd, protoreflect.ValueOfString(redacted))
+			} else {
+				m.Clear(fd)
+			}
+		case fd.IsMap():
+			if fd.MapValue().Message() != nil {
+				value.Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+					scrubMessage(v.Message())
+					return true
+				})
+			}
+		case fd.IsList():
+			if fd.Message() != nil {
+				for i := 0; i < value.List().Len(); i++ {
+					scrubMessage(value.List().Get(i).Message())
+				}
+			}
+		case fd.Message() != nil:
+			scrubMessage(value.Message())
+		}
+	}
+}
+
+// marshalScrubbed returns m as JSON, scrubbed of secrets.
+func marshalScrubbed(m proto.Message) (json.RawMessage, error) {
+	if !m.ProtoReflect().IsValid() {
+		return nil, nil
+	}
+	m = proto.Clone(m)
+	clearRequestID(m.ProtoReflect())
+	scrubMessage(m.ProtoReflect())
+	return protojson.Marshal(m)
+}
+
+// record appends response to the interaction of req, replacing the responses recorded in
+// earlier sessions, and saves the cassette of method, unless err says the response could
+// not be built. As recording is a development aid, failures are logged rather than
+// failing the call.
+func (c *cassette) record(method string, req proto.Message, response *cassetteResponse, err error) {
+	if err == nil {
+		err = c.recordLocked(method, req, response)
+	}
+	if err != nil {
+		logger.Error("Error recording cassette", zap.String("method", method), zap.Error(err))
+	}
+}
+
+func (c *cassette) recordLocked(method string, req proto.Message, response *cassetteResponse) error {
+	key, err := cassetteKey(req)
+	if err != nil {
+		return err
+	}
+	request, err := marshalScrubbed(req)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	interaction := c.lookupLocked(method, key)
+	if interaction == nil {
+		interaction = &cassetteInteraction{Key: key}
+		c.interactions[method] = append(c.interactions[method], interaction)
+	}
+	if !c.rerecorded[interaction] {
+		c.rerecorded[interaction] = true
+		interaction.Responses = nil
+	}
+	interaction.Request = request
+	interaction.Responses = append(interaction.Responses, response)
+	return c.saveLocked(method)
+}
+
+func (c *cassette) lookupLocked(method, key string) *cassetteInteraction {
+	for _, interaction := range c.interactions[method] {
+		if interaction.Key == key {
+			return interaction
+		}
+	}
+	return nil
+}
+
+// saveLocked writes the cassette of method to a temporary file and renames it into place,
+// so that a crash never leaves a truncated cassette behind.
+func (c *cassette) saveLocked(method string) error {
+	data, err := json.MarshalIndent(c.interactions[method], "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(c.dir, method+".json")
+	tmp, err := os.CreateTemp(c.dir, method+".json.tmp*")
+	if err != nil {
+		return fmt.Errorf("cannot save cassette: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot save cassette: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot save cassette: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot save cassette: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("cannot save cassette: %v", err)
+	}
+	return nil
+}
+
+// replay returns the next response recorded for req, repeating the last one once they
+// are all served, as the state of a resource polled after the recording stopped is its
+// last recorded one. Requests never recorded fail with INTERNAL and are logged, so that
+// a stale cassette is noticed rather than replayed as an upstream error.
+func (c *cassette) replay(method string, req proto.Message) (*cassetteResponse, error) {
+	key, err := cassetteKey(req)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot key %s request: %v", method, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	interaction := c.lookupLocked(method, key)
+	if interaction == nil || len(interaction.Responses) == 0 {
+		request, _ := marshalScrubbed(req)
+		logger.Error("Request not recorded in cassette", zap.String("method", method), zap.String("key", key), zap.ByteString("request", request))
+		return nil, status.Errorf(codes.Internal, "%s request %s is not recorded in cassette %s", method, key, c.dir)
+	}
+
+	i := c.served[interaction]
+	if i < len(interaction.Responses)-1 {
+		c.served[interaction] = i + 1
+	}
+	return interaction.Responses[i], nil
+}
+
+// newMessage returns a new empty message of type T.
+func newMessage[T proto.Message]() T {
+	var m T
+	return m.ProtoReflect().New().Interface().(T)
+}
+
+// unmarshalRecorded decodes a message of a cassette, whose absence is a nil message.
+func unmarshalRecorded[T proto.Message](data json.RawMessage) (T, error) {
+	var m T
+	if len(data) == 0 {
+		return m, nil
+	}
+	m = newMessage[T]()
+	if err := unmarshalOptions.Unmarshal(data, m); err != nil {
+		return m, status.Errorf(codes.Internal, "cannot parse cassette: %v", err)
+	}
+	return m, nil
+}
+
+// recordedError returns the recorded response of an error.
+func recordedError(err error) *cassetteResponse {
+	_, response := translateError(err)
+	return &cassetteResponse{Error: &cassetteError{Code: response.Code, Message: redactString(response.Message)}}
+}
+
+// recordCall calls a unary RPC and records its response. Calls cut short by their
+// context are not recorded, as they say nothing of the upstream.
+func recordCall[Req, Resp proto.Message](ctx context.Context, c *cassette, method string, req Req, call func() (Resp, error)) (Resp, error) {
+	resp, err := call()
+	if ctx.Err() != nil {
+		return resp, err
+	}
+	if err != nil {
+		c.record(method, req, recordedError(err), nil)
+		return resp, err
+	}
+	data, marshalErr := marshalScrubbed(resp)
+	c.record(method, req, &cassetteResponse{Response: data}, marshalErr)
+	return resp, err
+}
+
+// replayCall replays the response recorded for a unary RPC.
+func replayCall[Resp proto.Message](c *cassette, method string, req proto.Message) (Resp, error) {
+	var zero Resp
+	recorded, err := c.replay(method, req)
+	if err != nil {
+		return zero, err
+	}
+	if recorded.Error != nil {
+		return zero, recorded.Error.err()
+	}
+	return unmarshalRecorded[Resp](recorded.Response)
+}
+
+// pageRequest returns a copy of the request of a List RPC asking for the given page.
+func pageRequest[Req proto.Message](req Req, pageSize int, pageToken string) Req {
+	req = proto.Clone(req).(Req)
+	m := req.ProtoReflect()
+	m.Set(m.Descriptor().Fields().ByName("page_size"), protoreflect.ValueOfInt32(int32(pageSize)))
+	m.Set(m.Descriptor().Fields().ByName("page_token"), protoreflect.ValueOfString(pageToken))
+	return req
+}
+
+// recordPages iterates over the items of a List RPC, recording each page fetched.
+func recordPages[Req, T proto.Message](ctx context.Context, c *cassette, method string, req Req, list func(req Req) iterator.Pageable) *pageIterator[T] {
+	return newPageIterator(func(pageSize int, pageToken string) ([]T, string, error) {
+		pageReq := pageRequest(req, pageSize, pageToken)
+		if pageSize <= 0 {
+			pageSize = cassettePageSize
+		}
+
+		var items []T
+		nextPageToken, err := iterator.NewPager(list(pageReq), pageSize, pageToken).NextPage(&items)
+		if ctx.Err() != nil {
+			return items, nextPageToken, err
+		}
+		if err != nil {
+			c.record(method, pageReq, recordedError(err), nil)
+			return items, nextPageToken, err
+		}
+
+		page := &cassetteResponse{NextPageToken: nextPageToken}
+		var marshalErr error
+		for _, item := range items {
+			var data json.RawMessage
+			if data, marshalErr = marshalScrubbed(item); marshalErr != nil {
+				break
+			}
+			page.Items = append(page.Items, data)
+		}
+		c.record(method, pageReq, page, marshalErr)
+		return items, nextPageToken, nil
+	})
+}
+
+// replayPages iterates over the items of the pages recorded for a List RPC.
+func replayPages[Req, T proto.Message](c *cassette, method string, req Req) *pageIterator[T] {
+	return newPageIterator(func(pageSize int, pageToken string) ([]T, string, error) {
+		recorded, err := c.replay(method, pageRequest(req, pageSize, pageToken))
+		if err != nil {
+			return nil, "", err
+		}
+		if recorded.Error != nil {
+			return nil, "", recorded.Error.err()
+		}
+
+		items := make([]T, 0, len(recorded.Items))
+		for _, data := range recorded.Items {
+			item, err := unmarshalRecorded[T](data)
+			if err != nil {
+				return nil, "", err
+			}
+			items = append(items, item)
+		}
+		return items, recorded.NextPageToken, nil
+	})
+}
+
+// recordedSnapshot returns the recorded response of a call to a long-running operation.
+func recordedSnapshot[R, M proto.Message](op longRunningOperation[R, M], result R, err error) (*cassetteResponse, error) {
+	snapshot := &cassetteSnapshot{Name: op.Name(), Done: op.Done()}
+	if metadata, metadataErr := op.Metadata(); metadataErr == nil {
+		var marshalErr error
+		if snapshot.Metadata, marshalErr = marshalScrubbed(metadata); marshalErr != nil {
+			return nil, marshalErr
+		}
+	}
+	response := &cassetteResponse{Operation: snapshot}
+	if err != nil {
+		response.Error = recordedError(err).Error
+		return response, nil
+	}
+	if snapshot.Done {
+		var marshalErr error
+		if snapshot.Result, marshalErr = marshalScrubbed(result); marshalErr != nil {
+			return nil, marshalErr
+		}
+	}
+	return response, nil
+}
+
+// operationMethod is the method under which the polls of the operations started by
+// method are recorded.
+func operationMethod(method string) string {
+	return method + "Operation"
+}
+
+// recordOperation starts a long-running operation, recording the state it started in,
+// and records its polls.
+func recordOperation[Req, R, M proto.Message](ctx context.Context, c *cassette, method string, req Req, start func() (longRunningOperation[R, M], error)) (longRunningOperation[R, M], error) {
+	op, err := start()
+	if err != nil {
+		if ctx.Err() == nil {
+			c.record(method, req, recordedError(err), nil)
+		}
+		return nil, err
+	}
+
+	var result R
+	var opErr error
+	if op.Done() {
+		result, opErr = op.Poll(ctx)
+	}
+	if ctx.Err() == nil {
+		response, marshalErr := recordedSnapshot(op, result, opErr)
+		c.record(method, req, response, marshalErr)
+	}
+	return &recordedOperation[R, M]{op, c, operationMethod(method)}, nil
+}
+
+// recordedOperation records the polls of a long-running operation under its name.
+type recordedOperation[R, M proto.Message] struct {
+	longRunningOperation[R, M]
+	cassette *cassette
+	method   string
+}
+
+func (op *recordedOperation[R, M]) Poll(ctx context.Context, opts ...gax.CallOption) (R, error) {
+	result, err := op.longRunningOperation.Poll(ctx, opts...)
+	op.record(ctx, result, err)
+	return result, err
+}
+
+func (op *recordedOperation[R, M]) Wait(ctx context.Context, opts ...gax.CallOption) (R, error) {
+	result, err := op.longRunningOperation.Wait(ctx, opts...)
+	op.record(ctx, result, err)
+	return result, err
+}
+
+func (op *recordedOperation[R, M]) record(ctx context.Context, result R, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	response, marshalErr := recordedSnapshot[R, M](op.longRunningOperation, result, err)
+	op.cassette.record(op.method, wrapperspb.String(op.Name()), response, marshalErr)
+}
+
+// replayedOperation is a long-running operation whose polls replay the recorded ones.
+type replayedOperation[R, M proto.Message] struct {
+	*localOperation[R, M]
+
+	mu       sync.Mutex
+	metadata M
+}
+
+// replayOperation returns the named operation, in the state of recorded if set and
+// otherwise pending until polled.
+func replayOperation[R, M proto.Message](c *cassette, method, name string, recorded *cassetteResponse) *replayedOperation[R, M] {
+	op := &replayedOperation[R, M]{}
+	op.localOperation = &localOperation[R, M]{name: name, check: func() (R, bool, error) {
+		recorded, err := c.replay(operationMethod(method), wrapperspb.String(name))
+		if err != nil {
+			var zero R
+			return zero, false, err
+		}
+		return op.apply(recorded)
+	}}
+	if recorded != nil {
+		op.result, op.done, op.err = op.apply(recorded)
+	}
+	return op
+}
+
+// apply returns the result, state and error of a recorded snapshot, updating the
+// metadata.
+func (op *replayedOperation[R, M]) apply(recorded *cassetteResponse) (R, bool, error) {
+	var result R
+	snapshot := recorded.Operation
+	if snapshot == nil {
+		if recorded.Error != nil {
+			return result, false, recorded.Error.err()
+		}
+		return result, false, status.Errorf(codes.Internal, "cassette of operation %s holds no snapshot", op.name)
+	}
+
+	metadata, err := unmarshalRecorded[M](snapshot.Metadata)
+	if err != nil {
+		return result, false, err
+	}
+	op.mu.Lock()
+	op.metadata = metadata
+	op.mu.Unlock()
+
+	if recorded.Error != nil {
+		return result, snapshot.Done, recorded.Error.err()
+	}
+	if snapshot.Done {
+		if result, err = unmarshalRecorded[R](snapshot.Result); err != nil {
+			return result, false, err
+		}
+	}
+	return result, snapshot.Done, nil
+}
+
+func (op *replayedOperation[R, M]) Metadata() (M, error) {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	return op.metadata, nil
+}
+
+// replayStart replays the start of a long-running operation.
+func replayStart[R, M proto.Message](c *cassette, method string, req proto.Message) (longRunningOperation[R, M], error) {
+	recorded, err := c.replay(method, req)
+	if err != nil {
+		return nil, err
+	}
+	if recorded.Operation == nil {
+		if recorded.Error != nil {
+			return nil, recorded.Error.err()
+		}
+		return nil, status.Errorf(codes.Internal, "cassette of %s holds no operation", method)
+	}
+	return replayOperation[R, M](c, method, recorded.Operation.Name, recorded), nil
+}
+
+// cassetteRecorder calls the services of next, recording their traffic into a cassette.
+type cassetteRecorder struct {
+	next     *services
+	cassette *cassette
+}
+
+// services returns the recording services.
+func (r *cassetteRecorder) services() *services {
+	return &services{
+		blockchain:     r,
+		mpcKey:         r,
+		mpcTransaction: r,
+		mpcWallet:      r,
+		pool:           r,
+		protocol:       r,
+	}
+}
+
+func (r *cassetteRecorder) GetNetwork(ctx context.Context, req *blockchain.GetNetworkRequest, opts ...gax.CallOption) (*blockchain.Network, error) {
+	return recordCall(ctx, r.cassette, "GetNetwork", req, func() (*blockchain.Network, error) {
+		return r.next.blockchain.GetNetwork(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) ListNetworks(ctx context.Context, req *blockchain.ListNetworksRequest, opts ...gax.CallOption) v1clients.NetworkIterator {
+	return networkPageIterator{recordPages[*blockchain.ListNetworksRequest, *blockchain.Network](ctx, r.cassette, "ListNetworks", req, func(req *blockchain.ListNetworksRequest) iterator.Pageable {
+		return r.next.blockchain.ListNetworks(ctx, req, opts...)
+	})}
+}
+
+func (r *cassetteRecorder) GetAsset(ctx context.Context, req *blockchain.GetAssetRequest, opts ...gax.CallOption) (*blockchain.Asset, error) {
+	return recordCall(ctx, r.cassette, "GetAsset", req, func() (*blockchain.Asset, error) {
+		return r.next.blockchain.GetAsset(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) ListAssets(ctx context.Context, req *blockchain.ListAssetsRequest, opts ...gax.CallOption) v1clients.AssetIterator {
+	return assetPageIterator{recordPages[*blockchain.ListAssetsRequest, *blockchain.Asset](ctx, r.cassette, "ListAssets", req, func(req *blockchain.ListAssetsRequest) iterator.Pageable {
+		return r.next.blockchain.ListAssets(ctx, req, opts...)
+	})}
+}
+
+func (r *cassetteRecorder) RegisterDevice(ctx context.Context, req *mpcKeys.RegisterDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error) {
+	return recordCall(ctx, r.cassette, "RegisterDevice", req, func() (*mpcKeys.Device, error) {
+		return r.next.mpcKey.RegisterDevice(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) GetDevice(ctx context.Context, req *mpcKeys.GetDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error) {
+	return recordCall(ctx, r.cassette, "GetDevice", req, func() (*mpcKeys.Device, error) {
+		return r.next.mpcKey.GetDevice(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) CreateDeviceGroup(ctx context.Context, req *mpcKeys.CreateDeviceGroupRequest, opts ...gax.CallOption) (createDeviceGroupOperation, error) {
+	return recordOperation(ctx, r.cassette, "CreateDeviceGroup", req, func() (createDeviceGroupOperation, error) {
+		return r.next.mpcKey.CreateDeviceGroup(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) CreateDeviceGroupOperation(name string) createDeviceGroupOperation {
+	return &recordedOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata]{r.next.mpcKey.CreateDeviceGroupOperation(name), r.cassette, operationMethod("CreateDeviceGroup")}
+}
+
+func (r *cassetteRecorder) GetDeviceGroup(ctx context.Context, req *mpcKeys.GetDeviceGroupRequest, opts ...gax.CallOption) (*mpcKeys.DeviceGroup, error) {
+	return recordCall(ctx, r.cassette, "GetDeviceGroup", req, func() (*mpcKeys.DeviceGroup, error) {
+		return r.next.mpcKey.GetDeviceGroup(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) ListMPCOperations(ctx context.Context, req *mpcKeys.ListMPCOperationsRequest, opts ...gax.CallOption) (*mpcKeys.ListMPCOperationsResponse, error) {
+	return recordCall(ctx, r.cassette, "ListMPCOperations", req, func() (*mpcKeys.ListMPCOperationsResponse, error) {
+		return r.next.mpcKey.ListMPCOperations(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) CreateMPCKey(ctx context.Context, req *mpcKeys.CreateMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error) {
+	return recordCall(ctx, r.cassette, "CreateMPCKey", req, func() (*mpcKeys.MPCKey, error) {
+		return r.next.mpcKey.CreateMPCKey(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) GetMPCKey(ctx context.Context, req *mpcKeys.GetMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error) {
+	return recordCall(ctx, r.cassette, "GetMPCKey", req, func() (*mpcKeys.MPCKey, error) {
+		return r.next.mpcKey.GetMPCKey(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) CreateSignature(ctx context.Context, req *mpcKeys.CreateSignatureRequest, opts ...gax.CallOption) (createSignatureOperation, error) {
+	return recordOperation(ctx, r.cassette, "CreateSignature", req, func() (createSignatureOperation, error) {
+		return r.next.mpcKey.CreateSignature(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) CreateSignatureOperation(name string) createSignatureOperation {
+	return &recordedOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata]{r.next.mpcKey.CreateSignatureOperation(name), r.cassette, operationMethod("CreateSignature")}
+}
+
+func (r *cassetteRecorder) CreateMPCTransaction(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error) {
+	return recordOperation(ctx, r.cassette, "CreateMPCTransaction", req, func() (createMPCTransactionOperation, error) {
+		return r.next.mpcTransaction.CreateMPCTransaction(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) CreateMPCTransactionOperation(name string) createMPCTransactionOperation {
+	return &recordedOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata]{r.next.mpcTransaction.CreateMPCTransactionOperation(name), r.cassette, operationMethod("CreateMPCTransaction")}
+}
+
+func (r *cassetteRecorder) GetMPCTransaction(ctx context.Context, req *mpcTransactions.GetMPCTransactionRequest, opts ...gax.CallOption) (*mpcTransactions.MPCTransaction, error) {
+	return recordCall(ctx, r.cassette, "GetMPCTransaction", req, func() (*mpcTransactions.MPCTransaction, error) {
+		return r.next.mpcTransaction.GetMPCTransaction(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) ListMPCTransactions(ctx context.Context, req *mpcTransactions.ListMPCTransactionsRequest, opts ...gax.CallOption) v1clients.MPCTransactionIterator {
+	return mpcTransactionPageIterator{recordPages[*mpcTransactions.ListMPCTransactionsRequest, *mpcTransactions.MPCTransaction](ctx, r.cassette, "ListMPCTransactions", req, func(req *mpcTransactions.ListMPCTransactionsRequest) iterator.Pageable {
+		return r.next.mpcTransaction.ListMPCTransactions(ctx, req, opts...)
+	})}
+}
+
+func (r *cassetteRecorder) CreateMPCWallet(ctx context.Context, req *mpcWallet.CreateMPCWalletRequest, opts ...gax.CallOption) (createMPCWalletOperation, error) {
+	return recordOperation(ctx, r.cassette, "CreateMPCWallet", req, func() (createMPCWalletOperation, error) {
+		return r.next.mpcWallet.CreateMPCWallet(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) CreateMPCWalletOperation(name string) createMPCWalletOperation {
+	return &recordedOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata]{r.next.mpcWallet.CreateMPCWalletOperation(name), r.cassette, operationMethod("CreateMPCWallet")}
+}
+
+func (r *cassetteRecorder) GetMPCWallet(ctx context.Context, req *mpcWallet.GetMPCWalletRequest, opts ...gax.CallOption) (*mpcWallet.MPCWallet, error) {
+	return recordCall(ctx, r.cassette, "GetMPCWallet", req, func() (*mpcWallet.MPCWallet, error) {
+		return r.next.mpcWallet.GetMPCWallet(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) ListMPCWallets(ctx context.Context, req *mpcWallet.ListMPCWalletsRequest, opts ...gax.CallOption) v1clients.MPCWalletIterator {
+	return mpcWalletPageIterator{recordPages[*mpcWallet.ListMPCWalletsRequest, *mpcWallet.MPCWallet](ctx, r.cassette, "ListMPCWallets", req, func(req *mpcWallet.ListMPCWalletsRequest) iterator.Pageable {
+		return r.next.mpcWallet.ListMPCWallets(ctx, req, opts...)
+	})}
+}
+
+func (r *cassetteRecorder) GenerateAddress(ctx context.Context, req *mpcWallet.GenerateAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error) {
+	return recordCall(ctx, r.cassette, "GenerateAddress", req, func() (*mpcWallet.Address, error) {
+		return r.next.mpcWallet.GenerateAddress(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) GetAddress(ctx context.Context, req *mpcWallet.GetAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error) {
+	return recordCall(ctx, r.cassette, "GetAddress", req, func() (*mpcWallet.Address, error) {
+		return r.next.mpcWallet.GetAddress(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) ListAddresses(ctx context.Context, req *mpcWallet.ListAddressesRequest, opts ...gax.CallOption) v1clients.AddressIterator {
+	return addressPageIterator{recordPages[*mpcWallet.ListAddressesRequest, *mpcWallet.Address](ctx, r.cassette, "ListAddresses", req, func(req *mpcWallet.ListAddressesRequest) iterator.Pageable {
+		return r.next.mpcWallet.ListAddresses(ctx, req, opts...)
+	})}
+}
+
+func (r *cassetteRecorder) ListBalances(ctx context.Context, req *mpcWallet.ListBalancesRequest, opts ...gax.CallOption) v1clients.BalanceIterator {
+	return balancePageIterator{recordPages[*mpcWallet.ListBalancesRequest, *mpcWallet.Balance](ctx, r.cassette, "ListBalances", req, func(req *mpcWallet.ListBalancesRequest) iterator.Pageable {
+		return r.next.mpcWallet.ListBalances(ctx, req, opts...)
+	})}
+}
+
+func (r *cassetteRecorder) CreatePool(ctx context.Context, req *pools.CreatePoolRequest, opts ...gax.CallOption) (*pools.Pool, error) {
+	return recordCall(ctx, r.cassette, "CreatePool", req, func() (*pools.Pool, error) {
+		return r.next.pool.CreatePool(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) GetPool(ctx context.Context, req *pools.GetPoolRequest, opts ...gax.CallOption) (*pools.Pool, error) {
+	return recordCall(ctx, r.cassette, "GetPool", req, func() (*pools.Pool, error) {
+		return r.next.pool.GetPool(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) ListPools(ctx context.Context, req *pools.ListPoolsRequest, opts ...gax.CallOption) v1clients.PoolIterator {
+	return poolPageIterator{recordPages[*pools.ListPoolsRequest, *pools.Pool](ctx, r.cassette, "ListPools", req, func(req *pools.ListPoolsRequest) iterator.Pageable {
+		return r.next.pool.ListPools(ctx, req, opts...)
+	})}
+}
+
+func (r *cassetteRecorder) ConstructTransaction(ctx context.Context, req *protocols.ConstructTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	return recordCall(ctx, r.cassette, "ConstructTransaction", req, func() (*v1types.Transaction, error) {
+		return r.next.protocol.ConstructTransaction(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) ConstructTransferTransaction(ctx context.Context, req *protocols.ConstructTransferTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	return recordCall(ctx, r.cassette, "ConstructTransferTransaction", req, func() (*v1types.Transaction, error) {
+		return r.next.protocol.ConstructTransferTransaction(ctx, req, opts...)
+	})
+}
+
+func (r *cassetteRecorder) BroadcastTransaction(ctx context.Context, req *protocols.BroadcastTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	return recordCall(ctx, r.cassette, "BroadcastTransaction", req, func() (*v1types.Transaction, error) {
+		return r.next.protocol.BroadcastTransaction(ctx, req, opts...)
+	})
+}
+
+// cassettePlayer serves the WaaS services from the traffic recorded in a cassette.
+type cassettePlayer struct {
+	cassette *cassette
+}
+
+// services returns the replaying services.
+func (p *cassettePlayer) services() *services {
+	return &services{
+		blockchain:     p,
+		mpcKey:         p,
+		mpcTransaction: p,
+		mpcWallet:      p,
+		pool:           p,
+		protocol:       p,
+	}
+}
+
+func (p *cassettePlayer) GetNetwork(ctx context.Context, req *blockchain.GetNetworkRequest, opts ...gax.CallOption) (*blockchain.Network, error) {
+	return replayCall[*blockchain.Network](p.cassette, "GetNetwork", req)
+}
+
+func (p *cassettePlayer) ListNetworks(ctx context.Context, req *blockchain.ListNetworksRequest, opts ...gax.CallOption) v1clients.NetworkIterator {
+	return networkPageIterator{replayPages[*blockchain.ListNetworksRequest, *blockchain.Network](p.cassette, "ListNetworks", req)}
+}
+
+func (p *cassettePlayer) GetAsset(ctx context.Context, req *blockchain.GetAssetRequest, opts ...gax.CallOption) (*blockchain.Asset, error) {
+	return replayCall[*blockchain.Asset](p.cassette, "GetAsset", req)
+}
+
+func (p *cassettePlayer) ListAssets(ctx context.Context, req *blockchain.ListAssetsRequest, opts ...gax.CallOption) v1clients.AssetIterator {
+	return assetPageIterator{replayPages[*blockchain.ListAssetsRequest, *blockchain.Asset](p.cassette, "ListAssets", req)}
+}
+
+func (p *cassettePlayer) RegisterDevice(ctx context.Context, req *mpcKeys.RegisterDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error) {
+	return replayCall[*mpcKeys.Device](p.cassette, "RegisterDevice", req)
+}
+
+func (p *cassettePlayer) GetDevice(ctx context.Context, req *mpcKeys.GetDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error) {
+	return replayCall[*mpcKeys.Device](p.cassette, "GetDevice", req)
+}
+
+func (p *cassettePlayer) CreateDeviceGroup(ctx context.Context, req *mpcKeys.CreateDeviceGroupRequest, opts ...gax.CallOption) (createDeviceGroupOperation, error) {
+	return replayStart[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata](p.cassette, "CreateDeviceGroup", req)
+}
+
+func (p *cassettePlayer) CreateDeviceGroupOperation(name string) createDeviceGroupOperation {
+	return replayOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata](p.cassette, "CreateDeviceGroup", name, nil)
+}
+
+func (p *cassettePlayer) GetDeviceGroup(ctx context.Context, req *mpcKeys.GetDeviceGroupRequest, opts ...gax.CallOption) (*mpcKeys.DeviceGroup, error) {
+	return replayCall[*mpcKeys.DeviceGroup](p.cassette, "GetDeviceGroup", req)
+}
+
+func (p *cassettePlayer) ListMPCOperations(ctx context.Context, req *mpcKeys.ListMPCOperationsRequest, opts ...gax.CallOption) (*mpcKeys.ListMPCOperationsResponse, error) {
+	return replayCall[*mpcKeys.ListMPCOperationsResponse](p.cassette, "ListMPCOperations", req)
+}
+
+func (p *cassettePlayer) CreateMPCKey(ctx context.Context, req *mpcKeys.CreateMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error) {
+	return replayCall[*mpcKeys.MPCKey](p.cassette, "CreateMPCKey", req)
+}
+
+func (p *cassettePlayer) GetMPCKey(ctx context.Context, req *mpcKeys.GetMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error) {
+	return replayCall[*mpcKeys.MPCKey](p.cassette, "GetMPCKey", req)
+}
+
+func (p *cassettePlayer) CreateSignature(ctx context.Context, req *mpcKeys.CreateSignatureRequest, opts ...gax.CallOption) (createSignatureOperation, error) {
+	return replayStart[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata](p.cassette, "CreateSignature", req)
+}
+
+func (p *cassettePlayer) CreateSignatureOperation(name string) createSignatureOperation {
+	return replayOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata](p.cassette, "CreateSignature", name, nil)
+}
+
+func (p *cassettePlayer) CreateMPCTransaction(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error) {
+	return replayStart[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata](p.cassette, "CreateMPCTransaction", req)
+}
+
+func (p *cassettePlayer) CreateMPCTransactionOperation(name string) createMPCTransactionOperation {
+	return replayOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata](p.cassette, "CreateMPCTransaction", name, nil)
+}
+
+func (p *cassettePlayer) GetMPCTransaction(ctx context.Context, req *mpcTransactions.GetMPCTransactionRequest, opts ...gax.CallOption) (*mpcTransactions.MPCTransaction, error) {
+	return replayCall[*mpcTransactions.MPCTransaction](p.cassette, "GetMPCTransaction", req)
+}
+
+func (p *cassettePlayer) ListMPCTransactions(ctx context.Context, req *mpcTransactions.ListMPCTransactionsRequest, opts ...gax.CallOption) v1clients.MPCTransactionIterator {
+	return mpcTransactionPageIterator{replayPages[*mpcTransactions.ListMPCTransactionsRequest, *mpcTransactions.MPCTransaction](p.cassette, "ListMPCTransactions", req)}
+}
+
+func (p *cassettePlayer) CreateMPCWallet(ctx context.Context, req *mpcWallet.CreateMPCWalletRequest, opts ...gax.CallOption) (createMPCWalletOperation, error) {
+	return replayStart[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata](p.cassette, "CreateMPCWallet", req)
+}
+
+func (p *cassettePlayer) CreateMPCWalletOperation(name string) createMPCWalletOperation {
+	return replayOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata](p.cassette, "CreateMPCWallet", name, nil)
+}
+
+func (p *cassettePlayer) GetMPCWallet(ctx context.Context, req *mpcWallet.GetMPCWalletRequest, opts ...gax.CallOption) (*mpcWallet.MPCWallet, error) {
+	return replayCall[*mpcWallet.MPCWallet](p.cassette, "GetMPCWallet", req)
+}
+
+func (p *cassettePlayer) ListMPCWallets(ctx context.Context, req *mpcWallet.ListMPCWalletsRequest, opts ...gax.CallOption) v1clients.MPCWalletIterator {
+	return mpcWalletPageIterator{replayPages[*mpcWallet.ListMPCWalletsRequest, *mpcWallet.MPCWallet](p.cassette, "ListMPCWallets", req)}
+}
+
+func (p *cassettePlayer) GenerateAddress(ctx context.Context, req *mpcWallet.GenerateAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error) {
+	return replayCall[*mpcWallet.Address](p.cassette, "GenerateAddress", req)
+}
+
+func (p *cassettePlayer) GetAddress(ctx context.Context, req *mpcWallet.GetAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error) {
+	return replayCall[*mpcWallet.Address](p.cassette, "GetAddress", req)
+}
+
+func (p *cassettePlayer) ListAddresses(ctx context.Context, req *mpcWallet.ListAddressesRequest, opts ...gax.CallOption) v1clients.AddressIterator {
+	return addressPageIterator{replayPages[*mpcWallet.ListAddressesRequest, *mpcWallet.Address](p.cassette, "ListAddresses", req)}
+}
+
+func (p *cassettePlayer) ListBalances(ctx context.Context, req *mpcWallet.ListBalancesRequest, opts ...gax.CallOption) v1clients.BalanceIterator {
+	return balancePageIterator{replayPages[*mpcWallet.ListBalancesRequest, *mpcWallet.Balance](p.cassette, "ListBalances", req)}
+}
+
+func (p *cassettePlayer) CreatePool(ctx context.Context, req *pools.CreatePoolRequest, opts ...gax.CallOption) (*pools.Pool, error) {
+	return replayCall[*pools.Pool](p.cassette, "CreatePool", req)
+}
+
+func (p *cassettePlayer) GetPool(ctx context.Context, req *pools.GetPoolRequest, opts ...gax.CallOption) (*pools.Pool, error) {
+	return replayCall[*pools.Pool](p.cassette, "GetPool", req)
+}
+
+func (p *cassettePlayer) ListPools(ctx context.Context, req *pools.ListPoolsRequest, opts ...gax.CallOption) v1clients.PoolIterator {
+	return poolPageIterator{replayPages[*pools.ListPoolsRequest, *pools.Pool](p.cassette, "ListPools", req)}
+}
+
+func (p *cassettePlayer) ConstructTransaction(ctx context.Context, req *protocols.ConstructTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	return replayCall[*v1types.Transaction](p.cassette, "ConstructTransaction", req)
+}
+
+func (p *cassettePlayer) ConstructTransferTransaction(ctx context.Context, req *protocols.ConstructTransferTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	return replayCall[*v1types.Transaction](p.cassette, "ConstructTransferTransaction", req)
+}
+
+func (p *cassettePlayer) BroadcastTransaction(ctx context.Context, req *protocols.BroadcastTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	return replayCall[*v1types.Transaction](p.cassette, "BroadcastTransaction", req)
+}
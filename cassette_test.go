@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
+	"google.golang.org/grpc/codes"
+)
+
+// newCassetteProxy returns a router without authentication served by svc.
+func newCassetteProxy(svc *services) *testProxy {
+	readiness := newReadinessChecker(0, time.Second)
+	return &testProxy{router: newRouter(svc, &routerConfig{readiness: readiness})}
+}
+
+// loadTestCassette loads the cassettes of dir, failing the test on error.
+func loadTestCassette(t *testing.T, dir string, create bool) *cassette {
+	t.Helper()
+
+	c, err := loadCassette(dir, create)
+	if err != nil {
+		t.Fatalf("loadCassette(%s) = %v", dir, err)
+	}
+	return c
+}
+
+// responseName returns the name of the resource or operation of a response.
+func responseName(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var response struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || response.Name == "" {
+		t.Fatalf("response %d %s holds no name", w.Code, w.Body)
+	}
+	return response.Name
+}
+
+// cassetteRequests exercise unary calls, pages, operations and errors against the
+// default emulator fixture.
+var cassetteRequests = []testRequest{
+	{method: http.MethodPost, path: "/pools/v1/pools?poolId=dev", body: `{"displayName": "Development"}`},
+	{method: http.MethodPost, path: "/pools/v1/pools?poolId=test", body: `{}`},
+	{method: http.MethodGet, path: "/pools/v1/pools/dev"},
+	{method: http.MethodGet, path: "/pools/v1/pools/missing"},
+	{method: http.MethodGet, path: "/pools/v1/pools?pageSize=1"},
+	{method: http.MethodGet, path: "/pools/v1/pools?pageSize=1&all=true"},
+	{method: http.MethodGet, path: "/blockchain/v1/networks"},
+	{method: http.MethodPost, path: "/mpc_keys/v1/device/register", body: `{"registrationData": "c2VjcmV0LXJlZ2lzdHJhdGlvbg=="}`},
+	{method: http.MethodPost, path: "/mpc_keys/v1/pools/dev/deviceGroups?deviceGroupId=group", body: `{"devices": ["devices/missing"]}`},
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cassettes")
+
+	e, err := newEmulator([]byte(defaultEmulatorFixture), 0, 0)
+	if err != nil {
+		t.Fatalf("newEmulator() = %v", err)
+	}
+	recorder := newCassetteProxy((&cassetteRecorder{next: e.services(), cassette: loadTestCassette(t, dir, true)}).services())
+
+	var want []string
+	var device string
+	for _, req := range cassetteRequests {
+		w := recorder.do("", req)
+		want = append(want, w.Result().Status+" "+compactBody(w))
+		if strings.HasSuffix(req.path, "/register") {
+			device = responseName(t, w)
+		}
+	}
+
+	// The wallet operation is polled until done, recording every state it is seen in.
+	createWallet := testRequest{method: http.MethodPost, path: "/mpc_wallets/v1/pools/dev/mpcWallets?wait=true&device=" + device, body: `{}`}
+	w := recorder.do("", createWallet)
+	if w.Code != http.StatusOK {
+		t.Fatalf("CreateMPCWallet() = %d %s, want the created wallet", w.Code, w.Body)
+	}
+	want = append(want, w.Result().Status+" "+compactBody(w))
+
+	registrations, err := os.ReadFile(filepath.Join(dir, "RegisterDevice.json"))
+	if err != nil {
+		t.Fatalf("reading the RegisterDevice cassette: %v", err)
+	}
+	if strings.Contains(string(registrations), "c2VjcmV0LXJlZ2lzdHJhdGlvbg") || strings.Contains(string(registrations), "requestId") {
+		t.Errorf("RegisterDevice cassette = %s, want the registration data and request ID scrubbed", registrations)
+	}
+
+	player := newCassetteProxy((&cassettePlayer{loadTestCassette(t, dir, false)}).services())
+	for i, req := range append(cassetteRequests, createWallet) {
+		w := player.do("", req)
+		if got := w.Result().Status + " " + compactBody(w); got != want[i] {
+			t.Errorf("replayed %s %s = %s, want %s", req.method, req.path, got, want[i])
+		}
+	}
+}
+
+func TestCassetteReplayPolls(t *testing.T) {
+	dir := t.TempDir()
+
+	// The device group is never created by the emulator during the test: it is put in
+	// place below, completing the operation between two polls.
+	e, err := newEmulator([]byte(defaultEmulatorFixture), time.Hour, 0)
+	if err != nil {
+		t.Fatalf("newEmulator() = %v", err)
+	}
+	recorder := newCassetteProxy((&cassetteRecorder{next: e.services(), cassette: loadTestCassette(t, dir, true)}).services())
+	recorder.call(t, testRequest{method: http.MethodPost, path: "/pools/v1/pools?poolId=dev", body: `{}`}, http.StatusOK, nil)
+	w := recorder.do("", testRequest{method: http.MethodPost, path: "/mpc_keys/v1/device/register", body: `{"registrationData": "cmVnaXN0cmF0aW9u"}`})
+	device := responseName(t, w)
+
+	createGroup := testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/dev/deviceGroups?deviceGroupId=group", body: `{"devices": ["` + device + `"]}`}
+	w = recorder.do("", createGroup)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("CreateDeviceGroup() = %d %s, want a pending operation", w.Code, w.Body)
+	}
+	getOperation := testRequest{method: http.MethodGet, path: "/operations/" + responseName(t, w) + "?kind=" + operationCreateDeviceGroup}
+	recorder.call(t, getOperation, http.StatusAccepted, nil)
+	e.deviceGroups.put(&mpcKeys.DeviceGroup{Name: "pools/dev/deviceGroups/group", Devices: []string{device}})
+	recorder.call(t, getOperation, http.StatusOK, nil)
+
+	// The polls replay in order, and the last one repeats.
+	player := newCassetteProxy((&cassettePlayer{loadTestCassette(t, dir, false)}).services())
+	if w := player.do("", createGroup); w.Code != http.StatusAccepted {
+		t.Errorf("replayed CreateDeviceGroup() = %d %s, want a pending operation", w.Code, w.Body)
+	}
+	player.call(t, getOperation, http.StatusAccepted, nil)
+	player.call(t, getOperation, http.StatusOK, nil)
+	player.call(t, getOperation, http.StatusOK, nil)
+}
+
+func TestCassetteReplayMiss(t *testing.T) {
+	player := newCassetteProxy((&cassettePlayer{loadTestCassette(t, t.TempDir(), false)}).services())
+
+	checkError(t, player.do("", testRequest{method: http.MethodGet, path: "/pools/v1/pools/dev"}), http.StatusInternalServerError, codes.Internal)
+	checkError(t, player.do("", testRequest{method: http.MethodGet, path: "/pools/v1/pools"}), http.StatusInternalServerError, codes.Internal)
+}
+
+func TestLoadCassetteErrors(t *testing.T) {
+	if _, err := loadCassette(filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Error("loadCassette() of a missing directory succeeded, want an error")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "GetPool.json"), []byte(`{"key": "abc"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCassette(dir, false); err == nil {
+		t.Error("loadCassette() of a malformed cassette succeeded, want an error")
+	}
+}
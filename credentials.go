@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coinbase/waas-client-library-go/auth"
+)
+
+const (
+	// apiKeyNameEnvVar is the environment variable holding the API Key name.
+	apiKeyNameEnvVar = "COINBASE_CLOUD_API_KEY_NAME"
+
+	// apiKeyPrivateKeyEnvVar is the environment variable holding the API Key private key.
+	apiKeyPrivateKeyEnvVar = "COINBASE_CLOUD_API_KEY_PRIVATE_KEY"
+
+	// defaultNameSecret is the secret holding the API Key name in a SecretStore.
+	defaultNameSecret = "waas-api-key-name"
+
+	// defaultPrivateKeySecret is the secret holding the API Key private key in a SecretStore.
+	defaultPrivateKeySecret = "waas-api-key-private-key"
+)
+
+// CredentialProvider supplies the WaaS API Key used to authenticate upstream calls.
+type CredentialProvider interface {
+	APIKey(ctx context.Context) (*auth.APIKey, error)
+}
+
+// SecretStore is a source of named secrets, such as a vault or a cloud secret manager.
+type SecretStore interface {
+	GetSecret(ctx context.Context, name string) (string, error)
+}
+
+// envCredentialProvider reads the API Key from environment variables.
+type envCredentialProvider struct{}
+
+func (envCredentialProvider) APIKey(ctx context.Context) (*auth.APIKey, error) {
+	name := os.Getenv(apiKeyNameEnvVar)
+	if name == "" {
+		return nil, fmt.Errorf("environment variable %s must be set", apiKeyNameEnvVar)
+	}
+
+	privateKey := os.Getenv(apiKeyPrivateKeyEnvVar)
+	if privateKey == "" {
+		return nil, fmt.Errorf("environment variable %s must be set", apiKeyPrivateKeyEnvVar)
+	}
+
+	return &auth.APIKey{Name: name, PrivateKey: privateKey}, nil
+}
+
+// keyFileCredentialProvider reads the API Key from a JSON key file as downloaded
+// from the WaaS console.
+type keyFileCredentialProvider struct {
+	path string
+}
+
+// apiKeyFile is the subset of the downloaded key file the proxy needs.
+type apiKeyFile struct {
+	Name       string `json:"name"`
+	PrivateKey string `json:"privateKey"`
+}
+
+func (p keyFileCredentialProvider) APIKey(ctx context.Context) (*auth.APIKey, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read API key file: %v", err)
+	}
+
+	var keyFile apiKeyFile
+	if err := json.Unmarshal(data, &keyFile); err != nil {
+		return nil, fmt.Errorf("cannot parse API key file %q: %v", p.path, err)
+	}
+
+	return &auth.APIKey{Name: keyFile.Name, PrivateKey: keyFile.PrivateKey}, nil
+}
+
+// secretStoreCredentialProvider reads the API Key from a SecretStore.
+type secretStoreCredentialProvider struct {
+	store            SecretStore
+	nameSecret       string
+	privateKeySecret string
+}
+
+func (p secretStoreCredentialProvider) APIKey(ctx context.Context) (*auth.APIKey, error) {
+	name, err := p.store.GetSecret(ctx, p.nameSecret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secret %q: %v", p.nameSecret, err)
+	}
+
+	privateKey, err := p.store.GetSecret(ctx, p.privateKeySecret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read secret %q: %v", p.privateKeySecret, err)
+	}
+
+	return &auth.APIKey{Name: name, PrivateKey: privateKey}, nil
+}
+
+// fileSecretStore is a SecretStore backed by a directory holding one file per
+// secret, as produced by Docker and Kubernetes secret mounts.
+type fileSecretStore struct {
+	dir string
+}
+
+func (s fileSecretStore) GetSecret(ctx context.Context, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// newCredentialProvider returns the CredentialProvider for the given source.
+func newCredentialProvider(source, keyFile, secretsDir string) (CredentialProvider, error) {
+	switch source {
+	case "env":
+		return envCredentialProvider{}, nil
+	case "file":
+		if keyFile == "" {
+			return nil, errors.New("-credentials-file must be set when -credentials=file")
+		}
+		return keyFileCredentialProvider{path: keyFile}, nil
+	case "secrets":
+		if secretsDir == "" {
+			return nil, errors.New("-secrets-dir must be set when -credentials=secrets")
+		}
+		return secretStoreCredentialProvider{
+			store:            fileSecretStore{dir: secretsDir},
+			nameSecret:       defaultNameSecret,
+			privateKeySecret: defaultPrivateKeySecret,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown credentials source %q", source)
+	}
+}
+
+// loadAPIKey loads the API Key from the provider and rejects missing or placeholder values.
+func loadAPIKey(ctx context.Context, provider CredentialProvider) (*auth.APIKey, error) {
+	apiKey, err := provider.APIKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateCredential("API key name", apiKey.Name); err != nil {
+		return nil, err
+	}
+	if err := validateCredential("API key private key", apiKey.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	return apiKey, nil
+}
+
+// validateCredential rejects empty values and unfilled placeholders such as <YOUR_API_KEY_NAME>.
+func validateCredential(field, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fmt.Errorf("%s is empty", field)
+	}
+	if strings.HasPrefix(value, "<") && strings.HasSuffix(value, ">") {
+		return fmt.Errorf("%s is still the placeholder %s", field, value)
+	}
+	return nil
+}
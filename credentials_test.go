@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFile writes contents to the named file of dir, failing the test on error.
+func writeTestFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCredentialProviders(t *testing.T) {
+	t.Setenv(apiKeyNameEnvVar, "organizations/org/apiKeys/env-key")
+	t.Setenv(apiKeyPrivateKeyEnvVar, "env-private-key")
+
+	dir := t.TempDir()
+	keyFile := writeTestFile(t, dir, "key.json", `{"name": "organizations/org/apiKeys/file-key", "privateKey": "file-private-key"}`)
+	writeTestFile(t, dir, defaultNameSecret, "organizations/org/apiKeys/secret-key\n")
+	writeTestFile(t, dir, defaultPrivateKeySecret, "secret-private-key\n")
+
+	for _, tt := range []struct {
+		source   string
+		wantName string
+	}{
+		{source: "env", wantName: "organizations/org/apiKeys/env-key"},
+		{source: "file", wantName: "organizations/org/apiKeys/file-key"},
+		{source: "secrets", wantName: "organizations/org/apiKeys/secret-key"},
+	} {
+		provider, err := newCredentialProvider(tt.source, keyFile, dir)
+		if err != nil {
+			t.Fatalf("newCredentialProvider(%s) = %v", tt.source, err)
+		}
+		apiKey, err := loadAPIKey(context.Background(), provider)
+		if err != nil {
+			t.Errorf("loadAPIKey(%s) = %v", tt.source, err)
+			continue
+		}
+		if apiKey.Name != tt.wantName || !strings.HasSuffix(apiKey.PrivateKey, "private-key") {
+			t.Errorf("loadAPIKey(%s) = %+v, want the key named %s", tt.source, apiKey, tt.wantName)
+		}
+	}
+}
+
+func TestCredentialProviderErrors(t *testing.T) {
+	for _, tt := range []struct {
+		source, keyFile, secretsDir string
+	}{
+		{source: "file"},
+		{source: "secrets"},
+		{source: "vault"},
+	} {
+		if _, err := newCredentialProvider(tt.source, tt.keyFile, tt.secretsDir); err == nil {
+			t.Errorf("newCredentialProvider(%q, %q, %q) succeeded, want an error", tt.source, tt.keyFile, tt.secretsDir)
+		}
+	}
+}
+
+func TestLoadAPIKeyErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, contents := range map[string]string{
+		"missing-name.json": `{"privateKey": "private-key"}`,
+		"placeholder.json":  `{"name": "<YOUR_API_KEY_NAME>", "privateKey": "<YOUR_PRIVATE_KEY>"}`,
+		"malformed.json":    `{"name": `,
+	} {
+		provider := keyFileCredentialProvider{path: writeTestFile(t, dir, name, contents)}
+		if apiKey, err := loadAPIKey(context.Background(), provider); err == nil {
+			t.Errorf("loadAPIKey(%s) = %+v, want an error", name, apiKey)
+		}
+	}
+
+	if _, err := loadAPIKey(context.Background(), keyFileCredentialProvider{path: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Error("loadAPIKey() of a missing key file succeeded, want an error")
+	}
+
+	t.Setenv(apiKeyNameEnvVar, "")
+	if _, err := loadAPIKey(context.Background(), envCredentialProvider{}); err == nil {
+		t.Errorf("loadAPIKey() without %s succeeded, want an error", apiKeyNameEnvVar)
+	}
+}
+
+func TestFileSecretStoreRejectsPaths(t *testing.T) {
+	store := fileSecretStore{dir: t.TempDir()}
+	for _, name := range []string{"", ".", "..", "../key", `dir\key`} {
+		if _, err := store.GetSecret(context.Background(), name); err == nil {
+			t.Errorf("GetSecret(%q) succeeded, want an error", name)
+		}
+	}
+}
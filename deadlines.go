@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// requestTimeoutHeader lets callers override the deadline of a single request.
+	requestTimeoutHeader = "X-Request-Timeout"
+
+	// rpcContextKey is the gin context key holding the name of the RPC served by the route.
+	rpcContextKey = "rpc"
+
+	// maxRequestTimeout caps the deadline a caller may request with X-Request-Timeout.
+	maxRequestTimeout = 10 * time.Minute
+
+	// Default deadlines by kind of route.
+	defaultGetTimeout       = 10 * time.Second
+	defaultListTimeout      = time.Minute
+	defaultMutationTimeout  = 30 * time.Second
+	defaultOperationTimeout = 2 * time.Minute
+)
+
+// routeTimeoutsFlag overrides the deadline of individual routes, keyed by RPC name.
+var routeTimeoutsFlag = flag.String("route-timeouts", "", "comma-separated per-route deadlines keyed by RPC name, e.g. GetNetwork=2s,CreateMPCTransaction=5m")
+
+// routeTimeouts holds the parsed -route-timeouts overrides.
+var routeTimeouts map[string]time.Duration
+
+// parseRouteTimeouts parses a comma-separated list of RPC=duration pairs.
+func parseRouteTimeouts(value string) (map[string]time.Duration, error) {
+	timeouts := map[string]time.Duration{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		route, duration, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("route timeout %q must have the form RPC=duration", pair)
+		}
+		timeout, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("route timeout %q: %v", pair, err)
+		}
+		if timeout <= 0 {
+			return nil, fmt.Errorf("route timeout %q must be positive", pair)
+		}
+		timeouts[route] = timeout
+	}
+	return timeouts, nil
+}
+
+// routeTimeout returns the deadline of the route serving the given RPC. Routes starting
+// long-running operations get enough time to wait for them.
+func routeTimeout(route string) time.Duration {
+	if timeout, ok := routeTimeouts[route]; ok {
+		return timeout
+	}
+
+	switch {
+	case route == "GetOperation" || route == "ApprovePendingTransaction" || route == operationCreateDeviceGroup || route == operationCreateSignature ||
+		route == operationCreateMPCWallet || route == operationCreateMPCTransaction:
+		return defaultOperationTimeout
+	case strings.HasPrefix(route, "Get"):
+		return defaultGetTimeout
+	case strings.HasPrefix(route, "List"):
+		return defaultListTimeout
+	default:
+		return defaultMutationTimeout
+	}
+}
+
+// parseRequestTimeout parses an X-Request-Timeout value, either a Go duration such
+// as "1m30s" or a number of seconds.
+func parseRequestTimeout(value string) (time.Duration, error) {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, parseErr := strconv.ParseFloat(value, 64)
+		if parseErr != nil {
+			return 0, fmt.Errorf("cannot parse %s %q as a duration or number of seconds", requestTimeoutHeader, value)
+		}
+		timeout = time.Duration(seconds * float64(time.Second))
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("%s must be positive, got %q", requestTimeoutHeader, value)
+	}
+	if timeout > maxRequestTimeout {
+		timeout = maxRequestTimeout
+	}
+	return timeout, nil
+}
+
+// deadline returns middleware bounding the request context of the route serving the
+// given RPC by its deadline, or by the caller's X-Request-Timeout header when present.
+// The request context is also cancelled when the client disconnects. The RPC name is
+// recorded in the gin context for the middleware running after the handler, and in the
+// request context for the upstream transport.
+func deadline(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(rpcContextKey, route)
+
+		timeout := routeTimeout(route)
+		if value := c.GetHeader(requestTimeoutHeader); value != "" {
+			var err error
+			if timeout, err = parseRequestTimeout(value); err != nil {
+				writeBadRequest(c, err)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(withRPC(c.Request.Context(), route), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
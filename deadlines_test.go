@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteTimeout(t *testing.T) {
+	defer func(timeouts map[string]time.Duration) { routeTimeouts = timeouts }(routeTimeouts)
+
+	var err error
+	if routeTimeouts, err = parseRouteTimeouts(" GetNetwork=2s, CreateMPCTransaction=5m,"); err != nil {
+		t.Fatalf("parseRouteTimeouts() = %v", err)
+	}
+
+	for route, want := range map[string]time.Duration{
+		"GetNetwork":                  2 * time.Second,
+		"GetPool":                     defaultGetTimeout,
+		"ListPools":                   defaultListTimeout,
+		"CreatePool":                  defaultMutationTimeout,
+		operationCreateMPCWallet:      defaultOperationTimeout,
+		operationCreateMPCTransaction: 5 * time.Minute,
+		"GetOperation":                defaultOperationTimeout,
+	} {
+		if got := routeTimeout(route); got != want {
+			t.Errorf("routeTimeout(%s) = %v, want %v", route, got, want)
+		}
+	}
+
+	for _, value := range []string{"GetNetwork", "GetNetwork=soon", "GetNetwork=0s", "GetNetwork=-1s"} {
+		if _, err := parseRouteTimeouts(value); err == nil {
+			t.Errorf("parseRouteTimeouts(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestParseRequestTimeout(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "1m30s", want: 90 * time.Second},
+		{value: "30", want: 30 * time.Second},
+		{value: "0.5", want: 500 * time.Millisecond},
+		{value: "1h", want: maxRequestTimeout},
+		{value: "0", wantErr: true},
+		{value: "-5s", wantErr: true},
+		{value: "soon", wantErr: true},
+	} {
+		got, err := parseRequestTimeout(tt.value)
+		if (err != nil) != tt.wantErr || (err == nil && got != tt.want) {
+			t.Errorf("parseRequestTimeout(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestDeadlineMiddleware(t *testing.T) {
+	var remaining time.Duration
+	router := gin.New()
+	router.GET("/pools/:poolId", deadline("GetPool"), func(c *gin.Context) {
+		if deadline, ok := c.Request.Context().Deadline(); ok {
+			remaining = time.Until(deadline)
+		}
+		c.Status(http.StatusOK)
+	})
+	// The operation never finishes: ?wait=true returns it pending shortly before the deadline.
+	router.POST("/pools", deadline(operationCreateMPCWallet), func(c *gin.Context) {
+		writeOperation[*pools.Pool, *pools.Pool](c, operationCreateMPCWallet, newTestOperation(false, nil), false)
+	})
+
+	for _, tt := range []struct {
+		timeout    string
+		wantStatus int
+		want       time.Duration
+	}{
+		{wantStatus: http.StatusOK, want: defaultGetTimeout},
+		{timeout: "2s", wantStatus: http.StatusOK, want: 2 * time.Second},
+		{timeout: "never", wantStatus: http.StatusBadRequest},
+	} {
+		remaining = 0
+		r := httptest.NewRequest(http.MethodGet, "/pools/pool-1", nil)
+		if tt.timeout != "" {
+			r.Header.Set(requestTimeoutHeader, tt.timeout)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != tt.wantStatus || remaining > tt.want || remaining < tt.want-time.Second {
+			t.Errorf("GET with %s %q = %d with %v remaining, want %d with %v", requestTimeoutHeader, tt.timeout, w.Code, remaining, tt.wantStatus, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/pools?wait=true", nil)
+	r.Header.Set(requestTimeoutHeader, "1.2s")
+	w := httptest.NewRecorder()
+	start := time.Now()
+	router.ServeHTTP(w, r)
+	if elapsed := time.Since(start); w.Code != http.StatusAccepted || elapsed > time.Second {
+		t.Errorf("POST ?wait=true with a 1.2s deadline = %d after %v, want the pending operation within the deadline", w.Code, elapsed)
+	}
+}
@@ -0,0 +1,1149 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1clients "github.com/coinbase/waas-client-library-go/clients/v1"
+	blockchain "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/blockchain/v1"
+	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	mpcWallet "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_wallets/v1"
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	ethereum "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/ethereum/v1"
+	protocols "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/v1"
+	v1types "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/types/v1"
+	cryptoTypes "github.com/coinbase/waas-client-library-go/gen/go/coinbase/crypto/types/v1"
+	"github.com/googleapis/gax-go/v2"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Values of -backend.
+	backendWaaS     = "waas"
+	backendEmulator = "emulator"
+	backendReplay   = "replay"
+
+	// localOperationPollInterval is how often waiting on a local operation checks whether
+	// it is done.
+	localOperationPollInterval = 50 * time.Millisecond
+
+	// Fee and gas limit of the native transfers constructed by the emulator without a fee.
+	emulatorMaxFeePerGas         = "30000000000"
+	emulatorMaxPriorityFeePerGas = "1500000000"
+	emulatorTransferGas          = 21000
+)
+
+var (
+	// backend selects the services behind the routes.
+	backend = flag.String("backend", backendWaaS, "services behind the routes: waas, emulator to serve them from an in-memory WaaS emulator needing no credentials, or replay to serve the cassettes of -cassette-dir")
+
+	// emulatorFixtureFile is the initial state of the emulator.
+	emulatorFixtureFile = flag.String("emulator-fixture", "", "path to the JSON file of the networks, assets and address balances the emulator starts with; a Goerli network funding each address with 10 ETH if unset")
+
+	// emulatorOperationDelay is how long the MPC operations of the emulator take.
+	emulatorOperationDelay = flag.Duration("emulator-operation-delay", 2*time.Second, "how long the emulator takes to create device groups and wallets and to sign payloads and transactions")
+
+	// emulatorBlockTime is how long broadcast transactions take to confirm in the emulator.
+	emulatorBlockTime = flag.Duration("emulator-block-time", 5*time.Second, "how long the emulator takes to confirm a broadcast transaction")
+)
+
+// defaultEmulatorFixture is the initial state of the emulator without -emulator-fixture.
+const defaultEmulatorFixture = `{
+  "networks": [{
+    "name": "networks/ethereum-goerli",
+    "displayName": "Ethereum Goerli",
+    "nativeAsset": "networks/ethereum-goerli/assets/eth",
+    "protocolFamily": "protocolFamilies/evm",
+    "type": "TESTNET"
+  }],
+  "assets": [{
+    "name": "networks/ethereum-goerli/assets/eth",
+    "advertisedSymbol": "ETH",
+    "decimals": 18,
+    "definition": {"assetType": "native"}
+  }],
+  "balances": [{"asset": "networks/ethereum-goerli/assets/eth", "amount": "10000000000000000000"}]
+}`
+
+// resourceID matches the IDs of the resources created by the emulator and the fakes.
+var resourceID = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// resourceIDs numbers the resources and operations created by the emulator and the fakes.
+var resourceIDs atomic.Int64
+
+// newResourceID returns a new resource ID with the given prefix.
+func newResourceID(prefix string) string {
+	return prefix + "-" + strconv.FormatInt(resourceIDs.Add(1), 10)
+}
+
+// checkName returns INVALID_ARGUMENT unless name is made of the given collections, each
+// followed by a valid ID, as WaaS does for malformed resource names.
+func checkName(name string, collections ...string) error {
+	segments := strings.Split(name, "/")
+	if len(segments) != 2*len(collections) {
+		return status.Errorf(codes.InvalidArgument, "invalid resource name %q", name)
+	}
+	for i, collection := range collections {
+		if segments[2*i] != collection || !resourceID.MatchString(segments[2*i+1]) {
+			return status.Errorf(codes.InvalidArgument, "invalid resource name %q", name)
+		}
+	}
+	return nil
+}
+
+// namedResource is a WaaS resource.
+type namedResource interface {
+	proto.Message
+	GetName() string
+}
+
+// resourceCollection holds resources by name. The resources it holds are never modified:
+// updates put a modified copy instead, so that callers may read them without locking.
+type resourceCollection[T namedResource] struct {
+	mu    sync.Mutex
+	items map[string]T
+}
+
+func (c *resourceCollection[T]) put(item T) T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.items == nil {
+		c.items = map[string]T{}
+	}
+	c.items[item.GetName()] = item
+	return item
+}
+
+func (c *resourceCollection[T]) get(name string) (T, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, ok := c.items[name]
+	if !ok {
+		return item, status.Errorf(codes.NotFound, "%s not found", name)
+	}
+	return item, nil
+}
+
+func (c *resourceCollection[T]) delete(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.items, name)
+}
+
+// list returns the resources whose name starts with prefix and matching keep, if set,
+// ordered by name.
+func (c *resourceCollection[T]) list(prefix string, keep func(T) bool) []T {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := []T{}
+	for name, item := range c.items {
+		if strings.HasPrefix(name, prefix) && (keep == nil || keep(item)) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].GetName() < items[j].GetName() })
+	return items
+}
+
+// pageIterator iterates over the items of the pages returned by fetch, as the v1clients
+// iterators do over the pages of a List RPC.
+type pageIterator[T any] struct {
+	pageInfo *iterator.PageInfo
+	nextFunc func() error
+	items    []T
+}
+
+func newPageIterator[T any](fetch func(pageSize int, pageToken string) ([]T, string, error)) *pageIterator[T] {
+	it := &pageIterator[T]{}
+	it.pageInfo, it.nextFunc = iterator.NewPageInfo(
+		func(pageSize int, pageToken string) (string, error) {
+			items, nextPageToken, err := fetch(pageSize, pageToken)
+			if err != nil {
+				return "", err
+			}
+			it.items = append(it.items, items...)
+			return nextPageToken, nil
+		},
+		func() int { return len(it.items) },
+		func() interface{} { b := it.items; it.items = nil; return b })
+	return it
+}
+
+// newSliceIterator iterates over items in pages, whose tokens are the offset of their
+// first item, or fails with err.
+func newSliceIterator[T any](items []T, err error) *pageIterator[T] {
+	return newPageIterator(func(pageSize int, pageToken string) ([]T, string, error) {
+		if err != nil {
+			return nil, "", err
+		}
+		start := 0
+		if pageToken != "" {
+			var convErr error
+			if start, convErr = strconv.Atoi(pageToken); convErr != nil || start < 0 || start > len(items) {
+				return nil, "", status.Errorf(codes.InvalidArgument, "invalid page token %q", pageToken)
+			}
+		}
+		end := len(items)
+		if pageSize > 0 && start+pageSize < end {
+			end = start + pageSize
+		}
+		if end == len(items) {
+			return items[start:end], "", nil
+		}
+		return items[start:end], strconv.Itoa(end), nil
+	})
+}
+
+func (it *pageIterator[T]) PageInfo() *iterator.PageInfo {
+	return it.pageInfo
+}
+
+func (it *pageIterator[T]) Next() (T, error) {
+	var item T
+	if err := it.nextFunc(); err != nil {
+		return item, err
+	}
+	item, it.items = it.items[0], it.items[1:]
+	return item, nil
+}
+
+// The v1clients iterators also expose the raw page, which the proxy does not use.
+
+type networkPageIterator struct {
+	*pageIterator[*blockchain.Network]
+}
+
+func (networkPageIterator) Response() *blockchain.ListNetworksResponse { return nil }
+
+type assetPageIterator struct {
+	*pageIterator[*blockchain.Asset]
+}
+
+func (assetPageIterator) Response() *blockchain.ListAssetsResponse { return nil }
+
+type mpcTransactionPageIterator struct {
+	*pageIterator[*mpcTransactions.MPCTransaction]
+}
+
+func (mpcTransactionPageIterator) Response() *mpcTransactions.ListMPCTransactionsResponse {
+	return nil
+}
+
+type mpcWalletPageIterator struct {
+	*pageIterator[*mpcWallet.MPCWallet]
+}
+
+func (mpcWalletPageIterator) Response() *mpcWallet.ListMPCWalletsResponse { return nil }
+
+type addressPageIterator struct {
+	*pageIterator[*mpcWallet.Address]
+}
+
+func (addressPageIterator) Response() *mpcWallet.ListAddressesResponse { return nil }
+
+type balancePageIterator struct {
+	*pageIterator[*mpcWallet.Balance]
+}
+
+func (balancePageIterator) Response() *mpcWallet.ListBalancesResponse { return nil }
+
+type poolPageIterator struct {
+	*pageIterator[*pools.Pool]
+}
+
+func (poolPageIterator) Response() *pools.ListPoolsResponse { return nil }
+
+// localOperation is a long-running operation run in process. Like the operations of the
+// v1clients, it reports the state seen by its latest poll, which asks check whether the
+// operation is done and with which result. An error from check fails the poll itself
+// when the operation is not done, and the operation otherwise.
+type localOperation[R, M proto.Message] struct {
+	name     string
+	metadata M
+	check    func() (result R, done bool, err error)
+
+	mu     sync.Mutex
+	result R
+	done   bool
+	err    error
+}
+
+// newLocalOperation returns an operation polled once already.
+func newLocalOperation[R, M proto.Message](name string, metadata M, check func() (R, bool, error)) *localOperation[R, M] {
+	op := &localOperation[R, M]{name: name, metadata: metadata, check: check}
+	op.poll()
+	return op
+}
+
+// failedOperation returns an operation whose polls fail with err.
+func failedOperation[R, M proto.Message](name string, err error) *localOperation[R, M] {
+	var metadata M
+	return newLocalOperation(name, metadata, func() (R, bool, error) {
+		var result R
+		return result, false, err
+	})
+}
+
+func (op *localOperation[R, M]) poll() (R, bool, error) {
+	result, done, err := op.check()
+
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	op.result, op.done, op.err = result, done, err
+	return result, done, err
+}
+
+func (op *localOperation[R, M]) Name() string         { return op.name }
+func (op *localOperation[R, M]) Metadata() (M, error) { return op.metadata, nil }
+
+func (op *localOperation[R, M]) Done() bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	return op.done
+}
+
+func (op *localOperation[R, M]) Poll(ctx context.Context, opts ...gax.CallOption) (R, error) {
+	result, done, err := op.poll()
+	if !done {
+		var zero R
+		return zero, err
+	}
+	return result, err
+}
+
+func (op *localOperation[R, M]) Wait(ctx context.Context, opts ...gax.CallOption) (R, error) {
+	ticker := time.NewTicker(localOperationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		result, done, err := op.poll()
+		if done || err != nil {
+			return result, err
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// localOperations holds local operations by name, and the names of the operations
+// started by requests carrying a request ID.
+type localOperations struct {
+	mu  sync.Mutex
+	ops map[string]interface{}
+
+	// requestMu serializes the requests carrying a request ID, so that concurrent retries
+	// start a single operation.
+	requestMu sync.Mutex
+	requests  map[string]string
+}
+
+func (o *localOperations) put(op interface{ Name() string }) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.ops == nil {
+		o.ops = map[string]interface{}{}
+	}
+	o.ops[op.Name()] = op
+}
+
+// lookupOperation returns the named operation of o, or one failing with NOT_FOUND if o
+// holds no such operation of that type.
+func lookupOperation[R, M proto.Message](o *localOperations, name string) *localOperation[R, M] {
+	o.mu.Lock()
+	op, ok := o.ops[name].(*localOperation[R, M])
+	o.mu.Unlock()
+
+	if !ok {
+		return failedOperation[R, M](name, status.Errorf(codes.NotFound, "%s not found", name))
+	}
+	return op
+}
+
+// startOnce returns the operation started by an earlier rpc request with requestID, or
+// starts one with start. As in WaaS, retries are not checked against the original request.
+// Requests without a request ID always start an operation.
+func startOnce[R, M proto.Message](o *localOperations, rpc, requestID string, start func() (*localOperation[R, M], error)) (*localOperation[R, M], error) {
+	if requestID == "" {
+		return start()
+	}
+
+	o.requestMu.Lock()
+	defer o.requestMu.Unlock()
+
+	key := rpc + "/" + requestID
+	if name, ok := o.requests[key]; ok {
+		return lookupOperation[R, M](o, name), nil
+	}
+	op, err := start()
+	if err != nil {
+		return nil, err
+	}
+	if o.requests == nil {
+		o.requests = map[string]string{}
+	}
+	o.requests[key] = op.Name()
+	return op, nil
+}
+
+// awaitResource returns the check of an operation done once c holds the named resource.
+func awaitResource[T namedResource](c *resourceCollection[T], name string) func() (T, bool, error) {
+	return func() (T, bool, error) {
+		item, err := c.get(name)
+		return item, err == nil, nil
+	}
+}
+
+// emulatorFixture is the initial state of the emulator. Networks and assets are in the
+// proto JSON encoding. Each balance is credited to every address generated on the network
+// of its asset.
+type emulatorFixture struct {
+	Networks []json.RawMessage `json:"networks"`
+	Assets   []json.RawMessage `json:"assets"`
+	Balances []emulatorBalance `json:"balances"`
+}
+
+// emulatorBalance is an amount of an asset, in base units.
+type emulatorBalance struct {
+	Asset  string `json:"asset"`
+	Amount string `json:"amount"`
+}
+
+// emulator is a stateful in-memory stand-in for the WaaS services, for local development
+// and tests without a WaaS account. It implements the six service interfaces and keeps
+// every resource created until the process exits.
+//
+// Device groups, wallets and signatures are created operationDelay after being requested,
+// and MPC transactions move from CREATED through SIGNING, SIGNED and CONFIRMING to
+// CONFIRMED, or FAILED when their sender cannot fund them. Signing takes operationDelay
+// and confirmation blockTime. Signatures, addresses and hashes are derived from resource
+// names: they are valid on no network. Like WaaS, retries of the requests starting an
+// operation with the same request ID return the operation of the first.
+type emulator struct {
+	operationDelay time.Duration
+	blockTime      time.Duration
+	seedBalances   []emulatorBalance
+
+	networks        resourceCollection[*blockchain.Network]
+	assets          resourceCollection[*blockchain.Asset]
+	pools           resourceCollection[*pools.Pool]
+	devices         resourceCollection[*mpcKeys.Device]
+	deviceGroups    resourceCollection[*mpcKeys.DeviceGroup]
+	mpcKeys         resourceCollection[*mpcKeys.MPCKey]
+	mpcOperations   resourceCollection[*mpcKeys.MPCOperation]
+	signatures      resourceCollection[*mpcKeys.Signature]
+	mpcWallets      resourceCollection[*mpcWallet.MPCWallet]
+	addresses       resourceCollection[*mpcWallet.Address]
+	balances        resourceCollection[*mpcWallet.Balance]
+	mpcTransactions resourceCollection[*mpcTransactions.MPCTransaction]
+	operations      localOperations
+
+	// mu serializes the changes of balances and the generation of addresses.
+	mu sync.Mutex
+}
+
+// loadEmulator returns an emulator starting with the fixture at path, or the default one
+// if path is empty.
+func loadEmulator(path string, operationDelay, blockTime time.Duration) (*emulator, error) {
+	fixture := []byte(defaultEmulatorFixture)
+	if path != "" {
+		var err error
+		if fixture, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return newEmulator(fixture, operationDelay, blockTime)
+}
+
+// newEmulator returns an emulator starting with the given JSON fixture.
+func newEmulator(fixture []byte, operationDelay, blockTime time.Duration) (*emulator, error) {
+	var contents emulatorFixture
+	if err := json.Unmarshal(fixture, &contents); err != nil {
+		return nil, fmt.Errorf("cannot parse emulator fixture: %v", err)
+	}
+
+	e := &emulator{operationDelay: operationDelay, blockTime: blockTime, seedBalances: contents.Balances}
+	for _, raw := range contents.Networks {
+		network := &blockchain.Network{}
+		if err := unmarshalOptions.Unmarshal(raw, network); err != nil {
+			return nil, fmt.Errorf("cannot parse emulator network: %v", err)
+		}
+		if err := checkName(network.GetName(), "networks"); err != nil {
+			return nil, err
+		}
+		e.networks.put(network)
+	}
+	for _, raw := range contents.Assets {
+		asset := &blockchain.Asset{}
+		if err := unmarshalOptions.Unmarshal(raw, asset); err != nil {
+			return nil, fmt.Errorf("cannot parse emulator asset: %v", err)
+		}
+		if err := checkName(asset.GetName(), "networks", "assets"); err != nil {
+			return nil, err
+		}
+		if _, err := e.networks.get(parentName(asset.GetName())); err != nil {
+			return nil, fmt.Errorf("asset %s: %v", asset.GetName(), err)
+		}
+		e.assets.put(asset)
+	}
+	for _, balance := range contents.Balances {
+		if _, err := e.assets.get(balance.Asset); err != nil {
+			return nil, fmt.Errorf("balance of %s: %v", balance.Asset, err)
+		}
+		if amount, ok := new(big.Int).SetString(balance.Amount, 10); !ok || amount.Sign() < 0 {
+			return nil, fmt.Errorf("balance of %s: invalid amount %q", balance.Asset, balance.Amount)
+		}
+	}
+	return e, nil
+}
+
+// services returns the emulator as the services of a router.
+func (e *emulator) services() *services {
+	return &services{
+		blockchain:     e,
+		mpcKey:         e,
+		mpcTransaction: e,
+		mpcWallet:      e,
+		pool:           e,
+		protocol:       e,
+	}
+}
+
+// parentName returns the name of the parent of the named resource.
+func parentName(name string) string {
+	segments := strings.Split(name, "/")
+	if len(segments) < 2 {
+		return ""
+	}
+	return strings.Join(segments[:len(segments)-2], "/")
+}
+
+// lastID returns the ID of the named resource.
+func lastID(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// emulatedDigest returns the SHA-256 digest of parts, which stands in for the keys,
+// signatures and hashes WaaS would compute.
+func emulatedDigest(parts ...string) []byte {
+	digest := sha256.New()
+	for _, part := range parts {
+		digest.Write([]byte(part))
+		digest.Write([]byte{0})
+	}
+	return digest.Sum(nil)
+}
+
+// emulatedSignature returns the signature of payload by the named key.
+func emulatedSignature(key string, payload []byte) *cryptoTypes.Signature {
+	return &cryptoTypes.Signature{Signature: &cryptoTypes.Signature_EcdsaSignature{EcdsaSignature: &cryptoTypes.ECDSASignature{
+		R: emulatedDigest("r", key, string(payload)),
+		S: emulatedDigest("s", key, string(payload)),
+	}}}
+}
+
+// emulatedTransaction returns transaction input to be signed by a single signature.
+func emulatedTransaction(input *v1types.TransactionInput) *v1types.Transaction {
+	encoded, _ := proto.MarshalOptions{Deterministic: true}.Marshal(input)
+	return &v1types.Transaction{
+		Input:              input,
+		RequiredSignatures: []*v1types.RequiredSignature{{Payload: emulatedDigest(string(encoded))}},
+	}
+}
+
+func (e *emulator) GetNetwork(ctx context.Context, req *blockchain.GetNetworkRequest, opts ...gax.CallOption) (*blockchain.Network, error) {
+	if err := checkName(req.GetName(), "networks"); err != nil {
+		return nil, err
+	}
+	return e.networks.get(req.GetName())
+}
+
+func (e *emulator) ListNetworks(ctx context.Context, req *blockchain.ListNetworksRequest, opts ...gax.CallOption) v1clients.NetworkIterator {
+	return networkPageIterator{newSliceIterator(e.networks.list("networks/", nil), nil)}
+}
+
+func (e *emulator) GetAsset(ctx context.Context, req *blockchain.GetAssetRequest, opts ...gax.CallOption) (*blockchain.Asset, error) {
+	if err := checkName(req.GetName(), "networks", "assets"); err != nil {
+		return nil, err
+	}
+	return e.assets.get(req.GetName())
+}
+
+func (e *emulator) ListAssets(ctx context.Context, req *blockchain.ListAssetsRequest, opts ...gax.CallOption) v1clients.AssetIterator {
+	err := checkName(req.GetParent(), "networks")
+	if err == nil {
+		_, err = e.networks.get(req.GetParent())
+	}
+	return assetPageIterator{newSliceIterator(e.assets.list(req.GetParent()+"/assets/", nil), err)}
+}
+
+func (e *emulator) RegisterDevice(ctx context.Context, req *mpcKeys.RegisterDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error) {
+	if len(req.GetRegistrationData()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "registration_data is required")
+	}
+	return e.devices.put(&mpcKeys.Device{Name: "devices/" + newResourceID("device")}), nil
+}
+
+func (e *emulator) GetDevice(ctx context.Context, req *mpcKeys.GetDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error) {
+	if err := checkName(req.GetName(), "devices"); err != nil {
+		return nil, err
+	}
+	return e.devices.get(req.GetName())
+}
+
+// startDeviceGroup creates the named device group of devices after the operation delay,
+// listing its MPC operation meanwhile.
+func (e *emulator) startDeviceGroup(name string, devices []string) {
+	mpcOperation := e.mpcOperations.put(&mpcKeys.MPCOperation{
+		Name:    name + "/mpcOperations/" + newResourceID("mpc-operation"),
+		MpcData: emulatedDigest("mpc-data", name),
+		Metadata: &mpcKeys.MPCOperation_CreateDeviceGroupMetadata{
+			CreateDeviceGroupMetadata: &mpcKeys.CreateDeviceGroupMetadata{DeviceGroup: name},
+		},
+	})
+
+	time.AfterFunc(e.operationDelay, func() {
+		e.deviceGroups.put(&mpcKeys.DeviceGroup{Name: name, Devices: devices})
+		e.mpcOperations.delete(mpcOperation.GetName())
+	})
+}
+
+func (e *emulator) CreateDeviceGroup(ctx context.Context, req *mpcKeys.CreateDeviceGroupRequest, opts ...gax.CallOption) (createDeviceGroupOperation, error) {
+	op, err := startOnce(&e.operations, operationCreateDeviceGroup, req.GetRequestId(), func() (*localOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata], error) {
+		return e.createDeviceGroup(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// createDeviceGroup starts the operation of a CreateDeviceGroup request.
+func (e *emulator) createDeviceGroup(ctx context.Context, req *mpcKeys.CreateDeviceGroupRequest) (*localOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata], error) {
+	if err := checkName(req.GetParent(), "pools"); err != nil {
+		return nil, err
+	}
+	if _, err := e.pools.get(req.GetParent()); err != nil {
+		return nil, err
+	}
+	devices := req.GetDeviceGroup().GetDevices()
+	if len(devices) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "device_group.devices is required")
+	}
+	for _, device := range devices {
+		if _, err := e.GetDevice(ctx, &mpcKeys.GetDeviceRequest{Name: device}); err != nil {
+			return nil, err
+		}
+	}
+
+	id := req.GetDeviceGroupId()
+	if id == "" {
+		id = newResourceID("device-group")
+	}
+	name := req.GetParent() + "/deviceGroups/" + id
+	if err := checkName(name, "pools", "deviceGroups"); err != nil {
+		return nil, err
+	}
+	if _, err := e.deviceGroups.get(name); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "%s already exists", name)
+	}
+
+	e.startDeviceGroup(name, devices)
+	op := newLocalOperation("operations/"+newResourceID("operation"), &mpcKeys.CreateDeviceGroupMetadata{DeviceGroup: name},
+		awaitResource(&e.deviceGroups, name))
+	e.operations.put(op)
+	return op, nil
+}
+
+func (e *emulator) CreateDeviceGroupOperation(name string) createDeviceGroupOperation {
+	return lookupOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata](&e.operations, name)
+}
+
+func (e *emulator) GetDeviceGroup(ctx context.Context, req *mpcKeys.GetDeviceGroupRequest, opts ...gax.CallOption) (*mpcKeys.DeviceGroup, error) {
+	if err := checkName(req.GetName(), "pools", "deviceGroups"); err != nil {
+		return nil, err
+	}
+	return e.deviceGroups.get(req.GetName())
+}
+
+func (e *emulator) ListMPCOperations(ctx context.Context, req *mpcKeys.ListMPCOperationsRequest, opts ...gax.CallOption) (*mpcKeys.ListMPCOperationsResponse, error) {
+	if err := checkName(req.GetParent(), "pools", "deviceGroups"); err != nil {
+		return nil, err
+	}
+	return &mpcKeys.ListMPCOperationsResponse{MpcOperations: e.mpcOperations.list(req.GetParent()+"/mpcOperations/", nil)}, nil
+}
+
+func (e *emulator) CreateMPCKey(ctx context.Context, req *mpcKeys.CreateMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error) {
+	if _, err := e.GetDeviceGroup(ctx, &mpcKeys.GetDeviceGroupRequest{Name: req.GetParent()}); err != nil {
+		return nil, err
+	}
+	if len(req.GetMpcKey().GetDerivationPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "mpc_key.derivation_path is required")
+	}
+
+	return e.mpcKeys.put(&mpcKeys.MPCKey{
+		Name:           req.GetParent() + "/mpcKeys/" + newResourceID("mpc-key"),
+		DerivationPath: req.GetMpcKey().GetDerivationPath(),
+	}), nil
+}
+
+func (e *emulator) GetMPCKey(ctx context.Context, req *mpcKeys.GetMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error) {
+	if err := checkName(req.GetName(), "pools", "deviceGroups", "mpcKeys"); err != nil {
+		return nil, err
+	}
+	return e.mpcKeys.get(req.GetName())
+}
+
+func (e *emulator) CreateSignature(ctx context.Context, req *mpcKeys.CreateSignatureRequest, opts ...gax.CallOption) (createSignatureOperation, error) {
+	op, err := startOnce(&e.operations, operationCreateSignature, req.GetRequestId(), func() (*localOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata], error) {
+		return e.createSignature(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// createSignature starts the operation of a CreateSignature request.
+func (e *emulator) createSignature(ctx context.Context, req *mpcKeys.CreateSignatureRequest) (*localOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata], error) {
+	if _, err := e.GetMPCKey(ctx, &mpcKeys.GetMPCKeyRequest{Name: req.GetParent()}); err != nil {
+		return nil, err
+	}
+	payload := req.GetSignature().GetPayload()
+	if len(payload) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "signature.payload is required")
+	}
+
+	deviceGroup := parentName(req.GetParent())
+	metadata := &mpcKeys.CreateSignatureMetadata{DeviceGroup: deviceGroup}
+	mpcOperation := e.mpcOperations.put(&mpcKeys.MPCOperation{
+		Name:     deviceGroup + "/mpcOperations/" + newResourceID("mpc-operation"),
+		MpcData:  emulatedDigest("mpc-data", req.GetParent(), string(payload)),
+		Metadata: &mpcKeys.MPCOperation_CreateSignatureMetadata{CreateSignatureMetadata: metadata},
+	})
+
+	name := req.GetParent() + "/signatures/" + newResourceID("signature")
+	time.AfterFunc(e.operationDelay, func() {
+		e.signatures.put(&mpcKeys.Signature{Name: name, Payload: payload, Signature: emulatedSignature(req.GetParent(), payload)})
+		e.mpcOperations.delete(mpcOperation.GetName())
+	})
+
+	op := newLocalOperation("operations/"+newResourceID("operation"), metadata, awaitResource(&e.signatures, name))
+	e.operations.put(op)
+	return op, nil
+}
+
+func (e *emulator) CreateSignatureOperation(name string) createSignatureOperation {
+	return lookupOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata](&e.operations, name)
+}
+
+func (e *emulator) CreateMPCTransaction(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error) {
+	op, err := startOnce(&e.operations, operationCreateMPCTransaction, req.GetRequestId(), func() (*localOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata], error) {
+		return e.createMPCTransaction(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// createMPCTransaction starts the operation of a CreateMPCTransaction request.
+func (e *emulator) createMPCTransaction(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest) (*localOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata], error) {
+	wallet, err := e.GetMPCWallet(ctx, &mpcWallet.GetMPCWalletRequest{Name: req.GetParent()})
+	if err != nil {
+		return nil, err
+	}
+	network := req.GetMpcTransaction().GetNetwork()
+	if _, err := e.GetNetwork(ctx, &blockchain.GetNetworkRequest{Name: network}); err != nil {
+		return nil, err
+	}
+	if req.GetInput().GetInput() == nil {
+		return nil, status.Error(codes.InvalidArgument, "input is required")
+	}
+
+	fromAddresses := req.GetMpcTransaction().GetFromAddresses()
+	for _, name := range fromAddresses {
+		address, err := e.GetAddress(ctx, &mpcWallet.GetAddressRequest{Name: name})
+		if err != nil {
+			return nil, err
+		}
+		if parentName(name) != network || address.GetMpcWallet() != wallet.GetName() {
+			return nil, status.Errorf(codes.InvalidArgument, "%s is not an address of %s on %s", name, wallet.GetName(), network)
+		}
+	}
+	if len(fromAddresses) == 0 {
+		addresses := e.addresses.list(network+"/addresses/", func(address *mpcWallet.Address) bool {
+			return address.GetMpcWallet() == wallet.GetName()
+		})
+		if len(addresses) == 0 {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s has no address on %s", wallet.GetName(), network)
+		}
+		fromAddresses = []string{addresses[0].GetName()}
+	}
+
+	mpcTx := e.mpcTransactions.put(&mpcTransactions.MPCTransaction{
+		Name:          req.GetParent() + "/mpcTransactions/" + newResourceID("mpc-transaction"),
+		Network:       network,
+		FromAddresses: fromAddresses,
+		State:         mpcTransactions.MPCTransaction_CREATED,
+		Transaction:   emulatedTransaction(req.GetInput()),
+	})
+	go e.processMPCTransaction(mpcTx.GetName())
+
+	op := newLocalOperation("operations/"+newResourceID("operation"), &mpcTransactions.CreateMPCTransactionMetadata{DeviceGroup: wallet.GetDeviceGroup()},
+		func() (*mpcTransactions.MPCTransaction, bool, error) {
+			// The operation is done once the transaction is broadcast.
+			mpcTx, err := e.mpcTransactions.get(mpcTx.GetName())
+			return mpcTx, err == nil && mpcTx.GetState() >= mpcTransactions.MPCTransaction_CONFIRMING, err
+		})
+	e.operations.put(op)
+	return op, nil
+}
+
+// updateMPCTransaction replaces the named MPC transaction with a copy modified by update.
+func (e *emulator) updateMPCTransaction(name string, update func(mpcTx *mpcTransactions.MPCTransaction)) {
+	mpcTx, err := e.mpcTransactions.get(name)
+	if err != nil {
+		return
+	}
+	mpcTx = proto.Clone(mpcTx).(*mpcTransactions.MPCTransaction)
+	update(mpcTx)
+	e.mpcTransactions.put(mpcTx)
+}
+
+// processMPCTransaction signs, broadcasts and confirms the named MPC transaction.
+func (e *emulator) processMPCTransaction(name string) {
+	e.updateMPCTransaction(name, func(mpcTx *mpcTransactions.MPCTransaction) {
+		mpcTx.State = mpcTransactions.MPCTransaction_SIGNING
+	})
+
+	time.Sleep(e.operationDelay)
+	e.updateMPCTransaction(name, func(mpcTx *mpcTransactions.MPCTransaction) {
+		mpcTx.State = mpcTransactions.MPCTransaction_SIGNED
+		for _, requiredSignature := range mpcTx.Transaction.RequiredSignatures {
+			requiredSignature.Signature = emulatedSignature(mpcTx.FromAddresses[0], requiredSignature.Payload)
+		}
+		mpcTx.Transaction.RawSignedTransaction = emulatedDigest("raw-signed-transaction", mpcTx.Name)
+	})
+
+	e.updateMPCTransaction(name, func(mpcTx *mpcTransactions.MPCTransaction) {
+		mpcTx.State = mpcTransactions.MPCTransaction_CONFIRMING
+		mpcTx.Transaction.Hash = "0x" + hex.EncodeToString(emulatedDigest("hash", string(mpcTx.Transaction.RawSignedTransaction)))
+	})
+
+	time.Sleep(e.blockTime)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.updateMPCTransaction(name, func(mpcTx *mpcTransactions.MPCTransaction) {
+		mpcTx.State = mpcTransactions.MPCTransaction_CONFIRMED
+		if err := e.transferLocked(mpcTx); err != nil {
+			logger.Info("Emulated transaction failed", zap.String("mpcTransaction", mpcTx.Name), zap.Error(err))
+			mpcTx.State = mpcTransactions.MPCTransaction_FAILED
+		}
+	})
+}
+
+// balanceName returns the name of the balance of asset held by the named address.
+func balanceName(address, asset string) string {
+	return address + "/balances/" + lastID(asset)
+}
+
+// transferLocked applies the native transfer of an EIP-1559 MPC transaction to the
+// balances of its sender and, if it is an address of the emulator, its recipient. The
+// sender pays the value and the maximum fee of the transaction.
+func (e *emulator) transferLocked(mpcTx *mpcTransactions.MPCTransaction) error {
+	input := mpcTx.GetTransaction().GetInput().GetEthereum_1559Input()
+	if input == nil {
+		return nil
+	}
+	network, err := e.networks.get(mpcTx.GetNetwork())
+	if err != nil || network.GetNativeAsset() == "" {
+		return err
+	}
+	value, err := parseAmount(input.GetValue())
+	if err != nil {
+		return err
+	}
+	maxFeePerGas, err := parseAmount(input.GetMaxFeePerGas())
+	if err != nil {
+		return err
+	}
+	cost := new(big.Int).Mul(maxFeePerGas, new(big.Int).SetUint64(input.GetGas()))
+	cost.Add(cost, value)
+
+	sender := balanceName(mpcTx.GetFromAddresses()[0], network.GetNativeAsset())
+	balance, err := e.balances.get(sender)
+	if err != nil {
+		return status.Errorf(codes.FailedPrecondition, "insufficient funds: %s holds no %s", mpcTx.GetFromAddresses()[0], network.GetNativeAsset())
+	}
+	amount, _ := new(big.Int).SetString(balance.GetAmount(), 10)
+	if amount.Cmp(cost) < 0 {
+		return status.Errorf(codes.FailedPrecondition, "insufficient funds: %s holds %s of %s, needs %s", mpcTx.GetFromAddresses()[0], amount, network.GetNativeAsset(), cost)
+	}
+	e.creditLocked(sender, network.GetNativeAsset(), balance.GetMpcWallet(), new(big.Int).Neg(cost))
+
+	recipients := e.addresses.list(network.GetName()+"/addresses/", func(address *mpcWallet.Address) bool {
+		return strings.EqualFold(address.GetAddress(), input.GetToAddress())
+	})
+	for _, recipient := range recipients {
+		e.creditLocked(balanceName(recipient.GetName(), network.GetNativeAsset()), network.GetNativeAsset(), recipient.GetMpcWallet(), value)
+	}
+	return nil
+}
+
+// creditLocked adds amount, which may be negative, to the named balance.
+func (e *emulator) creditLocked(name, asset, wallet string, amount *big.Int) {
+	total := new(big.Int).Set(amount)
+	if balance, err := e.balances.get(name); err == nil {
+		current, _ := new(big.Int).SetString(balance.GetAmount(), 10)
+		total.Add(total, current)
+	}
+	e.balances.put(&mpcWallet.Balance{Name: name, Asset: asset, Amount: total.String(), MpcWallet: wallet})
+}
+
+func (e *emulator) CreateMPCTransactionOperation(name string) createMPCTransactionOperation {
+	return lookupOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata](&e.operations, name)
+}
+
+func (e *emulator) GetMPCTransaction(ctx context.Context, req *mpcTransactions.GetMPCTransactionRequest, opts ...gax.CallOption) (*mpcTransactions.MPCTransaction, error) {
+	if err := checkName(req.GetName(), "pools", "mpcWallets", "mpcTransactions"); err != nil {
+		return nil, err
+	}
+	return e.mpcTransactions.get(req.GetName())
+}
+
+func (e *emulator) ListMPCTransactions(ctx context.Context, req *mpcTransactions.ListMPCTransactionsRequest, opts ...gax.CallOption) v1clients.MPCTransactionIterator {
+	_, err := e.GetMPCWallet(ctx, &mpcWallet.GetMPCWalletRequest{Name: req.GetParent()})
+	return mpcTransactionPageIterator{newSliceIterator(e.mpcTransactions.list(req.GetParent()+"/mpcTransactions/", nil), err)}
+}
+
+func (e *emulator) CreateMPCWallet(ctx context.Context, req *mpcWallet.CreateMPCWalletRequest, opts ...gax.CallOption) (createMPCWalletOperation, error) {
+	op, err := startOnce(&e.operations, operationCreateMPCWallet, req.GetRequestId(), func() (*localOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata], error) {
+		return e.createMPCWallet(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+// createMPCWallet starts the operation of a CreateMPCWallet request.
+func (e *emulator) createMPCWallet(ctx context.Context, req *mpcWallet.CreateMPCWalletRequest) (*localOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata], error) {
+	if err := checkName(req.GetParent(), "pools"); err != nil {
+		return nil, err
+	}
+	if _, err := e.pools.get(req.GetParent()); err != nil {
+		return nil, err
+	}
+	if _, err := e.GetDevice(ctx, &mpcKeys.GetDeviceRequest{Name: req.GetDevice()}); err != nil {
+		return nil, err
+	}
+
+	// As in WaaS, the wallet gets a new device group whose only member is the device.
+	deviceGroup := req.GetParent() + "/deviceGroups/" + newResourceID("device-group")
+	e.startDeviceGroup(deviceGroup, []string{req.GetDevice()})
+
+	name := req.GetParent() + "/mpcWallets/" + newResourceID("mpc-wallet")
+	time.AfterFunc(e.operationDelay, func() {
+		e.mpcWallets.put(&mpcWallet.MPCWallet{Name: name, DeviceGroup: deviceGroup})
+	})
+
+	op := newLocalOperation("operations/"+newResourceID("operation"), &mpcWallet.CreateMPCWalletMetadata{DeviceGroup: deviceGroup},
+		awaitResource(&e.mpcWallets, name))
+	e.operations.put(op)
+	return op, nil
+}
+
+func (e *emulator) CreateMPCWalletOperation(name string) createMPCWalletOperation {
+	return lookupOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata](&e.operations, name)
+}
+
+func (e *emulator) GetMPCWallet(ctx context.Context, req *mpcWallet.GetMPCWalletRequest, opts ...gax.CallOption) (*mpcWallet.MPCWallet, error) {
+	if err := checkName(req.GetName(), "pools", "mpcWallets"); err != nil {
+		return nil, err
+	}
+	return e.mpcWallets.get(req.GetName())
+}
+
+func (e *emulator) ListMPCWallets(ctx context.Context, req *mpcWallet.ListMPCWalletsRequest, opts ...gax.CallOption) v1clients.MPCWalletIterator {
+	err := checkName(req.GetParent(), "pools")
+	if err == nil {
+		_, err = e.pools.get(req.GetParent())
+	}
+	return mpcWalletPageIterator{newSliceIterator(e.mpcWallets.list(req.GetParent()+"/mpcWallets/", nil), err)}
+}
+
+func (e *emulator) GenerateAddress(ctx context.Context, req *mpcWallet.GenerateAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error) {
+	wallet, err := e.GetMPCWallet(ctx, &mpcWallet.GetMPCWalletRequest{Name: req.GetMpcWallet()})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.GetNetwork(ctx, &blockchain.GetNetworkRequest{Name: req.GetNetwork()}); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Each address is derived from a new key of the wallet's device group, at the next
+	// index of the wallet.
+	index := len(e.addresses.list("networks/", func(address *mpcWallet.Address) bool {
+		return address.GetMpcWallet() == wallet.GetName()
+	}))
+	mpcKey := e.mpcKeys.put(&mpcKeys.MPCKey{
+		Name:           wallet.GetDeviceGroup() + "/mpcKeys/" + newResourceID("mpc-key"),
+		DerivationPath: []int32{44, 60, 0, 0, int32(index)},
+	})
+	address := e.addresses.put(&mpcWallet.Address{
+		Name:      req.GetNetwork() + "/addresses/" + newResourceID("address"),
+		Address:   "0x" + hex.EncodeToString(emulatedDigest("address", mpcKey.GetName())[:20]),
+		MpcKeys:   []string{mpcKey.GetName()},
+		MpcWallet: wallet.GetName(),
+	})
+
+	for _, balance := range e.seedBalances {
+		if parentName(balance.Asset) == req.GetNetwork() {
+			amount, _ := new(big.Int).SetString(balance.Amount, 10)
+			e.creditLocked(balanceName(address.GetName(), balance.Asset), balance.Asset, wallet.GetName(), amount)
+		}
+	}
+	return address, nil
+}
+
+func (e *emulator) GetAddress(ctx context.Context, req *mpcWallet.GetAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error) {
+	if err := checkName(req.GetName(), "networks", "addresses"); err != nil {
+		return nil, err
+	}
+	return e.addresses.get(req.GetName())
+}
+
+func (e *emulator) ListAddresses(ctx context.Context, req *mpcWallet.ListAddressesRequest, opts ...gax.CallOption) v1clients.AddressIterator {
+	_, err := e.GetNetwork(ctx, &blockchain.GetNetworkRequest{Name: req.GetParent()})
+	addresses := e.addresses.list(req.GetParent()+"/addresses/", func(address *mpcWallet.Address) bool {
+		return req.GetMpcWallet() == "" || address.GetMpcWallet() == req.GetMpcWallet()
+	})
+	return addressPageIterator{newSliceIterator(addresses, err)}
+}
+
+func (e *emulator) ListBalances(ctx context.Context, req *mpcWallet.ListBalancesRequest, opts ...gax.CallOption) v1clients.BalanceIterator {
+	_, err := e.GetAddress(ctx, &mpcWallet.GetAddressRequest{Name: req.GetParent()})
+	return balancePageIterator{newSliceIterator(e.balances.list(req.GetParent()+"/balances/", nil), err)}
+}
+
+func (e *emulator) CreatePool(ctx context.Context, req *pools.CreatePoolRequest, opts ...gax.CallOption) (*pools.Pool, error) {
+	id := req.GetPoolId()
+	if id == "" {
+		id = newResourceID("pool")
+	}
+	name := "pools/" + id
+	if err := checkName(name, "pools"); err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := e.pools.get(name); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "%s already exists", name)
+	}
+	return e.pools.put(&pools.Pool{Name: name, DisplayName: req.GetPool().GetDisplayName()}), nil
+}
+
+func (e *emulator) GetPool(ctx context.Context, req *pools.GetPoolRequest, opts ...gax.CallOption) (*pools.Pool, error) {
+	if err := checkName(req.GetName(), "pools"); err != nil {
+		return nil, err
+	}
+	return e.pools.get(req.GetName())
+}
+
+func (e *emulator) ListPools(ctx context.Context, req *pools.ListPoolsRequest, opts ...gax.CallOption) v1clients.PoolIterator {
+	return poolPageIterator{newSliceIterator(e.pools.list("pools/", nil), nil)}
+}
+
+func (e *emulator) ConstructTransaction(ctx context.Context, req *protocols.ConstructTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	if _, err := e.GetNetwork(ctx, &blockchain.GetNetworkRequest{Name: req.GetNetwork()}); err != nil {
+		return nil, err
+	}
+	if req.GetInput().GetInput() == nil {
+		return nil, status.Error(codes.InvalidArgument, "input is required")
+	}
+	return emulatedTransaction(req.GetInput()), nil
+}
+
+func (e *emulator) ConstructTransferTransaction(ctx context.Context, req *protocols.ConstructTransferTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	network, err := e.GetNetwork(ctx, &blockchain.GetNetworkRequest{Name: req.GetNetwork()})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.GetAsset(ctx, &blockchain.GetAssetRequest{Name: req.GetAsset()}); err != nil {
+		return nil, err
+	}
+	if req.GetAsset() != network.GetNativeAsset() {
+		return nil, status.Errorf(codes.Unimplemented, "the emulator only transfers the native asset of %s", network.GetName())
+	}
+	if req.GetSender() == "" || req.GetRecipient() == "" || req.GetAmount() == "" {
+		return nil, status.Error(codes.InvalidArgument, "sender, recipient and amount are required")
+	}
+	amount, err := parseAmount(req.GetAmount())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	fee := req.GetFee().GetEthereumFee()
+	if fee == nil {
+		fee = &ethereum.DynamicFeeInput{MaxPriorityFeePerGas: emulatorMaxPriorityFeePerGas, MaxFeePerGas: emulatorMaxFeePerGas}
+	}
+	return emulatedTransaction(&v1types.TransactionInput{Input: &v1types.TransactionInput_Ethereum_1559Input{
+		Ethereum_1559Input: &ethereum.EIP1559TransactionInput{
+			Nonce:                uint64(req.GetNonce()),
+			MaxPriorityFeePerGas: fee.GetMaxPriorityFeePerGas(),
+			MaxFeePerGas:         fee.GetMaxFeePerGas(),
+			Gas:                  emulatorTransferGas,
+			FromAddress:          req.GetSender(),
+			ToAddress:            req.GetRecipient(),
+			Value:                amount.String(),
+		},
+	}}), nil
+}
+
+func (e *emulator) BroadcastTransaction(ctx context.Context, req *protocols.BroadcastTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	if _, err := e.GetNetwork(ctx, &blockchain.GetNetworkRequest{Name: req.GetNetwork()}); err != nil {
+		return nil, err
+	}
+	raw := req.GetTransaction().GetRawSignedTransaction()
+	if len(raw) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "transaction.raw_signed_transaction is required")
+	}
+
+	transaction := proto.Clone(req.GetTransaction()).(*v1types.Transaction)
+	transaction.Hash = "0x" + hex.EncodeToString(emulatedDigest("hash", string(raw)))
+	return transaction, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	mpcWallet "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_wallets/v1"
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// newEmulatorProxy returns a router without authentication served by an emulator of the
+// default fixture.
+func newEmulatorProxy(t *testing.T, operationDelay, blockTime time.Duration) *testProxy {
+	t.Helper()
+
+	e, err := newEmulator([]byte(defaultEmulatorFixture), operationDelay, blockTime)
+	if err != nil {
+		t.Fatalf("newEmulator() = %v", err)
+	}
+	svc := e.services()
+	readiness := newReadinessChecker(0, time.Second, upstreamProbes(nil, svc)...)
+	return &testProxy{router: newRouter(svc, &routerConfig{readiness: readiness})}
+}
+
+// call serves req and decodes the response into m, failing the test unless it has
+// wantStatus.
+func (p *testProxy) call(t *testing.T, req testRequest, wantStatus int, m proto.Message) {
+	t.Helper()
+
+	w := p.do("", req)
+	if w.Code != wantStatus {
+		t.Fatalf("%s %s = %d %s, want %d", req.method, req.path, w.Code, w.Body, wantStatus)
+	}
+	if m == nil {
+		return
+	}
+	if err := unmarshalOptions.Unmarshal(w.Body.Bytes(), m); err != nil {
+		t.Fatalf("%s %s = %s: %v", req.method, req.path, w.Body, err)
+	}
+}
+
+// awaitMPCTransaction polls the named MPC transaction until it is in a final state.
+func (p *testProxy) awaitMPCTransaction(t *testing.T, name string) *mpcTransactions.MPCTransaction {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mpcTx := &mpcTransactions.MPCTransaction{}
+		p.call(t, testRequest{method: http.MethodGet, path: "/mpc_transactions/v1/" + name}, http.StatusOK, mpcTx)
+		if mpcTx.State >= mpcTransactions.MPCTransaction_CONFIRMED || time.Now().After(deadline) {
+			return mpcTx
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// balance returns the ETH balance of the named address.
+func (p *testProxy) balance(t *testing.T, address string) string {
+	t.Helper()
+
+	var balances mpcWallet.ListBalancesResponse
+	p.call(t, testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/" + address + "/balances"}, http.StatusOK, &balances)
+	if len(balances.Balances) != 1 || balances.Balances[0].Asset != "networks/ethereum-goerli/assets/eth" {
+		t.Fatalf("ListBalances(%s) = %v, want an ETH balance", address, balances.Balances)
+	}
+	return balances.Balances[0].Amount
+}
+
+func TestEmulatorTransfer(t *testing.T) {
+	p := newEmulatorProxy(t, 0, 0)
+
+	p.call(t, testRequest{method: http.MethodPost, path: "/pools/v1/pools?poolId=dev", body: `{"displayName": "Development"}`}, http.StatusOK, nil)
+
+	device := &mpcKeys.Device{}
+	p.call(t, testRequest{method: http.MethodPost, path: "/mpc_keys/v1/device/register", body: `{"registrationData": "cmVnaXN0cmF0aW9u"}`}, http.StatusOK, device)
+
+	wallet := &mpcWallet.MPCWallet{}
+	p.call(t, testRequest{method: http.MethodPost, path: "/mpc_wallets/v1/pools/dev/mpcWallets?wait=true&device=" + device.Name, body: `{}`}, http.StatusOK, wallet)
+
+	deviceGroup := &mpcKeys.DeviceGroup{}
+	p.call(t, testRequest{method: http.MethodGet, path: "/mpc_keys/v1/" + wallet.DeviceGroup}, http.StatusOK, deviceGroup)
+	if len(deviceGroup.Devices) != 1 || deviceGroup.Devices[0] != device.Name {
+		t.Errorf("GetDeviceGroup() = %v, want %s as its only device", deviceGroup, device.Name)
+	}
+
+	generateAddress := testRequest{method: http.MethodPost, path: "/mpc_wallets/v1/" + wallet.Name + "/generateAddress", body: `{"network": "networks/ethereum-goerli"}`}
+	sender, recipient := &mpcWallet.Address{}, &mpcWallet.Address{}
+	p.call(t, generateAddress, http.StatusOK, sender)
+	p.call(t, generateAddress, http.StatusOK, recipient)
+	if sender.Address == recipient.Address || len(sender.MpcKeys) != 1 {
+		t.Fatalf("GenerateAddress() = %v and %v, want distinct addresses of a key each", sender, recipient)
+	}
+	if got := p.balance(t, sender.Name); got != "10000000000000000000" {
+		t.Errorf("balance of a new address = %s, want the 10 ETH of the fixture", got)
+	}
+
+	// 1 ETH paying 21000 gas at 1 wei.
+	transfer := testRequest{method: http.MethodPost, path: "/mpc_transactions/v1/" + wallet.Name + "/mpcTransactions?wait=true", body: `{
+		"mpcTransaction": {"network": "networks/ethereum-goerli", "fromAddresses": ["` + sender.Name + `"]},
+		"input": {"ethereum1559Input": {"toAddress": "` + recipient.Address + `", "value": "1000000000000000000", "maxFeePerGas": "1", "gas": "21000"}}
+	}`}
+	broadcast := &mpcTransactions.MPCTransaction{}
+	p.call(t, transfer, http.StatusOK, broadcast)
+	if broadcast.State < mpcTransactions.MPCTransaction_CONFIRMING || broadcast.Transaction.Hash == "" || broadcast.Transaction.RequiredSignatures[0].Signature == nil {
+		t.Errorf("CreateMPCTransaction(wait=true) = %v, want a signed and broadcast transaction", broadcast)
+	}
+
+	if mpcTx := p.awaitMPCTransaction(t, broadcast.Name); mpcTx.State != mpcTransactions.MPCTransaction_CONFIRMED {
+		t.Fatalf("MPC transaction state = %s, want CONFIRMED", mpcTx.State)
+	}
+	if got := p.balance(t, sender.Name); got != "8999999999999979000" {
+		t.Errorf("sender balance = %s, want 10 ETH less 1 ETH and 21000 wei of fees", got)
+	}
+	if got := p.balance(t, recipient.Name); got != "11000000000000000000" {
+		t.Errorf("recipient balance = %s, want 11 ETH", got)
+	}
+
+	// The sender cannot fund a second transfer of 9 ETH.
+	transfer.body = strings.Replace(transfer.body, `"1000000000000000000"`, `"9000000000000000000"`, 1)
+	p.call(t, transfer, http.StatusOK, broadcast)
+	if mpcTx := p.awaitMPCTransaction(t, broadcast.Name); mpcTx.State != mpcTransactions.MPCTransaction_FAILED {
+		t.Errorf("MPC transaction state = %s, want FAILED", mpcTx.State)
+	}
+	if got := p.balance(t, sender.Name); got != "8999999999999979000" {
+		t.Errorf("sender balance after a failed transfer = %s, want it unchanged", got)
+	}
+
+	var mpcTxs mpcTransactions.ListMPCTransactionsResponse
+	p.call(t, testRequest{method: http.MethodGet, path: "/mpc_transactions/v1/" + wallet.Name + "/mpcTransactions"}, http.StatusOK, &mpcTxs)
+	if len(mpcTxs.MpcTransactions) != 2 {
+		t.Errorf("ListMPCTransactions() = %d transactions, want 2", len(mpcTxs.MpcTransactions))
+	}
+}
+
+func TestEmulatorPendingOperations(t *testing.T) {
+	p := newEmulatorProxy(t, time.Hour, 0)
+
+	p.call(t, testRequest{method: http.MethodPost, path: "/pools/v1/pools?poolId=dev", body: `{}`}, http.StatusOK, nil)
+	device := &mpcKeys.Device{}
+	p.call(t, testRequest{method: http.MethodPost, path: "/mpc_keys/v1/device/register", body: `{"registrationData": "cmVnaXN0cmF0aW9u"}`}, http.StatusOK, device)
+
+	w := p.do("", testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/dev/deviceGroups?deviceGroupId=group", body: `{"devices": ["` + device.Name + `"]}`})
+	var op operationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &op); err != nil || w.Code != http.StatusAccepted || op.Done {
+		t.Fatalf("CreateDeviceGroup() = %d %s, want a pending operation", w.Code, w.Body)
+	}
+
+	var mpcOperations mpcKeys.ListMPCOperationsResponse
+	p.call(t, testRequest{method: http.MethodGet, path: "/mpc_keys/v1/pools/dev/deviceGroups/group/mpcOperations"}, http.StatusOK, &mpcOperations)
+	if len(mpcOperations.MpcOperations) != 1 || mpcOperations.MpcOperations[0].GetCreateDeviceGroupMetadata().GetDeviceGroup() != "pools/dev/deviceGroups/group" {
+		t.Errorf("ListMPCOperations() = %v, want the creation of the device group", mpcOperations.MpcOperations)
+	}
+
+	checkError(t, p.do("", testRequest{method: http.MethodGet, path: "/mpc_keys/v1/pools/dev/deviceGroups/group"}), http.StatusNotFound, codes.NotFound)
+
+	w = p.do("", testRequest{method: http.MethodGet, path: "/operations/" + op.Name + "?kind=" + operationCreateDeviceGroup})
+	if w.Code != http.StatusAccepted {
+		t.Errorf("GetOperation() = %d %s, want it pending", w.Code, w.Body)
+	}
+}
+
+func TestEmulatorRequestIDs(t *testing.T) {
+	e, err := newEmulator([]byte(defaultEmulatorFixture), 0, 0)
+	if err != nil {
+		t.Fatalf("newEmulator() = %v", err)
+	}
+	ctx := context.Background()
+	e.CreatePool(ctx, &pools.CreatePoolRequest{PoolId: "dev"})
+	device, _ := e.RegisterDevice(ctx, &mpcKeys.RegisterDeviceRequest{RegistrationData: []byte("registration")})
+
+	// checkRetry starts an operation twice with requestID, wanting the same operation if
+	// it is set and distinct ones otherwise.
+	checkRetry := func(rpc, requestID string, start func() (interface{ Name() string }, error)) {
+		t.Helper()
+		first, err := start()
+		if err != nil {
+			t.Fatalf("%s() = %v", rpc, err)
+		}
+		second, err := start()
+		if err != nil {
+			t.Fatalf("retried %s() = %v", rpc, err)
+		}
+		if (first.Name() == second.Name()) != (requestID != "") {
+			t.Errorf("%s() with request ID %q = %s and %s", rpc, requestID, first.Name(), second.Name())
+		}
+	}
+
+	var walletOp createMPCWalletOperation
+	for _, requestID := range []string{"request-1", ""} {
+		checkRetry(operationCreateDeviceGroup, requestID, func() (interface{ Name() string }, error) {
+			return e.CreateDeviceGroup(ctx, &mpcKeys.CreateDeviceGroupRequest{Parent: "pools/dev", DeviceGroup: &mpcKeys.DeviceGroup{Devices: []string{device.Name}}, RequestId: requestID})
+		})
+		checkRetry(operationCreateMPCWallet, requestID, func() (interface{ Name() string }, error) {
+			op, err := e.CreateMPCWallet(ctx, &mpcWallet.CreateMPCWalletRequest{Parent: "pools/dev", Device: device.Name, RequestId: requestID})
+			walletOp = op
+			return op, err
+		})
+	}
+
+	wallet, err := walletOp.Wait(ctx)
+	if err != nil {
+		t.Fatalf("CreateMPCWallet() = %v", err)
+	}
+	address, _ := e.GenerateAddress(ctx, &mpcWallet.GenerateAddressRequest{MpcWallet: wallet.Name, Network: "networks/ethereum-goerli"})
+	checkRetry(operationCreateSignature, "request-1", func() (interface{ Name() string }, error) {
+		return e.CreateSignature(ctx, &mpcKeys.CreateSignatureRequest{Parent: address.MpcKeys[0], Signature: &mpcKeys.Signature{Payload: []byte("payload")}, RequestId: "request-1"})
+	})
+	checkRetry(operationCreateMPCTransaction, "request-1", func() (interface{ Name() string }, error) {
+		req := newTransactionRequest(wallet.Name, "ethereum-goerli", testRecipient, "1", "1", nil)
+		req.RequestId = "request-1"
+		return e.CreateMPCTransaction(ctx, req)
+	})
+	if mpcTxs := e.mpcTransactions.list(wallet.Name+"/mpcTransactions/", nil); len(mpcTxs) != 1 {
+		t.Errorf("retried CreateMPCTransaction() created %d transactions, want 1", len(mpcTxs))
+	}
+}
+
+func TestEmulatorReadiness(t *testing.T) {
+	p := newEmulatorProxy(t, 0, 0)
+
+	w := p.do("", testRequest{method: http.MethodGet, path: "/readyz"})
+	if w.Code != http.StatusOK {
+		t.Errorf("/readyz = %d %s, want the emulator ready", w.Code, w.Body)
+	}
+}
+
+func TestNewEmulatorInvalidFixture(t *testing.T) {
+	for _, fixture := range []string{
+		`{"networks": {}}`,
+		`{"networks": [{"name": "Goerli"}]}`,
+		`{"assets": [{"name": "networks/ethereum-goerli/assets/eth"}]}`,
+		`{"networks": [{"name": "networks/ethereum-goerli"}], "balances": [{"asset": "networks/ethereum-goerli/assets/eth", "amount": "1"}]}`,
+		`{"networks": [{"name": "networks/ethereum-goerli"}], "assets": [{"name": "networks/ethereum-goerli/assets/eth"}], "balances": [{"asset": "networks/ethereum-goerli/assets/eth", "amount": "-1"}]}`,
+	} {
+		if _, err := newEmulator([]byte(fixture), 0, 0); err == nil {
+			t.Errorf("newEmulator(%s) succeeded, want an error", fixture)
+		}
+	}
+}
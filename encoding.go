@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const contentTypeJSON = "application/json"
+
+var (
+	// jsonUseProtoNames renders fields with their proto (snake_case) names instead of lowerCamelCase.
+	jsonUseProtoNames = flag.Bool("json-proto-names", false, "render JSON fields with proto names instead of lowerCamelCase")
+
+	// jsonEmitUnpopulated renders fields holding their zero value.
+	jsonEmitUnpopulated = flag.Bool("json-emit-unpopulated", false, "render JSON fields holding their zero value")
+
+	// jsonEnumNumbers renders enums as numbers instead of their string names.
+	jsonEnumNumbers = flag.Bool("json-enum-numbers", false, "render enums as numbers instead of strings")
+
+	// maxRequestBody caps the request bodies buffered by the middleware.
+	maxRequestBody = flag.Int64("max-request-body", 1<<20, "maximum size in bytes of a request body; larger ones are rejected with 413")
+)
+
+// marshalOptions returns the protojson options used for every response.
+func marshalOptions() protojson.MarshalOptions {
+	return protojson.MarshalOptions{
+		UseProtoNames:   *jsonUseProtoNames,
+		EmitUnpopulated: *jsonEmitUnpopulated,
+		UseEnumNumbers:  *jsonEnumNumbers,
+	}
+}
+
+// unmarshalOptions are the protojson options used for every request body.
+var unmarshalOptions = protojson.UnmarshalOptions{}
+
+// bindProto decodes the request body, up to -max-request-body bytes, into m as canonical
+// proto JSON.
+func bindProto(c *gin.Context, m proto.Message) error {
+	if c.Request.Body == nil {
+		return errors.New("request body is required")
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, *maxRequestBody))
+	if err != nil {
+		return fmt.Errorf("cannot read request body: %w", err)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return errors.New("request body is required")
+	}
+
+	if err := unmarshalOptions.Unmarshal(body, m); err != nil {
+		return fmt.Errorf("cannot parse request body: %v", err)
+	}
+	return nil
+}
+
+// readRequestBody reads the request body, up to -max-request-body bytes, and replaces it
+// so that the handlers can read it again. It writes an error and returns false if the
+// body cannot be read or is too large.
+func readRequestBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, *maxRequestBody))
+	if err != nil {
+		writeBadRequest(c, fmt.Errorf("cannot read request body: %w", err))
+		return nil, false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	return body, true
+}
+
+// writeProto writes m as proto JSON.
+func writeProto(c *gin.Context, m proto.Message) {
+	data, err := marshalOptions().Marshal(m)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, contentTypeJSON, data)
+}
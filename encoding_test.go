@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestContext returns a gin context for a POST request with body, and its recorder.
+func newTestContext(body string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	return c, w
+}
+
+func TestBindProto(t *testing.T) {
+	for _, tt := range []struct {
+		body    string
+		wantErr bool
+	}{
+		{body: `{"displayName": "Treasury"}`},
+		{body: `{"display_name": "Treasury"}`},
+		{body: "", wantErr: true},
+		{body: " \n", wantErr: true},
+		{body: `{"displayName": `, wantErr: true},
+		{body: `{"unknownField": 1}`, wantErr: true},
+		{body: `{"displayName": 1}`, wantErr: true},
+	} {
+		c, _ := newTestContext(tt.body)
+		pool := &pools.Pool{}
+		err := bindProto(c, pool)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("bindProto(%q) = %v, want error %v", tt.body, err, tt.wantErr)
+		}
+		if err == nil && pool.GetDisplayName() != "Treasury" {
+			t.Errorf("bindProto(%q) decoded %v", tt.body, pool)
+		}
+	}
+
+	defer func(limit int64) { *maxRequestBody = limit }(*maxRequestBody)
+	*maxRequestBody = 16
+	c, w := newTestContext(`{"displayName": "Treasury"}`)
+	err := bindProto(c, &pools.Pool{})
+	if err == nil {
+		t.Fatal("bindProto() of a body above -max-request-body succeeded, want an error")
+	}
+	writeBadRequest(c, err)
+	if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), "INVALID_ARGUMENT") {
+		t.Errorf("writeBadRequest(%v) = %d %s, want 413 INVALID_ARGUMENT", err, w.Code, w.Body)
+	}
+}
+
+func TestWriteProtoOptions(t *testing.T) {
+	tx := &mpcTransactions.MPCTransaction{Name: "pools/p/mpcWallets/w/mpcTransactions/t", State: mpcTransactions.MPCTransaction_CONFIRMED}
+
+	for _, tt := range []struct {
+		name                                     string
+		protoNames, emitUnpopulated, enumNumbers bool
+		want                                     []string
+	}{
+		{name: "default", want: []string{`"name":`, `"state":"CONFIRMED"`}},
+		{name: "proto names", protoNames: true, emitUnpopulated: true, want: []string{`"from_addresses":`}},
+		{name: "enum numbers", enumNumbers: true, want: []string{`"state":` + strconv.Itoa(int(mpcTransactions.MPCTransaction_CONFIRMED))}},
+	} {
+		*jsonUseProtoNames, *jsonEmitUnpopulated, *jsonEnumNumbers = tt.protoNames, tt.emitUnpopulated, tt.enumNumbers
+
+		c, w := newTestContext("")
+		writeProto(c, tx)
+		body := strings.ReplaceAll(w.Body.String(), " ", "")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != contentTypeJSON {
+			t.Errorf("writeProto(%s) = %d %s", tt.name, w.Code, w.Header().Get("Content-Type"))
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("writeProto(%s) = %s, want it to contain %s", tt.name, body, want)
+			}
+		}
+	}
+	*jsonUseProtoNames, *jsonEmitUnpopulated, *jsonEnumNumbers = false, false, false
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// statusClientClosedRequest is the non-standard HTTP status used for cancelled requests.
+	statusClientClosedRequest = 499
+
+	// errorDomain is the ErrorInfo domain of the errors raised by the proxy itself.
+	errorDomain = "waas-proxy"
+)
+
+// errorResponse is the JSON envelope returned for every failed request.
+type errorResponse struct {
+	Code      string        `json:"code"`
+	Message   string        `json:"message"`
+	Details   []errorDetail `json:"details,omitempty"`
+	RequestID string        `json:"requestId,omitempty"`
+
+	// retryAfter is the upstream RetryInfo delay, surfaced as a Retry-After header.
+	retryAfter time.Duration
+}
+
+// errorDetail is a single structured error detail, tagged by Type.
+type errorDetail struct {
+	Type            string            `json:"type"`
+	Reason          string            `json:"reason,omitempty"`
+	Domain          string            `json:"domain,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	FieldViolations []fieldViolation  `json:"fieldViolations,omitempty"`
+	Violations      []violation       `json:"violations,omitempty"`
+	RetryDelay      string            `json:"retryDelay,omitempty"`
+	ResourceType    string            `json:"resourceType,omitempty"`
+	ResourceName    string            `json:"resourceName,omitempty"`
+	Description     string            `json:"description,omitempty"`
+}
+
+// fieldViolation describes a single invalid request field.
+type fieldViolation struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// violation describes a single failed precondition or quota check.
+type violation struct {
+	Type        string `json:"type,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// httpErrorBody is the subset of the upstream REST error body used to recover the status code name.
+type httpErrorBody struct {
+	Error struct {
+		Status string `json:"status"`
+	} `json:"error"`
+}
+
+// writeError writes the HTTP status and error envelope corresponding to err.
+func writeError(c *gin.Context, err error) {
+	httpStatus, response := translateError(err)
+	if response.retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(response.retryAfter.Seconds()))))
+	}
+	c.AbortWithStatusJSON(httpStatus, response)
+}
+
+// writeBadRequest writes an INVALID_ARGUMENT error for a request the proxy itself rejected,
+// with a 413 status if its body exceeded -max-request-body.
+func writeBadRequest(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, &errorResponse{
+			Code:    codeName(codes.InvalidArgument),
+			Message: fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit),
+		})
+		return
+	}
+	abortWithCode(c, codes.InvalidArgument, err.Error())
+}
+
+// abortWithCode writes the error envelope for a request the proxy itself rejected with code.
+func abortWithCode(c *gin.Context, code codes.Code, message string) {
+	c.AbortWithStatusJSON(httpStatusFromCode(code), &errorResponse{
+		Code:    codeName(code),
+		Message: message,
+	})
+}
+
+// translateError maps an error returned by a WaaS client to an HTTP status and error envelope.
+func translateError(err error) (int, *errorResponse) {
+	response := &errorResponse{Message: err.Error()}
+	code := codes.Unknown
+	httpStatus := http.StatusInternalServerError
+
+	var httpErr *googleapi.Error
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		apiErr, _ = apierror.FromError(err)
+	}
+
+	switch {
+	case errors.As(err, &httpErr):
+		httpStatus = httpErr.Code
+		code = codeFromHTTPError(httpErr)
+		if httpErr.Message != "" {
+			response.Message = httpErr.Message
+		}
+		response.RequestID = httpErr.Header.Get("X-Request-Id")
+	case apiErr != nil && apiErr.GRPCStatus() != nil:
+		code = apiErr.GRPCStatus().Code()
+		httpStatus = httpStatusFromCode(code)
+		response.Message = apiErr.GRPCStatus().Message()
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+		httpStatus = http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+		httpStatus = statusClientClosedRequest
+	default:
+		if st, ok := status.FromError(err); ok {
+			code = st.Code()
+			httpStatus = httpStatusFromCode(code)
+			response.Message = st.Message()
+		} else {
+			code = codes.Internal
+		}
+	}
+
+	response.Code = codeName(code)
+	if apiErr != nil {
+		details := apiErr.Details()
+		response.Details = translateDetails(details)
+		if requestID := details.RequestInfo.GetRequestId(); requestID != "" {
+			response.RequestID = requestID
+		}
+		if details.RetryInfo.GetRetryDelay() != nil {
+			response.retryAfter = details.RetryInfo.GetRetryDelay().AsDuration()
+		}
+	}
+
+	return httpStatus, response
+}
+
+// translateDetails flattens the google.rpc error details into errorDetails.
+// DebugInfo is deliberately dropped as it may contain upstream stack traces.
+func translateDetails(details apierror.ErrDetails) []errorDetail {
+	var out []errorDetail
+
+	if info := details.ErrorInfo; info != nil {
+		out = append(out, errorDetail{Type: "errorInfo", Reason: info.GetReason(), Domain: info.GetDomain(), Metadata: info.GetMetadata()})
+	}
+
+	if badRequest := details.BadRequest; badRequest != nil {
+		detail := errorDetail{Type: "badRequest"}
+		for _, v := range badRequest.GetFieldViolations() {
+			detail.FieldViolations = append(detail.FieldViolations, fieldViolation{Field: v.GetField(), Description: v.GetDescription()})
+		}
+		out = append(out, detail)
+	}
+
+	if precondition := details.PreconditionFailure; precondition != nil {
+		detail := errorDetail{Type: "preconditionFailure"}
+		for _, v := range precondition.GetViolations() {
+			detail.Violations = append(detail.Violations, violation{Type: v.GetType(), Subject: v.GetSubject(), Description: v.GetDescription()})
+		}
+		out = append(out, detail)
+	}
+
+	if quota := details.QuotaFailure; quota != nil {
+		detail := errorDetail{Type: "quotaFailure"}
+		for _, v := range quota.GetViolations() {
+			detail.Violations = append(detail.Violations, violation{Subject: v.GetSubject(), Description: v.GetDescription()})
+		}
+		out = append(out, detail)
+	}
+
+	if retry := details.RetryInfo; retry != nil && retry.GetRetryDelay() != nil {
+		delay := retry.GetRetryDelay().AsDuration()
+		out = append(out, errorDetail{Type: "retryInfo", RetryDelay: strconv.FormatFloat(delay.Seconds(), 'f', -1, 64) + "s"})
+	}
+
+	if resource := details.ResourceInfo; resource != nil {
+		out = append(out, errorDetail{Type: "resourceInfo", ResourceType: resource.GetResourceType(), ResourceName: resource.GetResourceName(), Description: resource.GetDescription()})
+	}
+
+	return out
+}
+
+// codeFromHTTPError recovers the canonical code from an upstream REST error, preferring
+// the status name in the body over the HTTP status code.
+func codeFromHTTPError(httpErr *googleapi.Error) codes.Code {
+	var body httpErrorBody
+	if err := json.Unmarshal([]byte(httpErr.Body), &body); err == nil && body.Error.Status != "" {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(body.Error.Status))); err == nil {
+			return code
+		}
+	}
+
+	switch httpErr.Code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case statusClientClosedRequest:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		if httpErr.Code >= 500 {
+			return codes.Internal
+		}
+		return codes.Unknown
+	}
+}
+
+// httpStatusFromCode maps a canonical code to its HTTP status, per
+// https://cloud.google.com/apis/design/errors#handling_errors.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return statusClientClosedRequest
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// codeNames holds the canonical upper-case names of the gRPC codes.
+var codeNames = map[codes.Code]string{
+	codes.OK:                 "OK",
+	codes.Canceled:           "CANCELLED",
+	codes.Unknown:            "UNKNOWN",
+	codes.InvalidArgument:    "INVALID_ARGUMENT",
+	codes.DeadlineExceeded:   "DEADLINE_EXCEEDED",
+	codes.NotFound:           "NOT_FOUND",
+	codes.AlreadyExists:      "ALREADY_EXISTS",
+	codes.PermissionDenied:   "PERMISSION_DENIED",
+	codes.ResourceExhausted:  "RESOURCE_EXHAUSTED",
+	codes.FailedPrecondition: "FAILED_PRECONDITION",
+	codes.Aborted:            "ABORTED",
+	codes.OutOfRange:         "OUT_OF_RANGE",
+	codes.Unimplemented:      "UNIMPLEMENTED",
+	codes.Internal:           "INTERNAL",
+	codes.Unavailable:        "UNAVAILABLE",
+	codes.DataLoss:           "DATA_LOSS",
+	codes.Unauthenticated:    "UNAUTHENTICATED",
+}
+
+// codeName returns the canonical upper-case name of code, e.g. NOT_FOUND.
+func codeName(code codes.Code) string {
+	if name, ok := codeNames[code]; ok {
+		return name
+	}
+	return codeNames[codes.Unknown]
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+func TestTranslateError(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+	}{
+		{
+			name:        "grpc not found",
+			err:         status.Error(codes.NotFound, "pool not found"),
+			wantStatus:  http.StatusNotFound,
+			wantCode:    "NOT_FOUND",
+			wantMessage: "pool not found",
+		},
+		{
+			name:        "grpc failed precondition",
+			err:         status.Error(codes.FailedPrecondition, "insufficient balance"),
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    "FAILED_PRECONDITION",
+			wantMessage: "insufficient balance",
+		},
+		{
+			name:        "rest status in body",
+			err:         &googleapi.Error{Code: http.StatusBadRequest, Message: "bad state", Body: `{"error": {"status": "FAILED_PRECONDITION"}}`},
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    "FAILED_PRECONDITION",
+			wantMessage: "bad state",
+		},
+		{
+			name:       "rest status code only",
+			err:        &googleapi.Error{Code: http.StatusConflict},
+			wantStatus: http.StatusConflict,
+			wantCode:   "ALREADY_EXISTS",
+		},
+		{
+			name:       "deadline",
+			err:        context.DeadlineExceeded,
+			wantStatus: http.StatusGatewayTimeout,
+			wantCode:   "DEADLINE_EXCEEDED",
+		},
+		{
+			name:       "cancelled",
+			err:        context.Canceled,
+			wantStatus: statusClientClosedRequest,
+			wantCode:   "CANCELLED",
+		},
+		{
+			name:        "other",
+			err:         errors.New("boom"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    "INTERNAL",
+			wantMessage: "boom",
+		},
+	} {
+		httpStatus, response := translateError(tt.err)
+		if httpStatus != tt.wantStatus || response.Code != tt.wantCode || (tt.wantMessage != "" && response.Message != tt.wantMessage) {
+			t.Errorf("translateError(%s) = %d %+v, want %d %s %q", tt.name, httpStatus, response, tt.wantStatus, tt.wantCode, tt.wantMessage)
+		}
+	}
+}
+
+func TestTranslateErrorDetails(t *testing.T) {
+	st, err := status.New(codes.ResourceExhausted, "slow down").WithDetails(
+		&errdetails.ErrorInfo{Reason: "RATE_LIMITED", Domain: "waas"},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)},
+		&errdetails.RequestInfo{RequestId: "upstream-request"},
+		&errdetails.DebugInfo{Detail: "stack trace"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	httpStatus, response := translateError(st.Err())
+	if httpStatus != http.StatusTooManyRequests || response.Code != "RESOURCE_EXHAUSTED" || response.RequestID != "upstream-request" || response.retryAfter != 1500*time.Millisecond {
+		t.Errorf("translateError() = %d %+v, want RESOURCE_EXHAUSTED with the upstream request ID and retry delay", httpStatus, response)
+	}
+	var types []string
+	for _, detail := range response.Details {
+		types = append(types, detail.Type)
+	}
+	if fmt.Sprint(types) != "[errorInfo retryInfo]" || response.Details[0].Reason != "RATE_LIMITED" || response.Details[1].RetryDelay != "1.5s" {
+		t.Errorf("translateError() details = %+v, want errorInfo and retryInfo without debugInfo", response.Details)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	writeError(c, st.Err())
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("writeError() = %d with Retry-After %q, want 429 with Retry-After 2", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		httpStatus := httpStatusFromCode(code)
+		if code == codes.Internal || code == codes.Unknown || code == codes.DataLoss {
+			if httpStatus != http.StatusInternalServerError {
+				t.Errorf("httpStatusFromCode(%v) = %d, want 500", code, httpStatus)
+			}
+			continue
+		}
+		if httpStatus == http.StatusInternalServerError {
+			t.Errorf("httpStatusFromCode(%v) = 500, want a specific status", code)
+		}
+		if name := codeName(code); name == codeNames[codes.Unknown] && code != codes.Unknown {
+			t.Errorf("codeName(%v) = %s", code, name)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	v1clients "github.com/coinbase/waas-client-library-go/clients/v1"
+	blockchain "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/blockchain/v1"
+	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	mpcWallet "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_wallets/v1"
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	ethereum "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/ethereum/v1"
+	protocols "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/v1"
+	v1types "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/types/v1"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeUpstream is embedded in the fakes to fail every call with err when it is set.
+type fakeUpstream struct {
+	err error
+}
+
+// check returns the error injected in the fake, or the error of a malformed name.
+func (f *fakeUpstream) check(name string, collections ...string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return checkName(name, collections...)
+}
+
+// completedOperation returns a local operation done with result.
+func completedOperation[R, M proto.Message](name string, result R, metadata M) *localOperation[R, M] {
+	return newLocalOperation(name, metadata, func() (R, bool, error) { return result, true, nil })
+}
+
+// startOperation records a new operation of o completed with result.
+func startOperation[R, M proto.Message](o *localOperations, result R, metadata M) *localOperation[R, M] {
+	op := completedOperation("operations/"+newResourceID("operation"), result, metadata)
+	o.put(op)
+	return op
+}
+
+// fakeOperation returns the named operation of o, or one failing with err when set.
+func fakeOperation[R, M proto.Message](o *localOperations, name string, err error) *localOperation[R, M] {
+	if err != nil {
+		return failedOperation[R, M](name, err)
+	}
+	return lookupOperation[R, M](o, name)
+}
+
+// fakeBlockchain is an in-memory blockchainAPI.
+type fakeBlockchain struct {
+	fakeUpstream
+	networks resourceCollection[*blockchain.Network]
+	assets   resourceCollection[*blockchain.Asset]
+}
+
+func (f *fakeBlockchain) GetNetwork(ctx context.Context, req *blockchain.GetNetworkRequest, opts ...gax.CallOption) (*blockchain.Network, error) {
+	if err := f.check(req.GetName(), "networks"); err != nil {
+		return nil, err
+	}
+	return f.networks.get(req.GetName())
+}
+
+func (f *fakeBlockchain) ListNetworks(ctx context.Context, req *blockchain.ListNetworksRequest, opts ...gax.CallOption) v1clients.NetworkIterator {
+	return networkPageIterator{newSliceIterator(f.networks.list("networks/", nil), f.err)}
+}
+
+func (f *fakeBlockchain) GetAsset(ctx context.Context, req *blockchain.GetAssetRequest, opts ...gax.CallOption) (*blockchain.Asset, error) {
+	if err := f.check(req.GetName(), "networks", "assets"); err != nil {
+		return nil, err
+	}
+	return f.assets.get(req.GetName())
+}
+
+func (f *fakeBlockchain) ListAssets(ctx context.Context, req *blockchain.ListAssetsRequest, opts ...gax.CallOption) v1clients.AssetIterator {
+	err := f.check(req.GetParent(), "networks")
+	return assetPageIterator{newSliceIterator(f.assets.list(req.GetParent()+"/assets/", nil), err)}
+}
+
+// fakeMPCKey is an in-memory mpcKeyAPI.
+type fakeMPCKey struct {
+	fakeUpstream
+	devices      resourceCollection[*mpcKeys.Device]
+	deviceGroups resourceCollection[*mpcKeys.DeviceGroup]
+	mpcKeys      resourceCollection[*mpcKeys.MPCKey]
+	operations   localOperations
+}
+
+func (f *fakeMPCKey) RegisterDevice(ctx context.Context, req *mpcKeys.RegisterDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(req.GetRegistrationData()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "registration_data is required")
+	}
+	return f.devices.put(&mpcKeys.Device{Name: "devices/" + newResourceID("device")}), nil
+}
+
+func (f *fakeMPCKey) GetDevice(ctx context.Context, req *mpcKeys.GetDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error) {
+	if err := f.check(req.GetName(), "devices"); err != nil {
+		return nil, err
+	}
+	return f.devices.get(req.GetName())
+}
+
+func (f *fakeMPCKey) CreateDeviceGroup(ctx context.Context, req *mpcKeys.CreateDeviceGroupRequest, opts ...gax.CallOption) (createDeviceGroupOperation, error) {
+	if err := f.check(req.GetParent(), "pools"); err != nil {
+		return nil, err
+	}
+	if len(req.GetDeviceGroup().GetDevices()) != 1 {
+		return nil, status.Error(codes.InvalidArgument, "device_group must have exactly one device")
+	}
+
+	id := req.GetDeviceGroupId()
+	if id == "" {
+		id = newResourceID("device-group")
+	}
+	deviceGroup := proto.Clone(req.GetDeviceGroup()).(*mpcKeys.DeviceGroup)
+	deviceGroup.Name = req.GetParent() + "/deviceGroups/" + id
+	f.deviceGroups.put(deviceGroup)
+	return startOperation(&f.operations, deviceGroup, &mpcKeys.CreateDeviceGroupMetadata{DeviceGroup: deviceGroup.Name}), nil
+}
+
+func (f *fakeMPCKey) CreateDeviceGroupOperation(name string) createDeviceGroupOperation {
+	return fakeOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata](&f.operations, name, f.err)
+}
+
+func (f *fakeMPCKey) GetDeviceGroup(ctx context.Context, req *mpcKeys.GetDeviceGroupRequest, opts ...gax.CallOption) (*mpcKeys.DeviceGroup, error) {
+	if err := f.check(req.GetName(), "pools", "deviceGroups"); err != nil {
+		return nil, err
+	}
+	return f.deviceGroups.get(req.GetName())
+}
+
+func (f *fakeMPCKey) ListMPCOperations(ctx context.Context, req *mpcKeys.ListMPCOperationsRequest, opts ...gax.CallOption) (*mpcKeys.ListMPCOperationsResponse, error) {
+	if err := f.check(req.GetParent(), "pools", "deviceGroups"); err != nil {
+		return nil, err
+	}
+	if _, err := f.deviceGroups.get(req.GetParent()); err != nil {
+		return nil, err
+	}
+	return &mpcKeys.ListMPCOperationsResponse{}, nil
+}
+
+func (f *fakeMPCKey) CreateMPCKey(ctx context.Context, req *mpcKeys.CreateMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error) {
+	if err := f.check(req.GetParent(), "pools", "deviceGroups"); err != nil {
+		return nil, err
+	}
+	if _, err := f.deviceGroups.get(req.GetParent()); err != nil {
+		return nil, err
+	}
+	if len(req.GetMpcKey().GetDerivationPath()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "mpc_key.derivation_path is required")
+	}
+
+	mpcKey := proto.Clone(req.GetMpcKey()).(*mpcKeys.MPCKey)
+	mpcKey.Name = req.GetParent() + "/mpcKeys/" + newResourceID("mpc-key")
+	return f.mpcKeys.put(mpcKey), nil
+}
+
+func (f *fakeMPCKey) GetMPCKey(ctx context.Context, req *mpcKeys.GetMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error) {
+	if err := f.check(req.GetName(), "pools", "deviceGroups", "mpcKeys"); err != nil {
+		return nil, err
+	}
+	return f.mpcKeys.get(req.GetName())
+}
+
+func (f *fakeMPCKey) CreateSignature(ctx context.Context, req *mpcKeys.CreateSignatureRequest, opts ...gax.CallOption) (createSignatureOperation, error) {
+	if err := f.check(req.GetParent(), "pools", "deviceGroups", "mpcKeys"); err != nil {
+		return nil, err
+	}
+	if _, err := f.mpcKeys.get(req.GetParent()); err != nil {
+		return nil, err
+	}
+	if len(req.GetSignature().GetPayload()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "signature.payload is required")
+	}
+
+	signature := proto.Clone(req.GetSignature()).(*mpcKeys.Signature)
+	signature.Name = req.GetParent() + "/signatures/" + newResourceID("signature")
+	return startOperation(&f.operations, signature, &mpcKeys.CreateSignatureMetadata{}), nil
+}
+
+func (f *fakeMPCKey) CreateSignatureOperation(name string) createSignatureOperation {
+	return fakeOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata](&f.operations, name, f.err)
+}
+
+// fakeMPCTransaction is an in-memory mpcTransactionAPI.
+type fakeMPCTransaction struct {
+	fakeUpstream
+	mpcTransactions resourceCollection[*mpcTransactions.MPCTransaction]
+	operations      localOperations
+}
+
+func (f *fakeMPCTransaction) CreateMPCTransaction(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error) {
+	if err := f.check(req.GetParent(), "pools", "mpcWallets"); err != nil {
+		return nil, err
+	}
+	if err := checkName(req.GetMpcTransaction().GetNetwork(), "networks"); err != nil {
+		return nil, err
+	}
+	if req.GetInput() == nil {
+		return nil, status.Error(codes.InvalidArgument, "input is required")
+	}
+
+	mpcTx := proto.Clone(req.GetMpcTransaction()).(*mpcTransactions.MPCTransaction)
+	mpcTx.Name = req.GetParent() + "/mpcTransactions/" + newResourceID("mpc-transaction")
+	mpcTx.State = mpcTransactions.MPCTransaction_CONFIRMED
+	mpcTx.Transaction = &v1types.Transaction{Input: req.GetInput()}
+	f.mpcTransactions.put(mpcTx)
+	return startOperation(&f.operations, mpcTx, &mpcTransactions.CreateMPCTransactionMetadata{}), nil
+}
+
+func (f *fakeMPCTransaction) CreateMPCTransactionOperation(name string) createMPCTransactionOperation {
+	return fakeOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata](&f.operations, name, f.err)
+}
+
+func (f *fakeMPCTransaction) GetMPCTransaction(ctx context.Context, req *mpcTransactions.GetMPCTransactionRequest, opts ...gax.CallOption) (*mpcTransactions.MPCTransaction, error) {
+	if err := f.check(req.GetName(), "pools", "mpcWallets", "mpcTransactions"); err != nil {
+		return nil, err
+	}
+	return f.mpcTransactions.get(req.GetName())
+}
+
+func (f *fakeMPCTransaction) ListMPCTransactions(ctx context.Context, req *mpcTransactions.ListMPCTransactionsRequest, opts ...gax.CallOption) v1clients.MPCTransactionIterator {
+	err := f.check(req.GetParent(), "pools", "mpcWallets")
+	return mpcTransactionPageIterator{newSliceIterator(f.mpcTransactions.list(req.GetParent()+"/mpcTransactions/", nil), err)}
+}
+
+// fakeMPCWallet is an in-memory mpcWalletAPI.
+type fakeMPCWallet struct {
+	fakeUpstream
+	mpcWallets resourceCollection[*mpcWallet.MPCWallet]
+	addresses  resourceCollection[*mpcWallet.Address]
+	balances   resourceCollection[*mpcWallet.Balance]
+	operations localOperations
+}
+
+func (f *fakeMPCWallet) CreateMPCWallet(ctx context.Context, req *mpcWallet.CreateMPCWalletRequest, opts ...gax.CallOption) (createMPCWalletOperation, error) {
+	if err := f.check(req.GetParent(), "pools"); err != nil {
+		return nil, err
+	}
+	if err := checkName(req.GetDevice(), "devices"); err != nil {
+		return nil, err
+	}
+
+	wallet := f.mpcWallets.put(&mpcWallet.MPCWallet{
+		Name:        req.GetParent() + "/mpcWallets/" + newResourceID("mpc-wallet"),
+		DeviceGroup: req.GetParent() + "/deviceGroups/" + newResourceID("device-group"),
+	})
+	return startOperation(&f.operations, wallet, &mpcWallet.CreateMPCWalletMetadata{DeviceGroup: wallet.DeviceGroup}), nil
+}
+
+func (f *fakeMPCWallet) CreateMPCWalletOperation(name string) createMPCWalletOperation {
+	return fakeOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata](&f.operations, name, f.err)
+}
+
+func (f *fakeMPCWallet) GetMPCWallet(ctx context.Context, req *mpcWallet.GetMPCWalletRequest, opts ...gax.CallOption) (*mpcWallet.MPCWallet, error) {
+	if err := f.check(req.GetName(), "pools", "mpcWallets"); err != nil {
+		return nil, err
+	}
+	return f.mpcWallets.get(req.GetName())
+}
+
+func (f *fakeMPCWallet) ListMPCWallets(ctx context.Context, req *mpcWallet.ListMPCWalletsRequest, opts ...gax.CallOption) v1clients.MPCWalletIterator {
+	err := f.check(req.GetParent(), "pools")
+	return mpcWalletPageIterator{newSliceIterator(f.mpcWallets.list(req.GetParent()+"/mpcWallets/", nil), err)}
+}
+
+func (f *fakeMPCWallet) GenerateAddress(ctx context.Context, req *mpcWallet.GenerateAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error) {
+	if err := f.check(req.GetMpcWallet(), "pools", "mpcWallets"); err != nil {
+		return nil, err
+	}
+	if err := checkName(req.GetNetwork(), "networks"); err != nil {
+		return nil, err
+	}
+	if _, err := f.mpcWallets.get(req.GetMpcWallet()); err != nil {
+		return nil, err
+	}
+
+	id := newResourceID("address")
+	return f.addresses.put(&mpcWallet.Address{Name: req.GetNetwork() + "/addresses/" + id, Address: "0x" + id, MpcWallet: req.GetMpcWallet()}), nil
+}
+
+func (f *fakeMPCWallet) GetAddress(ctx context.Context, req *mpcWallet.GetAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error) {
+	if err := f.check(req.GetName(), "networks", "addresses"); err != nil {
+		return nil, err
+	}
+	return f.addresses.get(req.GetName())
+}
+
+func (f *fakeMPCWallet) ListAddresses(ctx context.Context, req *mpcWallet.ListAddressesRequest, opts ...gax.CallOption) v1clients.AddressIterator {
+	err := f.check(req.GetParent(), "networks")
+	addresses := f.addresses.list(req.GetParent()+"/addresses/", func(address *mpcWallet.Address) bool {
+		return req.GetMpcWallet() == "" || address.GetMpcWallet() == req.GetMpcWallet()
+	})
+	return addressPageIterator{newSliceIterator(addresses, err)}
+}
+
+func (f *fakeMPCWallet) ListBalances(ctx context.Context, req *mpcWallet.ListBalancesRequest, opts ...gax.CallOption) v1clients.BalanceIterator {
+	err := f.check(req.GetParent(), "networks", "addresses")
+	return balancePageIterator{newSliceIterator(f.balances.list(req.GetParent()+"/balances/", nil), err)}
+}
+
+// fakePool is an in-memory poolAPI.
+type fakePool struct {
+	fakeUpstream
+	pools resourceCollection[*pools.Pool]
+}
+
+func (f *fakePool) CreatePool(ctx context.Context, req *pools.CreatePoolRequest, opts ...gax.CallOption) (*pools.Pool, error) {
+	id := req.GetPoolId()
+	if id == "" {
+		id = newResourceID("pool")
+	}
+	name := "pools/" + id
+	if err := f.check(name, "pools"); err != nil {
+		return nil, err
+	}
+	if _, err := f.pools.get(name); err == nil {
+		return nil, status.Errorf(codes.AlreadyExists, "%s already exists", name)
+	}
+
+	pool := proto.Clone(req.GetPool()).(*pools.Pool)
+	pool.Name = name
+	return f.pools.put(pool), nil
+}
+
+func (f *fakePool) GetPool(ctx context.Context, req *pools.GetPoolRequest, opts ...gax.CallOption) (*pools.Pool, error) {
+	if err := f.check(req.GetName(), "pools"); err != nil {
+		return nil, err
+	}
+	return f.pools.get(req.GetName())
+}
+
+func (f *fakePool) ListPools(ctx context.Context, req *pools.ListPoolsRequest, opts ...gax.CallOption) v1clients.PoolIterator {
+	return poolPageIterator{newSliceIterator(f.pools.list("pools/", nil), f.err)}
+}
+
+// fakeProtocol is a stateless protocolAPI.
+type fakeProtocol struct {
+	fakeUpstream
+}
+
+func (f *fakeProtocol) ConstructTransaction(ctx context.Context, req *protocols.ConstructTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	if err := f.check(req.GetNetwork(), "networks"); err != nil {
+		return nil, err
+	}
+	if req.GetInput().GetInput() == nil {
+		return nil, status.Error(codes.InvalidArgument, "input is required")
+	}
+	return &v1types.Transaction{Input: req.GetInput()}, nil
+}
+
+func (f *fakeProtocol) ConstructTransferTransaction(ctx context.Context, req *protocols.ConstructTransferTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	if err := f.check(req.GetNetwork(), "networks"); err != nil {
+		return nil, err
+	}
+	if req.GetRecipient() == "" || req.GetAmount() == "" {
+		return nil, status.Error(codes.InvalidArgument, "recipient and amount are required")
+	}
+	return &v1types.Transaction{Input: &v1types.TransactionInput{Input: &v1types.TransactionInput_Ethereum_1559Input{
+		Ethereum_1559Input: &ethereum.EIP1559TransactionInput{ToAddress: req.GetRecipient(), Value: req.GetAmount(), Nonce: uint64(req.GetNonce())},
+	}}}, nil
+}
+
+func (f *fakeProtocol) BroadcastTransaction(ctx context.Context, req *protocols.BroadcastTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error) {
+	if err := f.check(req.GetNetwork(), "networks"); err != nil {
+		return nil, err
+	}
+	if len(req.GetTransaction().GetRawSignedTransaction()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "transaction.raw_signed_transaction is required")
+	}
+
+	transaction := proto.Clone(req.GetTransaction()).(*v1types.Transaction)
+	transaction.Hash = fmt.Sprintf("0x%064x", resourceIDs.Add(1))
+	return transaction, nil
+}
+
+// fakeWaaS holds a fake of each WaaS service.
+type fakeWaaS struct {
+	blockchain     fakeBlockchain
+	mpcKey         fakeMPCKey
+	mpcTransaction fakeMPCTransaction
+	mpcWallet      fakeMPCWallet
+	pool           fakePool
+	protocol       fakeProtocol
+}
+
+// services returns the fakes as the services of a router.
+func (f *fakeWaaS) services() *services {
+	return &services{
+		blockchain:     &f.blockchain,
+		mpcKey:         &f.mpcKey,
+		mpcTransaction: &f.mpcTransaction,
+		mpcWallet:      &f.mpcWallet,
+		pool:           &f.pool,
+		protocol:       &f.protocol,
+	}
+}
+
+// fail makes every call to the fakes fail with err.
+func (f *fakeWaaS) fail(err error) {
+	f.blockchain.err = err
+	f.mpcKey.err = err
+	f.mpcTransaction.err = err
+	f.mpcWallet.err = err
+	f.pool.err = err
+	f.protocol.err = err
+}
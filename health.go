@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coinbase/waas-client-library-go/auth"
+	blockchain "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/blockchain/v1"
+	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	mpcWallet "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_wallets/v1"
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	protocols "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/v1"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// Statuses reported by /readyz, overall and per dependency.
+	readinessReady        = "ready"
+	readinessNotReady     = "not_ready"
+	readinessShuttingDown = "shutting_down"
+	dependencyOK          = "ok"
+	dependencyFailing     = "failing"
+
+	// readinessRPC names the upstream calls of the readiness probes in metrics and traces.
+	readinessRPC = "ReadinessProbe"
+
+	// readinessProbeName is the ID of the resources the probes of services without a
+	// parameterless RPC look up. They are not expected to exist.
+	readinessProbeName = "readiness-probe"
+)
+
+var (
+	// readinessCacheTTL is how long probe results are reused across /readyz requests.
+	readinessCacheTTL = flag.Duration("readiness-cache-ttl", 10*time.Second, "how long /readyz reuses the results of its upstream probes")
+
+	// readinessProbeTimeout bounds each upstream probe.
+	readinessProbeTimeout = flag.Duration("readiness-probe-timeout", 3*time.Second, "deadline of each upstream probe run by /readyz")
+)
+
+// reachableCodes are the codes of upstream errors showing that a service is up and accepts
+// the proxy's credentials, such as NOT_FOUND for the probe resources. PERMISSION_DENIED is
+// not one of them: it is also how a revoked API key is answered.
+var reachableCodes = map[string]bool{
+	codeName(codes.InvalidArgument):    true,
+	codeName(codes.NotFound):           true,
+	codeName(codes.FailedPrecondition): true,
+	codeName(codes.ResourceExhausted):  true,
+}
+
+// readinessProbe checks a single dependency of the proxy.
+type readinessProbe struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// dependencyStatus is the outcome of a probe, as reported by /readyz.
+type dependencyStatus struct {
+	Status    string    `json:"status"`
+	Latency   string    `json:"latency"`
+	CheckTime time.Time `json:"checkTime"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// readinessResponse is the JSON body of /readyz.
+type readinessResponse struct {
+	Status       string                       `json:"status"`
+	Dependencies map[string]*dependencyStatus `json:"dependencies"`
+}
+
+// readinessChecker runs the probes on demand, caching their results for ttl.
+type readinessChecker struct {
+	probes  []readinessProbe
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu        sync.Mutex
+	checkTime time.Time
+	results   map[string]*dependencyStatus
+}
+
+// newReadinessChecker returns a checker running probes with the given timeout and
+// caching their results for ttl.
+func newReadinessChecker(ttl, timeout time.Duration, probes ...readinessProbe) *readinessChecker {
+	return &readinessChecker{probes: probes, ttl: ttl, timeout: timeout}
+}
+
+// check returns the results of the probes, running them concurrently if the cached ones
+// are stale. Concurrent callers share a single run, which is detached from their requests.
+func (r *readinessChecker) check() map[string]*dependencyStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.results != nil && time.Since(r.checkTime) < r.ttl {
+		return r.results
+	}
+
+	results := make(map[string]*dependencyStatus, len(r.probes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, probe := range r.probes {
+		wg.Add(1)
+		go func(probe readinessProbe) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(withRPC(context.Background(), readinessRPC), r.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := probe.check(ctx)
+			result := &dependencyStatus{Status: dependencyOK, Latency: time.Since(start).String(), CheckTime: start.UTC()}
+			if err != nil {
+				result.Status = dependencyFailing
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[probe.name] = result
+			mu.Unlock()
+		}(probe)
+	}
+	wg.Wait()
+
+	r.results = results
+	r.checkTime = time.Now()
+	return results
+}
+
+// probeError returns the error of an upstream probe call, or nil if the service
+// answered it in a way showing it is up.
+func probeError(err error) error {
+	if err == nil || errors.Is(err, iterator.Done) {
+		return nil
+	}
+	if _, response := translateError(err); reachableCodes[response.Code] {
+		return nil
+	}
+	return err
+}
+
+// upstreamProbes returns the probes of the loaded API key, unless apiKey is nil, and of
+// each WaaS service. The services without a parameterless RPC are probed by looking up a
+// resource that does not exist, which they answer with NOT_FOUND when up.
+func upstreamProbes(apiKey *auth.APIKey, svc *services) []readinessProbe {
+	walletName := "pools/" + readinessProbeName + "/mpcWallets/" + readinessProbeName
+
+	var probes []readinessProbe
+	if apiKey != nil {
+		probes = append(probes, readinessProbe{"credentials", func(ctx context.Context) error {
+			_, err := auth.NewAuthenticator(apiKey).BuildJWT(blockchainService, "GET "+readinessProbeName)
+			return err
+		}})
+	}
+	return append(probes, []readinessProbe{
+		{serviceLabel(blockchainService), func(ctx context.Context) error {
+			_, err := svc.blockchain.ListNetworks(ctx, &blockchain.ListNetworksRequest{PageSize: 1}).Next()
+			return probeError(err)
+		}},
+		{serviceLabel(poolService), func(ctx context.Context) error {
+			_, err := svc.pool.ListPools(ctx, &pools.ListPoolsRequest{PageSize: 1}).Next()
+			return probeError(err)
+		}},
+		{serviceLabel(mpcKeyService), func(ctx context.Context) error {
+			_, err := svc.mpcKey.GetDevice(ctx, &mpcKeys.GetDeviceRequest{Name: "devices/" + readinessProbeName})
+			return probeError(err)
+		}},
+		{serviceLabel(mpcWalletService), func(ctx context.Context) error {
+			_, err := svc.mpcWallet.GetMPCWallet(ctx, &mpcWallet.GetMPCWalletRequest{Name: walletName})
+			return probeError(err)
+		}},
+		{serviceLabel(mpcTransactionService), func(ctx context.Context) error {
+			_, err := svc.mpcTransaction.GetMPCTransaction(ctx, &mpcTransactions.GetMPCTransactionRequest{Name: walletName + "/mpcTransactions/" + readinessProbeName})
+			return probeError(err)
+		}},
+		{serviceLabel(protocolService), func(ctx context.Context) error {
+			_, err := svc.protocol.ConstructTransaction(ctx, &protocols.ConstructTransactionRequest{Network: "networks/" + readinessProbeName})
+			return probeError(err)
+		}},
+	}...)
+}
+
+// healthz responds whether the process is alive.
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": dependencyOK})
+}
+
+// readyz returns the handler responding whether the proxy can serve requests: it is not
+// shutting down, and every probe of r succeeds.
+func readyz(r *readinessChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response := &readinessResponse{Status: readinessReady, Dependencies: r.check()}
+		for _, dependency := range response.Dependencies {
+			if dependency.Status != dependencyOK {
+				response.Status = readinessNotReady
+			}
+		}
+		if shuttingDown.Load() {
+			response.Status = readinessShuttingDown
+		}
+
+		httpStatus := http.StatusOK
+		if response.Status != readinessReady {
+			httpStatus = http.StatusServiceUnavailable
+		}
+		c.JSON(httpStatus, response)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestProbeError(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		err     error
+		wantErr bool
+	}{
+		{name: "ok"},
+		{name: "empty list", err: iterator.Done},
+		{name: "not found", err: status.Error(codes.NotFound, "no such wallet")},
+		{name: "invalid argument", err: status.Error(codes.InvalidArgument, "bad network")},
+		{name: "unavailable", err: status.Error(codes.Unavailable, "unavailable"), wantErr: true},
+		{name: "unauthenticated", err: status.Error(codes.Unauthenticated, "bad key"), wantErr: true},
+		{name: "permission denied", err: status.Error(codes.PermissionDenied, "key lacks access"), wantErr: true},
+		{name: "deadline", err: context.DeadlineExceeded, wantErr: true},
+	} {
+		if err := probeError(tt.err); (err != nil) != tt.wantErr {
+			t.Errorf("probeError(%s) = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReadinessChecker(t *testing.T) {
+	var runs atomic.Int32
+	var failing atomic.Bool
+	checker := newReadinessChecker(time.Hour, time.Second,
+		readinessProbe{"pool", func(ctx context.Context) error {
+			runs.Add(1)
+			if failing.Load() {
+				return errors.New("unavailable")
+			}
+			return nil
+		}},
+		readinessProbe{"deadline", func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				return errors.New("no deadline")
+			}
+			return nil
+		}},
+	)
+
+	results := checker.check()
+	if len(results) != 2 || results["pool"].Status != dependencyOK || results["deadline"].Status != dependencyOK {
+		t.Fatalf("check() = %v, want both probes ok", results)
+	}
+
+	// Results are reused until they are stale.
+	failing.Store(true)
+	if results := checker.check(); runs.Load() != 1 || results["pool"].Status != dependencyOK {
+		t.Errorf("check() ran the probes %d times, want the cached results", runs.Load())
+	}
+	checker.checkTime = checker.checkTime.Add(-time.Hour)
+	if results := checker.check(); runs.Load() != 2 || results["pool"].Status != dependencyFailing || results["pool"].Error != "unavailable" {
+		t.Errorf("check() of stale results = %+v after %d runs, want the probe failing", results["pool"], runs.Load())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	defer shuttingDown.Store(false)
+
+	serve := func(checker *readinessChecker) (int, *readinessResponse) {
+		router := gin.New()
+		router.GET("/readyz", readyz(checker))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var response readinessResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("/readyz = %s, want JSON: %v", w.Body, err)
+		}
+		return w.Code, &response
+	}
+	ok := readinessProbe{"pool", func(ctx context.Context) error { return nil }}
+	failing := readinessProbe{"blockchain", func(ctx context.Context) error { return errors.New("unavailable") }}
+
+	for _, tt := range []struct {
+		name         string
+		probes       []readinessProbe
+		shuttingDown bool
+		wantCode     int
+		wantStatus   string
+	}{
+		{name: "ready", probes: []readinessProbe{ok}, wantCode: http.StatusOK, wantStatus: readinessReady},
+		{name: "failing dependency", probes: []readinessProbe{ok, failing}, wantCode: http.StatusServiceUnavailable, wantStatus: readinessNotReady},
+		{name: "shutting down", probes: []readinessProbe{ok}, shuttingDown: true, wantCode: http.StatusServiceUnavailable, wantStatus: readinessShuttingDown},
+	} {
+		shuttingDown.Store(tt.shuttingDown)
+		code, response := serve(newReadinessChecker(time.Hour, time.Second, tt.probes...))
+		if code != tt.wantCode || response.Status != tt.wantStatus || len(response.Dependencies) != len(tt.probes) {
+			t.Errorf("%s: /readyz = %d %+v, want %d %s", tt.name, code, response, tt.wantCode, tt.wantStatus)
+		}
+	}
+
+	router := gin.New()
+	router.GET("/healthz", healthz)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("/healthz = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	idempotencyKeyContextKey  = "idempotencyKey"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentResponseSize = 1 << 20
+
+	// idempotencySweepInterval is how often expired responses are dropped.
+	idempotencySweepInterval = 10 * time.Minute
+)
+
+// idempotencyNamespace is the UUID namespace of the WaaS request IDs derived from
+// Idempotency-Key headers, 52e4eda6-9a1f-462f-ad24-63e995ce263b. It was generated for the
+// proxy, so that the IDs cannot collide with name-based UUIDs derived by other software.
+var idempotencyNamespace = []byte{0x52, 0xe4, 0xed, 0xa6, 0x9a, 0x1f, 0x46, 0x2f, 0xad, 0x24, 0x63, 0xe9, 0x95, 0xce, 0x26, 0x3b}
+
+var (
+	// idempotencyStoreFile persists the cached responses across restarts.
+	idempotencyStoreFile = flag.String("idempotency-store", "", "path to the JSON-lines file persisting responses to requests with an Idempotency-Key; in memory if unset")
+
+	// idempotencyTTL is how long responses are replayed for.
+	idempotencyTTL = flag.Duration("idempotency-ttl", 24*time.Hour, "how long the response to a request with an Idempotency-Key is replayed")
+)
+
+// errIdempotencyKeyReused is returned when a key is reused for a different request.
+var errIdempotencyKeyReused = errors.New("Idempotency-Key was already used for a different request")
+
+// idempotentResponse is a cached response, persisted as one JSON line per key.
+type idempotentResponse struct {
+	Key         string            `json:"key"`
+	Fingerprint string            `json:"fingerprint"`
+	Status      int               `json:"status"`
+	Header      map[string]string `json:"header,omitempty"`
+	Body        []byte            `json:"body"`
+	ExpireTime  time.Time         `json:"expireTime"`
+}
+
+// idempotencyStore caches the responses to requests carrying an Idempotency-Key.
+type idempotencyStore struct {
+	ttl  time.Duration
+	path string
+	file *os.File
+
+	mu        sync.Mutex
+	responses map[string]*idempotentResponse
+	inFlight  map[string]string
+}
+
+// newIdempotencyStore returns a store persisting responses to the file at path, or
+// keeping them in memory if path is empty. Expired responses are dropped on startup and
+// periodically until ctx is done.
+func newIdempotencyStore(ctx context.Context, path string, ttl time.Duration) (*idempotencyStore, error) {
+	if ttl <= 0 {
+		return nil, fmt.Errorf("-idempotency-ttl must be positive, got %v", ttl)
+	}
+
+	s := &idempotencyStore{ttl: ttl, path: path, responses: map[string]*idempotentResponse{}, inFlight: map[string]string{}}
+	if path != "" {
+		if err := s.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	go s.sweep(ctx)
+	return s, nil
+}
+
+// load reads the unexpired responses persisted in the file, and compacts it.
+func (s *idempotencyStore) load() error {
+	now := time.Now()
+
+	f, err := os.Open(s.path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("cannot read idempotency store: %v", err)
+	default:
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64<<10), 2*maxIdempotentResponseSize)
+		for scanner.Scan() {
+			response := &idempotentResponse{}
+			// A line torn by a crash is skipped; its request may simply run again.
+			if err := json.Unmarshal(scanner.Bytes(), response); err != nil {
+				continue
+			}
+			if response.ExpireTime.After(now) {
+				s.responses[response.Key] = response
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("cannot read idempotency store: %v", err)
+		}
+	}
+
+	return s.compactLocked()
+}
+
+// compactLocked rewrites the file with the cached responses only, and reopens it for
+// appending. s.mu must be held once the store is shared.
+func (s *idempotencyStore) compactLocked() error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("cannot compact idempotency store: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for _, response := range s.responses {
+		if err := encoder.Encode(response); err != nil {
+			tmp.Close()
+			return fmt.Errorf("cannot compact idempotency store: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cannot compact idempotency store: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cannot compact idempotency store: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("cannot compact idempotency store: %v", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("cannot open idempotency store: %v", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	return nil
+}
+
+// begin returns the cached response for key, or marks the request in flight if there
+// is none. It reports whether another request with the key is still in flight.
+func (s *idempotencyStore) begin(key, fingerprint string) (*idempotentResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if response, ok := s.responses[key]; ok && response.ExpireTime.After(time.Now()) {
+		if response.Fingerprint != fingerprint {
+			return nil, false, errIdempotencyKeyReused
+		}
+		return response, false, nil
+	}
+	delete(s.responses, key)
+
+	if inFlight, ok := s.inFlight[key]; ok {
+		if inFlight != fingerprint {
+			return nil, false, errIdempotencyKeyReused
+		}
+		return nil, true, nil
+	}
+	s.inFlight[key] = fingerprint
+	return nil, false, nil
+}
+
+// finish caches response, if set, and releases the key for the next request.
+func (s *idempotencyStore) finish(key string, response *idempotentResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inFlight, key)
+	if response == nil {
+		return
+	}
+
+	response.Key = key
+	response.ExpireTime = time.Now().Add(s.ttl).UTC()
+	s.responses[key] = response
+
+	if s.file != nil {
+		line, err := json.Marshal(response)
+		if err == nil {
+			_, err = s.file.Write(append(line, '\n'))
+		}
+		if err != nil {
+			logger.Error("Error persisting idempotent response", zap.Error(err))
+		}
+	}
+}
+
+// sweep drops expired responses every idempotencySweepInterval until ctx is done.
+func (s *idempotencyStore) sweep(ctx context.Context) {
+	ticker := time.NewTicker(idempotencySweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.dropExpired(now)
+		}
+	}
+}
+
+// dropExpired drops the responses expired at now from memory and, by compacting it, from
+// the file.
+func (s *idempotencyStore) dropExpired(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dropped := false
+	for key, response := range s.responses {
+		if !response.ExpireTime.After(now) {
+			delete(s.responses, key)
+			dropped = true
+		}
+	}
+	if dropped && s.file != nil {
+		if err := s.compactLocked(); err != nil {
+			logger.Error("Error compacting idempotency store", zap.Error(err))
+		}
+	}
+}
+
+// idempotency returns middleware replaying the original response to a POST request
+// retried with the same Idempotency-Key. Keys are scoped to the caller, and reusing
+// one for a different request is rejected. Responses to rejected or transiently failed
+// requests are not cached, so that they can be retried.
+func idempotency(store *idempotencyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			writeBadRequest(c, fmt.Errorf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+		if identity := identityFromContext(c); identity != nil {
+			key = identity.ID + "/" + key
+		}
+
+		body, ok := readRequestBody(c)
+		if !ok {
+			return
+		}
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s %s?%s\n", c.Request.Method, c.Request.URL.Path, c.Request.URL.RawQuery)
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		cached, inFlight, err := store.begin(key, fingerprint)
+		switch {
+		case err != nil:
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, &errorResponse{Code: codeName(codes.InvalidArgument), Message: err.Error()})
+			return
+		case inFlight:
+			abortWithCode(c, codes.Aborted, "a request with the same Idempotency-Key is in progress")
+			return
+		case cached != nil:
+			for name, value := range cached.Header {
+				c.Header(name, value)
+			}
+			c.Header(idempotentReplayedHeader, "true")
+			c.Status(cached.Status)
+			c.Writer.Write(cached.Body)
+			c.Abort()
+			return
+		}
+
+		c.Set(idempotencyKeyContextKey, key)
+		writer := &captureWriter{ResponseWriter: c.Writer, limit: maxIdempotentResponseSize}
+		c.Writer = writer
+		defer func() {
+			var response *idempotentResponse
+			if status := writer.Status(); idempotentStatus(status) && !writer.truncated {
+				response = &idempotentResponse{Fingerprint: fingerprint, Status: status, Header: map[string]string{}, Body: writer.body.Bytes()}
+				for _, name := range []string{"Content-Type", "Location"} {
+					if value := writer.Header().Get(name); value != "" {
+						response.Header[name] = value
+					}
+				}
+			}
+			store.finish(key, response)
+		}()
+		c.Next()
+	}
+}
+
+// idempotentStatus reports whether a response with status is final and replayed to
+// retries, rather than one the caller may retry to a different outcome.
+func idempotentStatus(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests, statusClientClosedRequest:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+// idempotentRequestID returns requestID, or if it is empty, the WaaS request ID derived
+// from the request's Idempotency-Key, so that retries are also deduplicated upstream.
+func idempotentRequestID(c *gin.Context, requestID string) string {
+	key := c.GetString(idempotencyKeyContextKey)
+	if requestID != "" || key == "" {
+		return requestID
+	}
+
+	// A name-based (version 5) UUID of the caller-scoped key.
+	hash := sha1.New()
+	hash.Write(idempotencyNamespace)
+	hash.Write([]byte(key))
+	b := hash.Sum(nil)[:16]
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return formatUUID(b)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+)
+
+// newTestIdempotencyStore returns a store persisted at path, or in memory if it is empty.
+func newTestIdempotencyStore(t *testing.T, path string, ttl time.Duration) *idempotencyStore {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	store, err := newIdempotencyStore(ctx, path, ttl)
+	if err != nil {
+		t.Fatalf("newIdempotencyStore() = %v", err)
+	}
+	return store
+}
+
+// newIdempotencyRouter returns a router serving POST /pools behind the idempotency
+// middleware, identifying callers by their X-Caller header. The handler responds with
+// the number of requests it served, and fails with status if the request asks for it.
+func newIdempotencyRouter(store *idempotencyStore) (*gin.Engine, *int) {
+	calls := 0
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set(identityContextKey, &Identity{ID: c.GetHeader("X-Caller")}) }, idempotency(store))
+	router.POST("/pools", func(c *gin.Context) {
+		calls++
+		if c.Query("fail") != "" {
+			abortWithCode(c, codes.Unavailable, "upstream unavailable")
+			return
+		}
+		c.Header("Location", "/pools/"+fmt.Sprint(calls))
+		c.JSON(http.StatusOK, gin.H{"calls": calls, "requestId": idempotentRequestID(c, "")})
+	})
+	return router, &calls
+}
+
+// postIdempotent sends a POST request with an Idempotency-Key on behalf of caller.
+func postIdempotent(router *gin.Engine, caller, key, target, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("X-Caller", caller)
+	if key != "" {
+		r.Header.Set(idempotencyKeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	router, calls := newIdempotencyRouter(newTestIdempotencyStore(t, "", time.Hour))
+
+	first := postIdempotent(router, "alice", "key-1", "/pools", `{"displayName": "a"}`)
+	if first.Code != http.StatusOK || *calls != 1 || first.Header().Get(idempotentReplayedHeader) != "" {
+		t.Fatalf("first POST = %d %s, want the handler's response", first.Code, first.Body)
+	}
+
+	// A retry replays the response without calling the handler again.
+	replay := postIdempotent(router, "alice", "key-1", "/pools", `{"displayName": "a"}`)
+	if replay.Code != http.StatusOK || *calls != 1 || replay.Body.String() != first.Body.String() ||
+		replay.Header().Get(idempotentReplayedHeader) != "true" || replay.Header().Get("Location") != "/pools/1" ||
+		replay.Header().Get("Content-Type") != first.Header().Get("Content-Type") {
+		t.Errorf("retried POST = %d %v %s, want the replayed response", replay.Code, replay.Header(), replay.Body)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		caller     string
+		key        string
+		target     string
+		body       string
+		wantStatus int
+		wantCalls  int
+	}{
+		{name: "different body", caller: "alice", key: "key-1", target: "/pools", body: `{"displayName": "b"}`, wantStatus: http.StatusUnprocessableEntity, wantCalls: 1},
+		{name: "different query", caller: "alice", key: "key-1", target: "/pools?wait=true", body: `{"displayName": "a"}`, wantStatus: http.StatusUnprocessableEntity, wantCalls: 1},
+		{name: "other caller", caller: "bob", key: "key-1", target: "/pools", body: `{"displayName": "a"}`, wantStatus: http.StatusOK, wantCalls: 2},
+		{name: "no key", caller: "alice", target: "/pools", body: `{"displayName": "a"}`, wantStatus: http.StatusOK, wantCalls: 3},
+		{name: "long key", caller: "alice", key: strings.Repeat("k", maxIdempotencyKeyLength+1), target: "/pools", wantStatus: http.StatusBadRequest, wantCalls: 3},
+	} {
+		w := postIdempotent(router, tt.caller, tt.key, tt.target, tt.body)
+		if w.Code != tt.wantStatus || *calls != tt.wantCalls {
+			t.Errorf("%s: POST = %d %s after %d calls, want %d after %d calls", tt.name, w.Code, w.Body, *calls, tt.wantStatus, tt.wantCalls)
+		}
+	}
+
+	// Transient failures are not cached, so that the request can be retried.
+	for i := 0; i < 2; i++ {
+		if w := postIdempotent(router, "alice", "key-2", "/pools?fail=true", "{}"); w.Code != http.StatusServiceUnavailable || w.Header().Get(idempotentReplayedHeader) != "" {
+			t.Errorf("failed POST = %d %v, want 503 without a replay", w.Code, w.Header())
+		}
+	}
+	if *calls != 5 {
+		t.Errorf("handler called %d times, want 5", *calls)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	store := newTestIdempotencyStore(t, "", time.Hour)
+
+	if cached, inFlight, err := store.begin("alice/key", "a"); cached != nil || inFlight || err != nil {
+		t.Fatalf("begin() = %v, %v, %v, want the request to proceed", cached, inFlight, err)
+	}
+	if _, inFlight, err := store.begin("alice/key", "a"); !inFlight || err != nil {
+		t.Errorf("begin() of a request in flight = %v, %v, want in flight", inFlight, err)
+	}
+	if _, _, err := store.begin("alice/key", "b"); err != errIdempotencyKeyReused {
+		t.Errorf("begin() of a different request in flight = %v, want %v", err, errIdempotencyKeyReused)
+	}
+
+	// A request that was not cached releases its key.
+	store.finish("alice/key", nil)
+	if _, inFlight, err := store.begin("alice/key", "b"); inFlight || err != nil {
+		t.Errorf("begin() after finish() = %v, %v, want the request to proceed", inFlight, err)
+	}
+}
+
+func TestIdempotencyStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.jsonl")
+	store := newTestIdempotencyStore(t, path, time.Hour)
+	store.begin("alice/key-1", "a")
+	store.finish("alice/key-1", &idempotentResponse{Fingerprint: "a", Status: http.StatusOK, Body: []byte(`{"name": "pools/p"}`)})
+
+	// Expired responses are dropped, and lines torn by a crash skipped, on startup.
+	expired := fmt.Sprintf(`{"key": "alice/old", "fingerprint": "a", "status": 200, "expireTime": %q}`, time.Now().Add(-time.Minute).Format(time.RFC3339))
+	store.file.WriteString(expired + "\n{\"key\": \"alice/torn\"\n")
+	store.file.Close()
+
+	restarted := newTestIdempotencyStore(t, path, time.Hour)
+	if cached, _, err := restarted.begin("alice/key-1", "a"); err != nil || cached == nil || string(cached.Body) != `{"name": "pools/p"}` {
+		t.Errorf("begin() after a restart = %+v, %v, want the persisted response", cached, err)
+	}
+	if cached, _, _ := restarted.begin("alice/old", "a"); cached != nil {
+		t.Errorf("begin() of an expired response = %+v, want none", cached)
+	}
+	if len(restarted.responses) != 1 {
+		t.Errorf("restarted store holds %d responses, want 1", len(restarted.responses))
+	}
+}
+
+func TestIdempotencyStoreSweep(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idempotency.jsonl")
+	store := newTestIdempotencyStore(t, path, time.Hour)
+	for _, key := range []string{"alice/old", "alice/new"} {
+		store.begin(key, "a")
+		store.finish(key, &idempotentResponse{Fingerprint: "a", Status: http.StatusOK})
+	}
+	store.responses["alice/old"].ExpireTime = time.Now().Add(-time.Minute)
+
+	// Expired responses are dropped from the file as well as from memory.
+	store.dropExpired(time.Now())
+	if _, ok := store.responses["alice/old"]; ok || len(store.responses) != 1 {
+		t.Errorf("store holds %d responses after a sweep, want alice/new", len(store.responses))
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() = %v", err)
+	}
+	if strings.Contains(string(content), "alice/old") || !strings.Contains(string(content), "alice/new") {
+		t.Errorf("store file after a sweep = %s, want only alice/new", content)
+	}
+
+	// Responses finished after a sweep are appended to the compacted file.
+	store.begin("alice/later", "a")
+	store.finish("alice/later", &idempotentResponse{Fingerprint: "a", Status: http.StatusOK})
+	restarted := newTestIdempotencyStore(t, path, time.Hour)
+	if len(restarted.responses) != 2 || restarted.responses["alice/later"] == nil {
+		t.Errorf("restarted store holds %d responses, want alice/new and alice/later", len(restarted.responses))
+	}
+}
+
+func TestIdempotentRequestID(t *testing.T) {
+	router, _ := newIdempotencyRouter(newTestIdempotencyStore(t, "", time.Hour))
+	requestID := func(caller, key string) string {
+		w := postIdempotent(router, caller, key, "/pools", "{}")
+		match := regexp.MustCompile(`"requestId":"([^"]*)"`).FindStringSubmatch(w.Body.String())
+		if match == nil {
+			t.Fatalf("POST = %d %s, want a request ID", w.Code, w.Body)
+		}
+		return match[1]
+	}
+
+	// The version 5 UUID of "alice/key-1" in the proxy's namespace.
+	id := requestID("alice", "key-1")
+	if want := "a6821778-7d95-502c-ad3a-01cde52716e9"; id != want {
+		t.Errorf("derived request ID = %q, want %q", id, want)
+	}
+	if other := requestID("bob", "key-1"); other == id {
+		t.Errorf("derived request IDs of two callers are both %q, want distinct IDs", id)
+	}
+	if other := requestID("alice", "key-2"); other == id {
+		t.Errorf("derived request IDs of two keys are both %q, want distinct IDs", id)
+	}
+	if none := requestID("alice", ""); none != "" {
+		t.Errorf("request ID without an Idempotency-Key = %q, want none", none)
+	}
+
+	// An explicit request ID is kept.
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(idempotencyKeyContextKey, "alice/key-1")
+	if got := idempotentRequestID(c, "explicit"); got != "explicit" {
+		t.Errorf("idempotentRequestID(explicit) = %q, want explicit", got)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// unixSocketPrefix marks -listen addresses that are Unix socket paths.
+const unixSocketPrefix = "unix:"
+
+// shuttingDown is set once the proxy starts shutting down.
+var shuttingDown atomic.Bool
+
+var (
+	// listenAddrs are the addresses the proxy serves on.
+	listenAddrs = flag.String("listen", ":8080", "comma-separated addresses to listen on; prefix Unix socket paths with unix:")
+
+	// shutdownDelay is how long the proxy keeps serving after a shutdown signal, so load
+	// balancers see /readyz fail and stop routing to it first.
+	shutdownDelay = flag.Duration("shutdown-delay", 0, "how long to keep serving on SIGINT or SIGTERM while /readyz reports shutting down, before draining")
+
+	// shutdownTimeout bounds how long in-flight requests may take to finish on shutdown.
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "how long to drain in-flight requests on SIGINT or SIGTERM")
+
+	// tlsCertFile and tlsKeyFile enable TLS on TCP listeners.
+	tlsCertFile = flag.String("tls-cert", "", "path to the PEM server certificate; enables TLS on TCP listeners")
+	tlsKeyFile  = flag.String("tls-key", "", "path to the PEM server private key")
+
+	// tlsReloadInterval is how often the certificate files are checked for changes.
+	tlsReloadInterval = flag.Duration("tls-reload-interval", 30*time.Second, "how often to check the TLS certificate files for changes")
+
+	// tlsClientCAFile enables mutual TLS.
+	tlsClientCAFile = flag.String("tls-client-ca", "", "path to the PEM bundle of CAs verifying client certificates; enables mutual TLS")
+
+	// tlsClientAuth selects whether client certificates are required with -tls-client-ca.
+	tlsClientAuth = flag.String("tls-client-auth", "require", "with -tls-client-ca, whether client certificates are required or optional")
+)
+
+// certReloader serves the TLS certificate from a pair of files, reloading it when they change.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader loads the certificate from certFile and keyFile.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the certificate again if either file changed since the last load, and
+// reports whether it did. The current certificate is kept if the new one is invalid.
+func (r *certReloader) reload() (bool, error) {
+	var modTime time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, fmt.Errorf("cannot stat TLS certificate: %v", err)
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("cannot load TLS certificate: %v", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return true, nil
+}
+
+// watch reloads the certificate every interval until ctx is done.
+func (r *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				logger.Error("Error reloading TLS certificate, keeping the current one", zap.Error(err))
+			} else if reloaded {
+				logger.Info("Reloaded TLS certificate", zap.String("file", r.certFile))
+			}
+		}
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// newTLSConfig returns the TLS configuration set by the -tls-* flags, or nil if TLS is
+// disabled. The certificate is reloaded on change until ctx is done.
+func newTLSConfig(ctx context.Context) (*tls.Config, error) {
+	if *tlsCertFile == "" && *tlsKeyFile == "" {
+		if *tlsClientCAFile != "" {
+			return nil, errors.New("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if *tlsCertFile == "" || *tlsKeyFile == "" {
+		return nil, errors.New("-tls-cert and -tls-key must be set together")
+	}
+
+	reloader, err := newCertReloader(*tlsCertFile, *tlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if *tlsReloadInterval > 0 {
+		go reloader.watch(ctx, *tlsReloadInterval)
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if *tlsClientCAFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(*tlsClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read client CA bundle: %v", err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA bundle %q", *tlsClientCAFile)
+	}
+
+	switch *tlsClientAuth {
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("unknown -tls-client-auth %q, want require or optional", *tlsClientAuth)
+	}
+	return config, nil
+}
+
+// listen opens the comma-separated addresses. TCP listeners serve TLS when tlsConfig is
+// set; Unix sockets are local and always serve plaintext.
+func listen(addrs string, tlsConfig *tls.Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+
+		if strings.HasPrefix(addr, unixSocketPrefix) {
+			socket := strings.TrimPrefix(addr, unixSocketPrefix)
+			// Remove the socket left behind by a previous run that did not shut down cleanly.
+			if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+				closeAll()
+				return nil, fmt.Errorf("cannot remove stale socket %q: %v", socket, err)
+			}
+			l, err := net.Listen("unix", socket)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			listeners = append(listeners, l)
+			continue
+		}
+
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if tlsConfig != nil {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if len(listeners) == 0 {
+		return nil, errors.New("-listen must name at least one address")
+	}
+	return listeners, nil
+}
+
+// serve serves on every listener until SIGINT or SIGTERM, then reports shutting down for
+// -shutdown-delay, stops accepting connections and waits up to -shutdown-timeout for
+// in-flight requests before closing the rest.
+func serve(server *http.Server, listeners []net.Listener) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		logger.Info("Proxy server listening", zap.String("network", l.Addr().Network()), zap.String("address", l.Addr().String()))
+		go func(l net.Listener) {
+			errs <- server.Serve(l)
+		}(l)
+	}
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		// A second signal kills the process without waiting for the drain.
+		stop()
+		shuttingDown.Store(true)
+		if *shutdownDelay > 0 {
+			logger.Info("Shutting down, reporting not ready", zap.Duration("delay", *shutdownDelay))
+			time.Sleep(*shutdownDelay)
+		}
+		logger.Info("Shutting down, draining in-flight requests", zap.Duration("timeout", *shutdownTimeout))
+	case serveErr = <-errs:
+		shuttingDown.Store(true)
+		logger.Error("Error serving, shutting down", zap.Error(serveErr))
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("Drain timed out, closing remaining connections", zap.Error(err))
+		server.Close()
+	}
+	return serveErr
+}
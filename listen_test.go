@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestCert returns a self-signed certificate for commonName, and writes it and its key
+// as PEM files into dir.
+func newTestCert(t *testing.T, dir, commonName string) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName + ".internal"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := writeTestFile(t, dir, commonName+".crt", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile := writeTestFile(t, dir, commonName+".key", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return cert, certFile, keyFile
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, keyFile := newTestCert(t, dir, "proxy")
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("newCertReloader() = %v", err)
+	}
+	first, _ := r.GetCertificate(nil)
+	if reloaded, err := r.reload(); reloaded || err != nil {
+		t.Errorf("reload() of unchanged files = %v, %v, want no reload", reloaded, err)
+	}
+
+	// A rotated certificate is picked up.
+	_, newCertFile, newKeyFile := newTestCert(t, dir, "rotated")
+	for _, rename := range [][2]string{{newCertFile, certFile}, {newKeyFile, keyFile}} {
+		if err := os.Rename(rename[0], rename[1]); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(rename[1], later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reloaded, err := r.reload(); !reloaded || err != nil {
+		t.Errorf("reload() of rotated files = %v, %v, want a reload", reloaded, err)
+	}
+	second, _ := r.GetCertificate(nil)
+	if second == first {
+		t.Error("GetCertificate() returns the old certificate after a reload")
+	}
+
+	// An invalid certificate is rejected and the current one kept.
+	writeTestFile(t, dir, filepath.Base(certFile), "not a certificate")
+	later := time.Now().Add(2 * time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reload(); err == nil {
+		t.Error("reload() of an invalid certificate succeeded, want an error")
+	}
+	if current, _ := r.GetCertificate(nil); current != second {
+		t.Error("GetCertificate() changed after a failed reload, want the previous certificate")
+	}
+}
+
+func TestNewTLSConfigFlags(t *testing.T) {
+	defer func(cert, key, ca string) { *tlsCertFile, *tlsKeyFile, *tlsClientCAFile = cert, key, ca }(*tlsCertFile, *tlsKeyFile, *tlsClientCAFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, certFile, keyFile := newTestCert(t, t.TempDir(), "proxy")
+
+	for _, tt := range []struct {
+		name               string
+		cert, key, ca      string
+		wantConfig, wantOK bool
+	}{
+		{name: "plaintext", wantOK: true},
+		{name: "tls", cert: certFile, key: keyFile, wantConfig: true, wantOK: true},
+		{name: "mtls", cert: certFile, key: keyFile, ca: certFile, wantConfig: true, wantOK: true},
+		{name: "cert without key", cert: certFile},
+		{name: "client CA without cert", ca: certFile},
+		{name: "empty client CA", cert: certFile, key: keyFile, ca: keyFile},
+	} {
+		*tlsCertFile, *tlsKeyFile, *tlsClientCAFile = tt.cert, tt.key, tt.ca
+		config, err := newTLSConfig(ctx)
+		if (err == nil) != tt.wantOK || (config != nil) != tt.wantConfig {
+			t.Errorf("newTLSConfig(%s) = %v, %v", tt.name, config, err)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "proxy.sock")
+	// A socket left behind by a previous run is replaced.
+	writeTestFile(t, filepath.Dir(socket), filepath.Base(socket), "")
+
+	listeners, err := listen("127.0.0.1:0, unix:"+socket, nil)
+	if err != nil {
+		t.Fatalf("listen() = %v", err)
+	}
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	if len(listeners) != 2 || listeners[0].Addr().Network() != "tcp" || listeners[1].Addr().Network() != "unix" {
+		t.Fatalf("listen() = %v, want a TCP and a Unix listener", listeners)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })}
+	go server.Serve(listeners[1])
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+	resp, err := client.Get("http://proxy/healthz")
+	if err != nil {
+		t.Fatalf("GET over the Unix socket = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("GET over the Unix socket = %d", resp.StatusCode)
+	}
+
+	if _, err := listen(" , ", nil); err == nil {
+		t.Error("listen() of no addresses succeeded, want an error")
+	}
+}
+
+func TestClientCertIdentity(t *testing.T) {
+	cert, _, _ := newTestCert(t, t.TempDir(), "settlement-service")
+	store := &keyStore{byHash: map[string]*Identity{}, byClientCert: map[string]*Identity{
+		"settlement-service.internal": {ID: "settlement", Scopes: []string{scopeAll}},
+	}}
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	if identity := store.lookupClientCert(verified); identity == nil || identity.ID != "settlement" {
+		t.Errorf("lookupClientCert() = %+v, want the settlement identity by DNS name", identity)
+	}
+	if identity := store.lookupClientCert(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}); identity != nil {
+		t.Errorf("lookupClientCert() of an unverified certificate = %+v, want none", identity)
+	}
+
+	// A key takes precedence, and a certificate only identifies callers sending none.
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.TLS = verified
+	w := httptest.NewRecorder()
+	handler := authenticate(store)
+	c, _ := gin.CreateTestContext(w)
+	c.Request = r
+	handler(c)
+	if identity := identityFromContext(c); identity == nil || identity.ID != "settlement" {
+		t.Errorf("authenticate() over mTLS = %+v, want the settlement identity", identity)
+	}
+
+	r.Header.Set("X-API-Key", "unknown-key")
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = r
+	handler(c)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("authenticate() with an unknown key over mTLS = %d, want 401", w.Code)
+	}
+}
+
+func TestLoadKeyStoreClientCerts(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := loadKeyStore(writeTestFile(t, dir, "keys.json", `{"keys": [
+		{"id": "settlement", "clientCert": "settlement-service", "scopes": ["*"]}
+	]}`))
+	if err != nil {
+		t.Fatalf("loadKeyStore() = %v", err)
+	}
+	if identity := store.byClientCert["settlement-service"]; identity == nil || identity.ID != "settlement" {
+		t.Errorf("byClientCert[settlement-service] = %+v, want settlement", identity)
+	}
+
+	for name, contents := range map[string]string{
+		"neither.json":   `{"keys": [{"id": "a", "scopes": ["*"]}]}`,
+		"duplicate.json": `{"keys": [{"id": "a", "clientCert": "svc"}, {"id": "b", "clientCert": "svc"}]}`,
+	} {
+		if _, err := loadKeyStore(writeTestFile(t, dir, name, contents)); err == nil {
+			t.Errorf("loadKeyStore(%s) succeeded, want an error", name)
+		}
+	}
+}
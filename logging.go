@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// requestIDHeader carries the ID correlating a request across the caller, the proxy
+	// and WaaS.
+	requestIDHeader = "X-Request-ID"
+
+	// requestIDContextKey is the gin context key holding the request ID.
+	requestIDContextKey = "requestId"
+
+	// requestIDMetadataKey is the metadata key forwarding the request ID to WaaS.
+	requestIDMetadataKey = "x-request-id"
+
+	// maxRequestIDLength caps the length of a request ID accepted from a caller.
+	maxRequestIDLength = 128
+
+	// maxLoggedPayload caps how much of a request or response body is logged.
+	maxLoggedPayload = 4 << 10
+
+	// maxPayloadCapture caps how much of a request or response is buffered to be logged.
+	// Bodies beyond it cannot be parsed to redact their secrets, so are not logged.
+	maxPayloadCapture = 64 << 10
+
+	// redacted replaces secrets in logged values.
+	redacted = "[REDACTED]"
+)
+
+var (
+	// logLevel is the minimum level of the log entries written.
+	logLevel = flag.String("log-level", "info", "minimum level of the log entries written: debug, info, warn or error; debug also logs redacted request and response bodies")
+
+	// logFormat selects the encoding of the log entries.
+	logFormat = flag.String("log-format", "json", "encoding of the log entries: json, or console for local development")
+)
+
+// logger writes the proxy's structured log entries. It discards them until setupLogging
+// is called.
+var logger = zap.NewNop()
+
+// redactedKeys are the JSON keys, lower-cased without underscores, whose values are
+// secrets: private keys, raw signatures and serialized transactions.
+var redactedKeys = map[string]bool{
+	"privatekey":           true,
+	"signature":            true,
+	"signedpayload":        true,
+	"rawsignedtransaction": true,
+	"rawtransaction":       true,
+	"ethereumrlpinput":     true,
+	"rlpinput":             true,
+	"mpcdata":              true,
+	"registrationdata":     true,
+}
+
+// secretPatterns match secrets in free text: PEM private keys, and hex strings at least as
+// long as a signature.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`(0x)?[0-9a-fA-F]{128,}`),
+}
+
+// validRequestID matches the request IDs accepted from callers.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:/+=-]+$`)
+
+// setupLogging replaces logger with one writing entries as configured by the flags, and
+// routes the standard library logger through it.
+func setupLogging() error {
+	level, err := zapcore.ParseLevel(*logLevel)
+	if err != nil {
+		return fmt.Errorf("cannot parse -log-level: %v", err)
+	}
+
+	config := zap.NewProductionConfig()
+	switch *logFormat {
+	case "json":
+	case "console":
+		config = zap.NewDevelopmentConfig()
+	default:
+		return fmt.Errorf("unknown -log-format %q", *logFormat)
+	}
+	config.Level = zap.NewAtomicLevelAt(level)
+	config.Sampling = nil
+	config.EncoderConfig.TimeKey = "time"
+	config.EncoderConfig.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+	config.DisableStacktrace = level > zapcore.DebugLevel
+
+	built, err := config.Build(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return redactingCore{core}
+	}))
+	if err != nil {
+		return fmt.Errorf("cannot build logger: %v", err)
+	}
+
+	logger = built
+	zap.RedirectStdLog(logger)
+	gin.SetMode(gin.ReleaseMode)
+	return nil
+}
+
+// requestLogger returns the logger of the request, annotated with its ID.
+func requestLogger(c *gin.Context) *zap.Logger {
+	return logger.With(zap.String("requestId", c.GetString(requestIDContextKey)))
+}
+
+// logRequests returns middleware assigning each request an ID, forwarding it to WaaS
+// and logging the request once served. The ID is taken from the caller's X-Request-ID
+// header when valid, and returned in the response's.
+func logRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(requestIDHeader)
+		if len(requestID) > maxRequestIDLength || !validRequestID.MatchString(requestID) {
+			var err error
+			if requestID, err = newUUID(); err != nil {
+				abortWithCode(c, codes.Internal, fmt.Sprintf("cannot generate request ID: %v", err))
+				return
+			}
+		}
+		c.Set(requestIDContextKey, requestID)
+		c.Header(requestIDHeader, requestID)
+		c.Request = c.Request.WithContext(metadata.AppendToOutgoingContext(c.Request.Context(), requestIDMetadataKey, requestID))
+
+		debug := logger.Core().Enabled(zapcore.DebugLevel)
+		var requestBody []byte
+		var writer *captureWriter
+		if debug {
+			// The handlers read the rest of the body, and enforce its size limit.
+			body := c.Request.Body
+			requestBody, _ = io.ReadAll(io.LimitReader(body, maxPayloadCapture))
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(requestBody), body), body}
+			writer = &captureWriter{ResponseWriter: c.Writer, limit: maxPayloadCapture}
+			c.Writer = writer
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := []zap.Field{
+			zap.String("requestId", requestID),
+			zap.String("method", c.Request.Method),
+			zap.String("route", c.FullPath()),
+			zap.String("path", c.Request.URL.Path),
+			zap.String("query", c.Request.URL.RawQuery),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("clientIp", c.ClientIP()),
+		}
+		if size := c.Writer.Size(); size > 0 {
+			fields = append(fields, zap.Int("responseSize", size))
+		}
+		if rpc := c.GetString(rpcContextKey); rpc != "" {
+			fields = append(fields, zap.String("rpc", rpc))
+		}
+		if identity := identityFromContext(c); identity != nil {
+			fields = append(fields, zap.String("identity", identity.ID))
+		}
+		if spanContext := trace.SpanContextFromContext(c.Request.Context()); spanContext.IsValid() {
+			fields = append(fields, zap.String("traceId", spanContext.TraceID().String()))
+		}
+		if retries := c.Writer.Header().Get(upstreamRetriesHeader); retries != "" {
+			fields = append(fields, zap.String("upstreamRetries", retries))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+		if debug {
+			fields = append(fields, zap.String("requestBody", redactPayload(requestBody)), zap.String("responseBody", redactPayload(writer.body.Bytes())))
+		}
+
+		switch {
+		case status >= 500:
+			logger.Error("Request failed", fields...)
+		case status >= 400:
+			logger.Warn("Request rejected", fields...)
+		default:
+			logger.Info("Request served", fields...)
+		}
+	}
+}
+
+// recoverPanics returns middleware logging the panics of handlers and responding with
+// INTERNAL instead of dropping the connection.
+func recoverPanics() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered interface{}) {
+		requestLogger(c).Error("Panic serving request", zap.Any("panic", recovered), zap.Stack("stack"))
+		abortWithCode(c, codes.Internal, "internal error")
+	})
+}
+
+// redactPayload returns a JSON request or response body for logging, truncated and with
+// the values of secret JSON keys replaced. Other bodies are only described.
+func redactPayload(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("[%d bytes of non-JSON or truncated payload]", len(body))
+	}
+	body, err := json.Marshal(redactJSON(value))
+	if err != nil {
+		return fmt.Sprintf("[%d bytes of unencodable payload]", len(body))
+	}
+	if len(body) > maxLoggedPayload {
+		body = append(body[:maxLoggedPayload:maxLoggedPayload], "..."...)
+	}
+	return redactString(string(body))
+}
+
+// redactJSON replaces the values of the secret keys of a decoded JSON value.
+func redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if redactedKeys[strings.ToLower(strings.ReplaceAll(key, "_", ""))] {
+				v[key] = redacted
+			} else {
+				v[key] = redactJSON(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactJSON(item)
+		}
+	}
+	return value
+}
+
+// redactString replaces the secrets found in free text.
+func redactString(s string) string {
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllLiteralString(s, redacted)
+	}
+	return s
+}
+
+// redactingCore redacts the message and the string and error fields of every entry, so
+// that secrets never reach the logs whichever code logs them.
+type redactingCore struct {
+	zapcore.Core
+}
+
+func (c redactingCore) With(fields []zapcore.Field) zapcore.Core {
+	return redactingCore{c.Core.With(redactFields(fields))}
+}
+
+func (c redactingCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c redactingCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	entry.Message = redactString(entry.Message)
+	return c.Core.Write(entry, redactFields(fields))
+}
+
+// redactFields returns fields with the secrets in their string and error values replaced.
+func redactFields(fields []zapcore.Field) []zapcore.Field {
+	out := make([]zapcore.Field, len(fields))
+	for i, field := range fields {
+		switch field.Type {
+		case zapcore.StringType:
+			field.String = redactString(field.String)
+		case zapcore.ByteStringType:
+			field = zap.String(field.Key, redactString(string(field.Interface.([]byte))))
+		case zapcore.ErrorType:
+			if err, ok := field.Interface.(error); ok {
+				field = zap.String(field.Key, redactString(err.Error()))
+			}
+		}
+		out[i] = field
+	}
+	return out
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// metricsNamespace prefixes the names of all the proxy's metrics.
+	metricsNamespace = "waas_proxy"
+
+	// unmatchedRoute is the route label of requests matching no route.
+	unmatchedRoute = "unmatched"
+
+	// maxTrackedOperationAge is how long a pending operation nobody polls any more is
+	// counted as pending.
+	maxTrackedOperationAge = 24 * time.Hour
+
+	// Results of the long-running operations.
+	operationSucceeded = "succeeded"
+	operationFailed    = "failed"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route template, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests served, by route template and method.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"route", "method"})
+
+	httpRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+
+	upstreamCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_calls_total",
+		Help:      "WaaS calls, by service, RPC of the route making them and gRPC code.",
+	}, []string{"service", "method", "code"})
+
+	upstreamCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_call_duration_seconds",
+		Help:      "Latency of the WaaS calls including retries, by service and RPC of the route making them.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"service", "method"})
+
+	upstreamCallsInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "upstream_calls_in_flight",
+		Help:      "WaaS calls in progress, by service.",
+	}, []string{"service"})
+
+	operationsStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_started_total",
+		Help:      "Long-running operations started, by kind.",
+	}, []string{"kind"})
+
+	operationsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_completed_total",
+		Help:      "Long-running operations seen completing, by kind and result.",
+	}, []string{"kind", "result"})
+
+	operationsPending = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "operations_pending",
+		Help:      "Long-running operations last seen pending, by kind.",
+	}, []string{"kind"})
+)
+
+// instrument returns middleware counting and timing every request by route template.
+func instrument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		httpRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		httpRequestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// upstreamCode returns the gRPC code of the outcome of a WaaS call made with ctx. The
+// body of an error response is read to find out and replaced so that it can still be
+// read by the caller.
+func upstreamCode(ctx context.Context, resp *http.Response, err error) codes.Code {
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.Canceled):
+		return codes.Canceled
+	case err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case err != nil:
+		return codes.Unavailable
+	case resp.StatusCode < http.StatusBadRequest:
+		return codes.OK
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxRetryErrorBody))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return codeFromHTTPError(&googleapi.Error{Code: resp.StatusCode, Body: string(body)})
+}
+
+// trackedOperation is an operation last seen pending.
+type trackedOperation struct {
+	kind string
+	seen time.Time
+}
+
+// operationTracker follows the long-running operations returned by the proxy, so that
+// each one is counted once as it completes.
+type operationTracker struct {
+	mu        sync.Mutex
+	pending   map[string]trackedOperation
+	lastSweep time.Time
+}
+
+// operations tracks the operations of the running proxy.
+var operations = &operationTracker{pending: map[string]trackedOperation{}}
+
+// observe records the state of the named operation of the given kind, as returned by the
+// route that started it when started is set, or by GetOperation otherwise. Operations
+// completing without having been seen pending are only counted when they are started.
+func (t *operationTracker) observe(kind, name string, started, done, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastSweep) > time.Hour {
+		for name, op := range t.pending {
+			if now.Sub(op.seen) > maxTrackedOperationAge {
+				delete(t.pending, name)
+				operationsPending.WithLabelValues(op.kind).Dec()
+			}
+		}
+		t.lastSweep = now
+	}
+
+	if started {
+		operationsStarted.WithLabelValues(kind).Inc()
+	}
+
+	op, tracked := t.pending[name]
+	if !done {
+		if !tracked {
+			operationsPending.WithLabelValues(kind).Inc()
+		}
+		t.pending[name] = trackedOperation{kind: kind, seen: now}
+		return
+	}
+	if !tracked && !started {
+		return
+	}
+	if tracked {
+		delete(t.pending, name)
+		operationsPending.WithLabelValues(op.kind).Dec()
+	}
+
+	result := operationSucceeded
+	if failed {
+		result = operationFailed
+	}
+	operationsCompleted.WithLabelValues(kind, result).Inc()
+}
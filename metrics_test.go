@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/grpc/codes"
+)
+
+// metricValue returns the value of a counter or gauge.
+func metricValue(m prometheus.Metric) float64 {
+	var metric dto.Metric
+	if err := m.Write(&metric); err != nil {
+		panic(err)
+	}
+	if metric.Counter != nil {
+		return metric.Counter.GetValue()
+	}
+	return metric.Gauge.GetValue()
+}
+
+// counterDelta returns a function reporting how much m grew since counterDelta was called.
+func counterDelta(m prometheus.Metric) func() float64 {
+	before := metricValue(m)
+	return func() float64 { return metricValue(m) - before }
+}
+
+func TestInstrument(t *testing.T) {
+	router := gin.New()
+	router.Use(instrument())
+	router.GET("/metrics-test/pools/:poolId", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	found := counterDelta(httpRequests.WithLabelValues("/metrics-test/pools/:poolId", http.MethodGet, "200"))
+	unmatched := counterDelta(httpRequests.WithLabelValues(unmatchedRoute, http.MethodGet, "404"))
+	for _, path := range []string{"/metrics-test/pools/a", "/metrics-test/pools/b", "/metrics-test/unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Requests are labelled by route template, not path, to bound the label cardinality.
+	if found() != 2 || unmatched() != 1 {
+		t.Errorf("http_requests_total grew by %v for the route and %v unmatched, want 2 and 1", found(), unmatched())
+	}
+	if n := metricValue(httpRequestsInFlight); n != 0 {
+		t.Errorf("http_requests_in_flight = %v after the requests, want 0", n)
+	}
+}
+
+func TestUpstreamCode(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	response := func(status int, body string) *http.Response {
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}
+	}
+
+	for _, tt := range []struct {
+		name string
+		ctx  context.Context
+		resp *http.Response
+		err  error
+		want codes.Code
+	}{
+		{name: "ok", ctx: context.Background(), resp: response(http.StatusOK, `{}`), want: codes.OK},
+		{name: "error status", ctx: context.Background(), resp: response(http.StatusBadRequest, `{"error": {"status": "FAILED_PRECONDITION"}}`), want: codes.FailedPrecondition},
+		{name: "http status", ctx: context.Background(), resp: response(http.StatusNotFound, `not found`), want: codes.NotFound},
+		{name: "cancelled", ctx: cancelled, err: context.Canceled, want: codes.Canceled},
+		{name: "deadline", ctx: expired, err: context.DeadlineExceeded, want: codes.DeadlineExceeded},
+		{name: "connection refused", ctx: context.Background(), err: errors.New("connection refused"), want: codes.Unavailable},
+	} {
+		if got := upstreamCode(tt.ctx, tt.resp, tt.err); got != tt.want {
+			t.Errorf("upstreamCode(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+		// The error body is still readable by the client.
+		if tt.resp != nil && tt.resp.StatusCode >= http.StatusBadRequest {
+			if body, _ := io.ReadAll(tt.resp.Body); len(body) == 0 {
+				t.Errorf("upstreamCode(%s) consumed the response body", tt.name)
+			}
+		}
+	}
+}
+
+func TestUpstreamMetrics(t *testing.T) {
+	upstream := newTestUpstream(t, unavailable(""), upstreamOK)
+	ctx := withRPC(context.Background(), "MetricsTestGetPool")
+
+	calls := counterDelta(upstreamCalls.WithLabelValues(serviceLabel(poolService), "MetricsTestGetPool", "OK"))
+	retries := counterDelta(upstreamRetries.WithLabelValues(serviceLabel(poolService), "MetricsTestGetPool"))
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	resp, err := newTestTransport(t).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() = %v", err)
+	}
+	resp.Body.Close()
+
+	// A call is counted once with its final outcome, however many attempts it took.
+	if calls() != 1 || retries() != 1 {
+		t.Errorf("upstream_calls_total grew by %v and upstream_retries_total by %v, want 1 and 1", calls(), retries())
+	}
+	if n := metricValue(upstreamCallsInFlight.WithLabelValues(serviceLabel(poolService))); n != 0 {
+		t.Errorf("upstream_calls_in_flight = %v after the call, want 0", n)
+	}
+}
+
+func TestOperationTracker(t *testing.T) {
+	const kind = "MetricsTestCreateMPCWallet"
+	tracker := &operationTracker{pending: map[string]trackedOperation{}}
+
+	started := counterDelta(operationsStarted.WithLabelValues(kind))
+	pending := counterDelta(operationsPending.WithLabelValues(kind))
+	succeeded := counterDelta(operationsCompleted.WithLabelValues(kind, operationSucceeded))
+	failed := counterDelta(operationsCompleted.WithLabelValues(kind, operationFailed))
+
+	for _, tt := range []struct {
+		name                                              string
+		op                                                string
+		started, done, failed                             bool
+		wantStarted, wantPending, wantSucceed, wantFailed float64
+	}{
+		{name: "started pending", op: "operations/a", started: true, wantStarted: 1, wantPending: 1},
+		{name: "polled pending", op: "operations/a", wantStarted: 1, wantPending: 1},
+		{name: "polled done", op: "operations/a", done: true, wantStarted: 1, wantSucceed: 1},
+		{name: "polled done again", op: "operations/a", done: true, wantStarted: 1, wantSucceed: 1},
+		{name: "started done", op: "operations/b", started: true, done: true, failed: true, wantStarted: 2, wantSucceed: 1, wantFailed: 1},
+		{name: "polled done unseen", op: "operations/c", done: true, wantStarted: 2, wantSucceed: 1, wantFailed: 1},
+	} {
+		tracker.observe(kind, tt.op, tt.started, tt.done, tt.failed)
+		if started() != tt.wantStarted || pending() != tt.wantPending || succeeded() != tt.wantSucceed || failed() != tt.wantFailed {
+			t.Errorf("%s: started %v, pending %v, succeeded %v, failed %v, want %v, %v, %v, %v", tt.name,
+				started(), pending(), succeeded(), failed(), tt.wantStarted, tt.wantPending, tt.wantSucceed, tt.wantFailed)
+		}
+	}
+}
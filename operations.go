@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// Kinds of long-running operations, named after the RPC that starts them.
+	operationCreateDeviceGroup    = "CreateDeviceGroup"
+	operationCreateSignature      = "CreateSignature"
+	operationCreateMPCWallet      = "CreateMPCWallet"
+	operationCreateMPCTransaction = "CreateMPCTransaction"
+
+	// maxOperationWaitTimeout caps the timeout a caller may request with ?wait=true.
+	maxOperationWaitTimeout = 10 * time.Minute
+
+	// operationResponseMargin is the time reserved to respond after a wait times out.
+	operationResponseMargin = time.Second
+)
+
+// operationWaitTimeout is the default time to block on an operation with ?wait=true.
+var operationWaitTimeout = flag.Duration("operation-wait-timeout", time.Minute, "default time to block on a long-running operation with ?wait=true")
+
+// longRunningOperation is the surface shared by the wrapped long-running operations
+// returned by the v1clients, with R the resource produced and M the operation metadata.
+type longRunningOperation[R, M proto.Message] interface {
+	Name() string
+	Done() bool
+	Metadata() (M, error)
+	Poll(ctx context.Context, opts ...gax.CallOption) (R, error)
+	Wait(ctx context.Context, opts ...gax.CallOption) (R, error)
+}
+
+// operationResponse is the JSON handle returned for a long-running operation.
+type operationResponse struct {
+	Name     string          `json:"name"`
+	Kind     string          `json:"kind"`
+	Done     bool            `json:"done"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    *errorResponse  `json:"error,omitempty"`
+}
+
+// parseWait parses the ?wait and ?timeout query parameters.
+func parseWait(c *gin.Context) (bool, time.Duration, error) {
+	wait, err := strconv.ParseBool(c.DefaultQuery("wait", "false"))
+	if err != nil {
+		return false, 0, fmt.Errorf("cannot parse wait %q as bool: %v", c.Query("wait"), err)
+	}
+
+	timeout := *operationWaitTimeout
+	if value := c.Query("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil {
+			return false, 0, fmt.Errorf("cannot parse timeout %q as duration: %v", value, err)
+		}
+		if timeout <= 0 {
+			return false, 0, fmt.Errorf("timeout must be positive, got %q", value)
+		}
+	}
+	if timeout > maxOperationWaitTimeout {
+		timeout = maxOperationWaitTimeout
+	}
+
+	return wait, timeout, nil
+}
+
+// writeOperation responds with a long-running operation. With ?wait=true it blocks until
+// the operation finishes and writes the resulting resource; if the wait times out, or
+// without ?wait, it writes the operation handle, refreshing it first when poll is set.
+func writeOperation[R, M proto.Message](c *gin.Context, kind string, op longRunningOperation[R, M], poll bool) {
+	ctx := c.Request.Context()
+	// Only GetOperation refreshes the operation; the routes starting one do not.
+	started := !poll
+
+	wait, timeout, err := parseWait(c)
+	if err != nil {
+		writeBadRequest(c, err)
+		return
+	}
+
+	// Stop waiting early enough to still return the pending operation within the
+	// request deadline.
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline) - operationResponseMargin; remaining < timeout {
+			timeout = remaining
+		}
+	}
+
+	if wait && timeout > 0 {
+		waitCtx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		result, err := op.Wait(waitCtx)
+		if err == nil {
+			if !started && !authorizeOperation(c, op, result) {
+				return
+			}
+			operations.observe(kind, op.Name(), started, true, false)
+			writeProto(c, result)
+			return
+		}
+		if waitCtx.Err() == nil || ctx.Err() != nil {
+			if op.Done() {
+				var zero R
+				if !started && !authorizeOperation(c, op, zero) {
+					return
+				}
+				operations.observe(kind, op.Name(), started, true, true)
+			}
+			writeError(c, err)
+			return
+		}
+		// The wait timed out; fall back to returning the pending operation, which
+		// Wait has just refreshed.
+		poll = false
+	}
+
+	var result R
+	var opErr error
+	// Polling a finished operation does not contact the server; it only decodes the result.
+	if poll || op.Done() {
+		result, opErr = op.Poll(ctx)
+		if opErr != nil && !op.Done() {
+			writeError(c, opErr)
+			return
+		}
+	}
+
+	if !started && !authorizeOperation(c, op, result) {
+		return
+	}
+
+	response := &operationResponse{Name: op.Name(), Kind: kind, Done: op.Done()}
+
+	metadata, err := op.Metadata()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if metadata.ProtoReflect().IsValid() {
+		if response.Metadata, err = marshalOptions().Marshal(metadata); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+
+	switch {
+	case opErr != nil:
+		_, response.Error = translateError(opErr)
+	case response.Done:
+		if response.Response, err = marshalOptions().Marshal(result); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+
+	operations.observe(kind, op.Name(), started, response.Done, opErr != nil)
+
+	httpStatus := http.StatusOK
+	if !response.Done {
+		httpStatus = http.StatusAccepted
+	}
+	c.JSON(httpStatus, response)
+}
+
+// authorizeOperation writes a NOT_FOUND error and returns false if the caller may not act
+// on the resources of an operation refreshed by name, which carries no pool: its result,
+// and the device group or MPCTransaction of its metadata. Callers with restricted grants cannot see an
+// operation whose resources are not known.
+func authorizeOperation[R, M proto.Message](c *gin.Context, op longRunningOperation[R, M], result R) bool {
+	identity := identityFromContext(c)
+	if identity == nil || !identity.Restricted() {
+		return true
+	}
+
+	var names []string
+	if named, ok := proto.Message(result).(interface{ GetName() string }); ok && result.ProtoReflect().IsValid() && named.GetName() != "" {
+		names = append(names, named.GetName())
+	}
+	if metadata, err := op.Metadata(); err == nil && metadata.ProtoReflect().IsValid() {
+		if scoped, ok := proto.Message(metadata).(interface{ GetDeviceGroup() string }); ok && scoped.GetDeviceGroup() != "" {
+			names = append(names, scoped.GetDeviceGroup())
+		}
+		if scoped, ok := proto.Message(metadata).(interface{ GetMpcTransaction() string }); ok && scoped.GetMpcTransaction() != "" {
+			names = append(names, scoped.GetMpcTransaction())
+		}
+	}
+
+	authorized := len(names) > 0
+	for _, name := range names {
+		authorized = authorized && identity.CanAccessResource(name)
+	}
+	if !authorized {
+		abortWithCode(c, codes.NotFound, fmt.Sprintf("%s not found", op.Name()))
+	}
+	return authorized
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testOperation is a long-running operation producing a pool, finished once done is closed.
+type testOperation struct {
+	done chan struct{}
+	err  error
+}
+
+func (op *testOperation) Name() string { return "operations/test" }
+
+func (op *testOperation) Done() bool {
+	select {
+	case <-op.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (op *testOperation) Metadata() (*pools.Pool, error) {
+	return &pools.Pool{Name: "pools/metadata"}, nil
+}
+
+func (op *testOperation) Poll(ctx context.Context, opts ...gax.CallOption) (*pools.Pool, error) {
+	if !op.Done() {
+		return nil, nil
+	}
+	if op.err != nil {
+		return nil, op.err
+	}
+	return &pools.Pool{Name: "pools/result"}, nil
+}
+
+func (op *testOperation) Wait(ctx context.Context, opts ...gax.CallOption) (*pools.Pool, error) {
+	select {
+	case <-op.done:
+		return op.Poll(ctx)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// newTestOperation returns an operation, already finished with err if done is set.
+func newTestOperation(done bool, err error) *testOperation {
+	op := &testOperation{done: make(chan struct{}), err: err}
+	if done {
+		close(op.done)
+	}
+	return op
+}
+
+// serveOperation writes op as a response to a request for target, returning the
+// status and decoded handle.
+func serveOperation(t *testing.T, target string, op *testOperation, poll bool) (int, *operationResponse, string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	writeOperation[*pools.Pool, *pools.Pool](c, "CreatePool", op, poll)
+
+	var response operationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("writeOperation(%s) = %d %s: %v", target, w.Code, w.Body, err)
+	}
+	return w.Code, &response, w.Body.String()
+}
+
+func TestWriteOperation(t *testing.T) {
+	code, response, body := serveOperation(t, "/", newTestOperation(false, nil), false)
+	if code != http.StatusAccepted || response.Done || response.Metadata == nil || response.Response != nil {
+		t.Errorf("pending operation = %d %s, want 202 with the metadata", code, body)
+	}
+
+	code, response, body = serveOperation(t, "/", newTestOperation(true, nil), true)
+	if code != http.StatusOK || !response.Done || !strings.Contains(string(response.Response), "pools/result") {
+		t.Errorf("done operation = %d %s, want 200 with the response", code, body)
+	}
+
+	code, response, body = serveOperation(t, "/", newTestOperation(true, status.Error(codes.FailedPrecondition, "failed")), true)
+	if code != http.StatusOK || !response.Done || response.Error == nil || response.Error.Code != "FAILED_PRECONDITION" {
+		t.Errorf("failed operation = %d %s, want 200 with the error", code, body)
+	}
+}
+
+func TestWriteOperationWait(t *testing.T) {
+	op := newTestOperation(false, nil)
+	time.AfterFunc(10*time.Millisecond, func() { close(op.done) })
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/?wait=true", nil)
+	writeOperation[*pools.Pool, *pools.Pool](c, "CreatePool", op, false)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"pools/result"`) || strings.Contains(w.Body.String(), `"kind"`) {
+		t.Errorf("?wait=true = %d %s, want the resulting pool", w.Code, w.Body)
+	}
+
+	// A wait timing out returns the pending handle.
+	code, response, body := serveOperation(t, "/?wait=true&timeout=10ms", newTestOperation(false, nil), false)
+	if code != http.StatusAccepted || response.Done {
+		t.Errorf("?wait=true&timeout=10ms = %d %s, want the pending operation", code, body)
+	}
+}
+
+func TestParseWait(t *testing.T) {
+	for _, tt := range []struct {
+		query       string
+		wantWait    bool
+		wantTimeout time.Duration
+		wantErr     bool
+	}{
+		{query: "", wantTimeout: *operationWaitTimeout},
+		{query: "wait=true&timeout=30s", wantWait: true, wantTimeout: 30 * time.Second},
+		{query: "wait=1&timeout=1h", wantWait: true, wantTimeout: maxOperationWaitTimeout},
+		{query: "wait=maybe", wantErr: true},
+		{query: "timeout=soon", wantErr: true},
+		{query: "timeout=-1s", wantErr: true},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		wait, timeout, err := parseWait(c)
+		if (err != nil) != tt.wantErr || (err == nil && (wait != tt.wantWait || timeout != tt.wantTimeout)) {
+			t.Errorf("parseWait(%q) = %v, %v, %v, want %v, %v, error %v", tt.query, wait, timeout, err, tt.wantWait, tt.wantTimeout, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+)
+
+// listMaxItems caps the number of items returned by a List route, including with ?all=true.
+var listMaxItems = flag.Int("list-max-items", 1000, "maximum number of items returned by a List route, including with ?all=true")
+
+// parsePageParams parses the ?pageSize, ?pageToken and ?all query parameters of a List
+// route. Page sizes above listMaxItems are lowered to it.
+func parsePageParams(c *gin.Context) (int32, string, bool, error) {
+	pageSize, err := parseInt32(c.DefaultQuery("pageSize", "50"))
+	if err != nil {
+		return 0, "", false, err
+	}
+	if pageSize <= 0 {
+		return 0, "", false, errors.New("pageSize must be positive")
+	}
+	if int(pageSize) > *listMaxItems {
+		pageSize = int32(*listMaxItems)
+	}
+
+	all, err := strconv.ParseBool(c.DefaultQuery("all", "false"))
+	if err != nil {
+		return 0, "", false, fmt.Errorf("cannot parse %q as bool: %v", c.Query("all"), err)
+	}
+
+	return pageSize, c.Query("pageToken"), all, nil
+}
+
+// listPage returns a single page of up to pageSize items from iter, starting at pageToken,
+// along with the token of the following page. With all set it keeps fetching pages until
+// the iterator is exhausted or listMaxItems items have been collected, in which case the
+// returned token resumes after the last item. The last page is shortened to end at the
+// cap, as page tokens are opaque and cannot resume in the middle of a page.
+func listPage[T any](iter iterator.Pageable, pageSize int32, pageToken string, all bool) ([]T, string, error) {
+	items := []T{}
+	for {
+		size := int(pageSize)
+		if remaining := *listMaxItems - len(items); all && remaining < size {
+			size = remaining
+		}
+
+		// Pagers of the same iterator share its state, so each page may differ in size.
+		var err error
+		pageToken, err = iterator.NewPager(iter, size, pageToken).NextPage(&items)
+		if err != nil {
+			return nil, "", err
+		}
+		if !all || pageToken == "" || len(items) >= *listMaxItems {
+			return items, pageToken, nil
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+)
+
+// testIterator pages through items, using the offset of the next page as its token.
+type testIterator struct {
+	items    []string
+	buf      []string
+	fetches  int
+	pageInfo *iterator.PageInfo
+}
+
+func newTestIterator(n int) *testIterator {
+	it := &testIterator{}
+	for i := 0; i < n; i++ {
+		it.items = append(it.items, fmt.Sprintf("item-%d", i))
+	}
+	it.pageInfo, _ = iterator.NewPageInfo(it.fetch, func() int { return len(it.buf) }, func() interface{} {
+		buf := it.buf
+		it.buf = nil
+		return buf
+	})
+	return it
+}
+
+func (it *testIterator) fetch(pageSize int, pageToken string) (string, error) {
+	start := 0
+	if pageToken != "" {
+		var err error
+		if start, err = strconv.Atoi(pageToken); err != nil {
+			return "", err
+		}
+	}
+	end, next := start+pageSize, strconv.Itoa(start+pageSize)
+	if end >= len(it.items) {
+		end, next = len(it.items), ""
+	}
+	it.buf = append(it.buf, it.items[start:end]...)
+	it.fetches++
+	return next, nil
+}
+
+func (it *testIterator) PageInfo() *iterator.PageInfo { return it.pageInfo }
+
+func TestListPage(t *testing.T) {
+	defer func(max int) { *listMaxItems = max }(*listMaxItems)
+	*listMaxItems = 5
+
+	for _, tt := range []struct {
+		name          string
+		items         int
+		pageSize      int32
+		pageToken     string
+		all           bool
+		wantItems     int
+		wantFirst     string
+		wantNextToken string
+	}{
+		{name: "first page", items: 10, pageSize: 3, wantItems: 3, wantFirst: "item-0", wantNextToken: "3"},
+		{name: "next page", items: 10, pageSize: 3, pageToken: "3", wantItems: 3, wantFirst: "item-3", wantNextToken: "6"},
+		{name: "last page", items: 10, pageSize: 3, pageToken: "9", wantItems: 1, wantFirst: "item-9"},
+		{name: "all", items: 4, pageSize: 3, all: true, wantItems: 4, wantFirst: "item-0"},
+		{name: "all capped", items: 10, pageSize: 3, all: true, wantItems: 5, wantFirst: "item-0", wantNextToken: "5"},
+		{name: "all capped resumed", items: 10, pageSize: 3, pageToken: "5", all: true, wantItems: 5, wantFirst: "item-5"},
+	} {
+		items, nextPageToken, err := listPage[string](newTestIterator(tt.items), tt.pageSize, tt.pageToken, tt.all)
+		if err != nil {
+			t.Errorf("listPage(%s) = %v", tt.name, err)
+			continue
+		}
+		if len(items) != tt.wantItems || items[0] != tt.wantFirst || nextPageToken != tt.wantNextToken {
+			t.Errorf("listPage(%s) = %v, %q, want %d items from %s and token %q", tt.name, items, nextPageToken, tt.wantItems, tt.wantFirst, tt.wantNextToken)
+		}
+	}
+}
+
+func TestParsePageParams(t *testing.T) {
+	for _, tt := range []struct {
+		query        string
+		wantPageSize int32
+		wantToken    string
+		wantAll      bool
+		wantErr      bool
+	}{
+		{query: "", wantPageSize: 50},
+		{query: "pageSize=10&pageToken=abc&all=true", wantPageSize: 10, wantToken: "abc", wantAll: true},
+		{query: "pageSize=5000", wantPageSize: 1000},
+		{query: "pageSize=0", wantErr: true},
+		{query: "pageSize=-1", wantErr: true},
+		{query: "pageSize=ten", wantErr: true},
+		{query: "all=everything", wantErr: true},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		pageSize, pageToken, all, err := parsePageParams(c)
+		if (err != nil) != tt.wantErr || (err == nil && (pageSize != tt.wantPageSize || pageToken != tt.wantToken || all != tt.wantAll)) {
+			t.Errorf("parsePageParams(%q) = %d, %q, %v, %v", tt.query, pageSize, pageToken, all, err)
+		}
+	}
+}
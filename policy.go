@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	mpcWallet "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_wallets/v1"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// nativeAsset names the native coin of a network in policy rules; tokens are named by
+	// their contract address.
+	nativeAsset = "native"
+
+	// Values of the contractCalls policy constraint.
+	contractCallsAllow     = "allow"
+	contractCallsTransfers = "transfers"
+	contractCallsDeny      = "deny"
+
+	// policyViolationReason is the ErrorInfo reason of requests denied by the policy engine.
+	policyViolationReason = "POLICY_VIOLATION"
+)
+
+// erc20TransferSelector is the function selector of the ERC-20 transfer(address,uint256) method.
+var erc20TransferSelector = []byte{0xa9, 0x05, 0x9c, 0xbb}
+
+var (
+	// policyFile is the YAML file of transaction policy rules.
+	policyFile = flag.String("policy-file", "", "path to the YAML transaction policy file; enables the policy engine")
+
+	// policyReloadInterval is how often the policy file is checked for changes.
+	policyReloadInterval = flag.Duration("policy-reload-interval", 10*time.Second, "how often to check the policy file for changes")
+)
+
+// policyConfig is the policy file.
+type policyConfig struct {
+	Rules []*policyRule `yaml:"rules"`
+}
+
+// policyRule is a named set of constraints applied to the requests in its scope. Every
+// rule in scope must pass for a request to be forwarded.
+type policyRule struct {
+	Name string `yaml:"name"`
+
+	// Pools and MPCWallets are glob patterns scoping the rule. A nil list matches everything.
+	Pools      []string `yaml:"pools"`
+	MPCWallets []string `yaml:"mpcWallets"`
+
+	// Networks are glob patterns of the network IDs transactions may be sent on.
+	Networks []string `yaml:"networks"`
+
+	// Assets are the assets that may be transferred: "native" or token contract addresses.
+	Assets []string `yaml:"assets"`
+
+	// MaxAmounts caps the amount transferred per transaction, in base units, by asset.
+	MaxAmounts map[string]string `yaml:"maxAmounts"`
+
+	// MaxFee caps gas times maxFeePerGas, in base units of the native asset.
+	MaxFee string `yaml:"maxFee"`
+
+	// Recipients restricts the addresses receiving the transferred asset.
+	Recipients struct {
+		Allow []string `yaml:"allow"`
+		Deny  []string `yaml:"deny"`
+	} `yaml:"recipients"`
+
+	// ContractCalls is "allow", "transfers" to allow ERC-20 transfers only, or "deny".
+	ContractCalls string `yaml:"contractCalls"`
+
+	// Contracts are the contract addresses that may be called. A nil list allows any.
+	Contracts []string `yaml:"contracts"`
+
+	// DenySignatures rejects CreateSignature requests in the rule's scope.
+	DenySignatures bool `yaml:"denySignatures"`
+
+	maxAmounts map[string]*big.Int
+	maxFee     *big.Int
+}
+
+// policyViolation identifies the rule and constraint that denied a request.
+type policyViolation struct {
+	Rule       string
+	Constraint string
+	Message    string
+}
+
+func (v *policyViolation) Error() string {
+	return v.Message
+}
+
+// transactionFacts are the properties of a CreateMPCTransaction request that rules are
+// evaluated against.
+type transactionFacts struct {
+	network string
+
+	// inspectable is false for raw RLP inputs, whose contents are opaque to the proxy.
+	inspectable bool
+
+	asset        string
+	amount       *big.Int
+	recipient    string
+	fee          *big.Int
+	contractCall bool
+	transfer     bool
+	contract     string
+}
+
+// policyEngine evaluates the rules of the policy file, reloading it when it changes.
+type policyEngine struct {
+	path string
+
+	mu      sync.RWMutex
+	rules   []*policyRule
+	modTime time.Time
+}
+
+// newPolicyEngine loads the policy file at path and reloads it every interval until
+// ctx is done.
+func newPolicyEngine(ctx context.Context, path string, interval time.Duration) (*policyEngine, error) {
+	engine := &policyEngine{path: path}
+	if _, err := engine.reload(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go engine.watch(ctx, interval)
+	}
+	return engine, nil
+}
+
+// reload loads the policy file again if it changed since the last load, and reports
+// whether it did. The current rules are kept if the new file is invalid.
+func (p *policyEngine) reload() (bool, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return false, fmt.Errorf("cannot stat policy file: %v", err)
+	}
+
+	p.mu.RLock()
+	unchanged := p.rules != nil && info.ModTime().Equal(p.modTime)
+	p.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	rules, err := loadPolicyRules(p.path)
+	if err != nil {
+		return false, err
+	}
+
+	p.mu.Lock()
+	p.rules = rules
+	p.modTime = info.ModTime()
+	p.mu.Unlock()
+	return true, nil
+}
+
+// watch reloads the policy file every interval until ctx is done.
+func (p *policyEngine) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := p.reload()
+			if err != nil {
+				logger.Error("Error reloading policy file, keeping the current rules", zap.Error(err))
+			} else if reloaded {
+				logger.Info("Reloaded policy file", zap.String("file", p.path))
+			}
+		}
+	}
+}
+
+// loadPolicyRules reads and validates the policy file at path.
+func loadPolicyRules(path string) ([]*policyRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy file: %v", err)
+	}
+
+	var config policyConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("cannot parse policy file %q: %v", path, err)
+	}
+
+	names := map[string]bool{}
+	rules := []*policyRule{}
+	for _, rule := range config.Rules {
+		if rule.Name == "" {
+			return nil, errors.New("policy rule with empty name")
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate policy rule %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("policy rule %q: %v", rule.Name, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// compile validates the rule and parses its amounts. Addresses are compared case-insensitively.
+func (r *policyRule) compile() error {
+	for field, grant := range map[string][]string{"pools": r.Pools, "mpcWallets": r.MPCWallets, "networks": r.Networks} {
+		if err := validateGrant(grant); err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+	}
+
+	switch r.ContractCalls {
+	case "":
+		r.ContractCalls = contractCallsAllow
+	case contractCallsAllow, contractCallsTransfers, contractCallsDeny:
+	default:
+		return fmt.Errorf("unknown contractCalls %q, want allow, transfers or deny", r.ContractCalls)
+	}
+
+	r.Assets = lowerAll(r.Assets)
+	r.Contracts = lowerAll(r.Contracts)
+	r.Recipients.Allow = lowerAll(r.Recipients.Allow)
+	r.Recipients.Deny = lowerAll(r.Recipients.Deny)
+
+	r.maxAmounts = map[string]*big.Int{}
+	for asset, amount := range r.MaxAmounts {
+		limit, err := parseAmount(amount)
+		if err != nil {
+			return fmt.Errorf("maxAmounts[%s]: %v", asset, err)
+		}
+		r.maxAmounts[strings.ToLower(asset)] = limit
+	}
+
+	if r.MaxFee != "" {
+		limit, err := parseAmount(r.MaxFee)
+		if err != nil {
+			return fmt.Errorf("maxFee: %v", err)
+		}
+		r.maxFee = limit
+	}
+	return nil
+}
+
+// inspectsContents reports whether the rule constrains the contents of a transaction
+// rather than only where it is sent.
+func (r *policyRule) inspectsContents() bool {
+	return r.Assets != nil || len(r.maxAmounts) > 0 || r.maxFee != nil || r.Recipients.Allow != nil ||
+		r.Recipients.Deny != nil || r.ContractCalls != contractCallsAllow || r.Contracts != nil
+}
+
+// checkTransaction returns the first violation of the rule by the transaction, or nil.
+func (r *policyRule) checkTransaction(tx *transactionFacts) *policyViolation {
+	deny := func(constraint, format string, args ...interface{}) *policyViolation {
+		return &policyViolation{Rule: r.Name, Constraint: constraint, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if r.Networks != nil && !grantAllows(r.Networks, tx.network) {
+		return deny("networks", "network %q is not allowed", tx.network)
+	}
+	if !r.inspectsContents() {
+		return nil
+	}
+	if !tx.inspectable {
+		return deny("input", "raw transaction inputs cannot be inspected")
+	}
+
+	if tx.contractCall && r.ContractCalls != contractCallsAllow {
+		return deny("contractCalls", "contract calls are not allowed")
+	}
+	if tx.transfer && r.ContractCalls == contractCallsDeny {
+		return deny("contractCalls", "token transfers are not allowed")
+	}
+	if (tx.contractCall || tx.transfer) && r.Contracts != nil && !contains(r.Contracts, tx.contract) {
+		return deny("contracts", "contract %s is not allowed", tx.contract)
+	}
+	if r.Assets != nil && !contains(r.Assets, tx.asset) {
+		return deny("assets", "asset %s is not allowed", tx.asset)
+	}
+	if limit, ok := r.maxAmounts[tx.asset]; ok && tx.amount.Cmp(limit) > 0 {
+		return deny("maxAmounts", "amount %s of %s exceeds the limit of %s", tx.amount, tx.asset, limit)
+	}
+	if contains(r.Recipients.Deny, tx.recipient) {
+		return deny("recipients", "recipient %s is denied", tx.recipient)
+	}
+	if r.Recipients.Allow != nil && !contains(r.Recipients.Allow, tx.recipient) {
+		return deny("recipients", "recipient %s is not allowed", tx.recipient)
+	}
+	if r.maxFee != nil && tx.fee.Cmp(r.maxFee) > 0 {
+		return deny("maxFee", "maximum fee %s exceeds the limit of %s", tx.fee, r.maxFee)
+	}
+	return nil
+}
+
+// checkTransaction evaluates the rules in scope of a CreateMPCTransaction request. A
+// request whose input cannot be decoded is denied by the rules in scope constraining the
+// network or the contents of transactions.
+func (p *policyEngine) checkTransaction(req *mpcTransactions.CreateMPCTransactionRequest) *policyViolation {
+	if p == nil {
+		return nil
+	}
+
+	pool, mpcWallet := transactionScope(req)
+	tx, err := transactionFactsFrom(req)
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, rule := range p.rules {
+		if !grantAllows(rule.Pools, pool) || !grantAllows(rule.MPCWallets, mpcWallet) {
+			continue
+		}
+		if err != nil {
+			if rule.Networks != nil || rule.inspectsContents() {
+				return &policyViolation{Rule: rule.Name, Constraint: "input", Message: err.Error()}
+			}
+			continue
+		}
+		if violation := rule.checkTransaction(tx); violation != nil {
+			return violation
+		}
+	}
+	return nil
+}
+
+// checkSignature returns the violation of the rule by a signature, or nil. Signature
+// payloads are opaque to the proxy, like raw transaction inputs, so they are denied by
+// any rule constraining the network or the contents of transactions.
+func (r *policyRule) checkSignature() *policyViolation {
+	switch {
+	case r.DenySignatures:
+		return &policyViolation{Rule: r.Name, Constraint: "denySignatures", Message: "signatures are not allowed"}
+	case r.Networks != nil:
+		return &policyViolation{Rule: r.Name, Constraint: "networks", Message: "the network of signature payloads cannot be inspected"}
+	case r.inspectsContents():
+		return &policyViolation{Rule: r.Name, Constraint: "input", Message: "signature payloads cannot be inspected"}
+	}
+	return nil
+}
+
+// checkSignature evaluates the rules in scope of a CreateSignature request for the MPCKey
+// named parent. Rules scoped to MPCWallets apply if they match one of the wallets of the
+// key's device group, which are listed with wallets only when such a rule could deny the
+// signature.
+func (p *policyEngine) checkSignature(ctx context.Context, wallets mpcWalletAPI, parent string) (*policyViolation, error) {
+	if p == nil {
+		return nil, nil
+	}
+
+	pool, deviceGroup := "", ""
+	if segments := strings.Split(parent, "/"); len(segments) >= 4 {
+		pool = segments[1]
+		deviceGroup = strings.Join(segments[:4], "/")
+	}
+
+	// Reloads replace the rules rather than modify them, so they can be read unlocked
+	// while the wallets are listed.
+	p.mu.RLock()
+	rules := p.rules
+	p.mu.RUnlock()
+
+	var walletIDs []string
+	resolved := false
+	for _, rule := range rules {
+		if !grantAllows(rule.Pools, pool) {
+			continue
+		}
+		violation := rule.checkSignature()
+		if violation == nil {
+			continue
+		}
+		if rule.MPCWallets == nil {
+			return violation, nil
+		}
+
+		if !resolved {
+			var err error
+			if walletIDs, err = deviceGroupWallets(ctx, wallets, pool, deviceGroup); err != nil {
+				return nil, err
+			}
+			resolved = true
+		}
+		for _, walletID := range walletIDs {
+			if grantAllows(rule.MPCWallets, walletID) {
+				return violation, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// deviceGroupWallets returns the IDs of the MPCWallets of the pool whose keys are held by
+// the named device group.
+func deviceGroupWallets(ctx context.Context, wallets mpcWalletAPI, pool, deviceGroup string) ([]string, error) {
+	var walletIDs []string
+	iter := wallets.ListMPCWallets(ctx, &mpcWallet.ListMPCWalletsRequest{Parent: "pools/" + pool})
+	for {
+		wallet, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			return walletIDs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if wallet.GetDeviceGroup() == deviceGroup {
+			walletIDs = append(walletIDs, wallet.GetName()[strings.LastIndex(wallet.GetName(), "/")+1:])
+		}
+	}
+}
+
+// transactionScope returns the IDs of the Pool and MPCWallet a CreateMPCTransaction
+// request is sent from.
+func transactionScope(req *mpcTransactions.CreateMPCTransactionRequest) (pool, mpcWallet string) {
+	if segments := strings.Split(req.GetParent(), "/"); len(segments) >= 4 {
+		return segments[1], segments[3]
+	}
+	return "", ""
+}
+
+// transactionFactsFrom extracts the facts of a CreateMPCTransaction request. Ethereum
+// EIP-1559 inputs are decoded, including ERC-20 transfer calls; raw RLP inputs are not.
+func transactionFactsFrom(req *mpcTransactions.CreateMPCTransactionRequest) (*transactionFacts, error) {
+	tx := &transactionFacts{amount: new(big.Int), fee: new(big.Int)}
+	tx.network = strings.TrimPrefix(req.GetMpcTransaction().GetNetwork(), "networks/")
+
+	// Which of the two inputs WaaS executes is not documented, so the request is only
+	// evaluated if they agree.
+	input := req.GetInput()
+	if embedded := req.GetMpcTransaction().GetTransaction().GetInput(); input == nil {
+		input = embedded
+	} else if embedded != nil && !proto.Equal(input, embedded) {
+		return nil, errors.New("input and mpcTransaction.transaction.input differ")
+	}
+	eip1559 := input.GetEthereum_1559Input()
+	if eip1559 == nil {
+		return tx, nil
+	}
+	tx.inspectable = true
+
+	value, err := parseAmount(eip1559.GetValue())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse transaction value: %v", err)
+	}
+	maxFeePerGas, err := parseAmount(eip1559.GetMaxFeePerGas())
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse maxFeePerGas: %v", err)
+	}
+	tx.fee.Mul(maxFeePerGas, new(big.Int).SetUint64(eip1559.GetGas()))
+
+	to := strings.ToLower(eip1559.GetToAddress())
+	data := eip1559.GetData()
+	switch {
+	case len(data) == 0:
+		tx.asset = nativeAsset
+		tx.amount = value
+		tx.recipient = to
+	case len(data) == 68 && bytes.HasPrefix(data, erc20TransferSelector) && value.Sign() == 0:
+		tx.transfer = true
+		tx.contract = to
+		tx.asset = to
+		tx.recipient = "0x" + hex.EncodeToString(data[16:36])
+		tx.amount = new(big.Int).SetBytes(data[36:68])
+	default:
+		tx.contractCall = true
+		tx.contract = to
+		tx.asset = nativeAsset
+		tx.amount = value
+		tx.recipient = to
+	}
+	return tx, nil
+}
+
+// enforcePolicy writes a PERMISSION_DENIED error naming the rule that fired and returns
+// false if violation is set.
+func enforcePolicy(c *gin.Context, violation *policyViolation) bool {
+	if violation == nil {
+		return true
+	}
+
+	message := "request denied by policy: " + violation.Message
+	if violation.Rule != "" {
+		message = fmt.Sprintf("request denied by policy rule %q: %s", violation.Rule, violation.Message)
+	}
+	requestLogger(c).Info("Policy denied request", zap.String("rule", violation.Rule), zap.String("constraint", violation.Constraint), zap.String("reason", violation.Message))
+
+	c.AbortWithStatusJSON(http.StatusForbidden, &errorResponse{
+		Code:    codeName(codes.PermissionDenied),
+		Message: message,
+		Details: []errorDetail{{
+			Type:     "errorInfo",
+			Reason:   policyViolationReason,
+			Domain:   errorDomain,
+			Metadata: map[string]string{"rule": violation.Rule, "constraint": violation.Constraint},
+		}},
+	})
+	return false
+}
+
+// parseAmount parses a non-negative integer amount in decimal or 0x-prefixed hex. An
+// empty amount is zero.
+func parseAmount(s string) (*big.Int, error) {
+	if s == "" {
+		return new(big.Int), nil
+	}
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s, base = s[2:], 16
+	}
+	amount, ok := new(big.Int).SetString(s, base)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return amount, nil
+}
+
+// lowerAll lowercases values, keeping a nil slice nil.
+func lowerAll(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	lowered := make([]string, len(values))
+	for i, v := range values {
+		lowered[i] = strings.ToLower(v)
+	}
+	return lowered
+}
+
+// contains reports whether values contains v.
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
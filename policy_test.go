@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	ethereum "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/ethereum/v1"
+	v1types "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/types/v1"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testRecipient = "0x1111111111111111111111111111111111111111"
+	testToken     = "0x2222222222222222222222222222222222222222"
+)
+
+// newTestPolicyEngine returns an engine evaluating the rules of the given policy file.
+func newTestPolicyEngine(t *testing.T, policy string) *policyEngine {
+	t.Helper()
+
+	engine, err := newPolicyEngine(context.Background(), writeTestFile(t, t.TempDir(), "policy.yaml", policy), 0)
+	if err != nil {
+		t.Fatalf("newPolicyEngine() = %v", err)
+	}
+	return engine
+}
+
+// newTransactionRequest returns a request for an EIP-1559 transaction from wallet on
+// network, with a fee of 21000 times maxFeePerGas.
+func newTransactionRequest(wallet, network, to, value, maxFeePerGas string, data []byte) *mpcTransactions.CreateMPCTransactionRequest {
+	return &mpcTransactions.CreateMPCTransactionRequest{
+		Parent:         wallet,
+		MpcTransaction: &mpcTransactions.MPCTransaction{Network: "networks/" + network},
+		Input: &v1types.TransactionInput{Input: &v1types.TransactionInput_Ethereum_1559Input{
+			Ethereum_1559Input: &ethereum.EIP1559TransactionInput{ToAddress: to, Value: value, MaxFeePerGas: maxFeePerGas, Gas: 21000, Data: data},
+		}},
+	}
+}
+
+// transferData returns the calldata of an ERC-20 transfer of amount to recipient.
+func transferData(recipient string, amount byte) []byte {
+	address, _ := hex.DecodeString(strings.TrimPrefix(recipient, "0x"))
+	data := append([]byte{}, erc20TransferSelector...)
+	data = append(data, make([]byte, 12)...)
+	data = append(data, address...)
+	data = append(data, make([]byte, 31)...)
+	return append(data, amount)
+}
+
+func TestPolicyCheckTransaction(t *testing.T) {
+	engine := newTestPolicyEngine(t, `
+rules:
+  - name: testnets-only
+    networks: ["ethereum-goerli"]
+  - name: treasury-limits
+    pools: ["treasury"]
+    assets: ["native", "`+testToken+`"]
+    maxAmounts:
+      native: "1000"
+      `+testToken+`: "0x10"
+    maxFee: "21000000"
+    recipients:
+      deny: ["0x000000000000000000000000000000000000dead"]
+    contractCalls: transfers
+  - name: hot-wallets
+    pools: ["treasury"]
+    mpcWallets: ["hot-*"]
+    recipients:
+      allow: ["`+testRecipient+`"]
+`)
+
+	const treasury = "pools/treasury/mpcWallets/cold"
+	for _, tt := range []struct {
+		name           string
+		req            *mpcTransactions.CreateMPCTransactionRequest
+		wantRule       string
+		wantConstraint string
+	}{
+		{name: "allowed", req: newTransactionRequest(treasury, "ethereum-goerli", testRecipient, "1000", "1000", nil)},
+		{name: "other pool", req: newTransactionRequest("pools/other/mpcWallets/w", "ethereum-goerli", testRecipient, "1000000", "1", []byte{1, 2, 3})},
+		{name: "network", req: newTransactionRequest(treasury, "ethereum-mainnet", testRecipient, "1", "1", nil), wantRule: "testnets-only", wantConstraint: "networks"},
+		{name: "native amount", req: newTransactionRequest(treasury, "ethereum-goerli", testRecipient, "1001", "1", nil), wantRule: "treasury-limits", wantConstraint: "maxAmounts"},
+		{name: "hex amount", req: newTransactionRequest(treasury, "ethereum-goerli", testRecipient, "0x3e9", "1", nil), wantRule: "treasury-limits", wantConstraint: "maxAmounts"},
+		{name: "fee", req: newTransactionRequest(treasury, "ethereum-goerli", testRecipient, "1", "1001", nil), wantRule: "treasury-limits", wantConstraint: "maxFee"},
+		{name: "denied recipient", req: newTransactionRequest(treasury, "ethereum-goerli", "0x000000000000000000000000000000000000DEAD", "1", "1", nil), wantRule: "treasury-limits", wantConstraint: "recipients"},
+		{name: "token transfer", req: newTransactionRequest(treasury, "ethereum-goerli", testToken, "0", "1", transferData(testRecipient, 0x10))},
+		{name: "token amount", req: newTransactionRequest(treasury, "ethereum-goerli", testToken, "0", "1", transferData(testRecipient, 0x11)), wantRule: "treasury-limits", wantConstraint: "maxAmounts"},
+		{name: "other token", req: newTransactionRequest(treasury, "ethereum-goerli", testRecipient, "0", "1", transferData(testRecipient, 1)), wantRule: "treasury-limits", wantConstraint: "assets"},
+		{name: "contract call", req: newTransactionRequest(treasury, "ethereum-goerli", testToken, "0", "1", []byte{1, 2, 3, 4}), wantRule: "treasury-limits", wantConstraint: "contractCalls"},
+		{name: "wallet recipient", req: newTransactionRequest("pools/treasury/mpcWallets/hot-1", "ethereum-goerli", "0x3333333333333333333333333333333333333333", "1", "1", nil), wantRule: "hot-wallets", wantConstraint: "recipients"},
+		{name: "raw input", req: &mpcTransactions.CreateMPCTransactionRequest{
+			Parent:         treasury,
+			MpcTransaction: &mpcTransactions.MPCTransaction{Network: "networks/ethereum-goerli"},
+			Input:          &v1types.TransactionInput{Input: &v1types.TransactionInput_EthereumRlpInput{EthereumRlpInput: &ethereum.RLPTransaction{}}},
+		}, wantRule: "treasury-limits", wantConstraint: "input"},
+		{name: "malformed value", req: newTransactionRequest(treasury, "ethereum-goerli", testRecipient, "lots", "1", nil), wantRule: "testnets-only", wantConstraint: "input"},
+	} {
+		violation := engine.checkTransaction(tt.req)
+		if tt.wantConstraint == "" {
+			if violation != nil {
+				t.Errorf("%s: checkTransaction() = %+v, want allowed", tt.name, violation)
+			}
+			continue
+		}
+		if violation == nil || violation.Rule != tt.wantRule || violation.Constraint != tt.wantConstraint {
+			t.Errorf("%s: checkTransaction() = %+v, want rule %q constraint %q", tt.name, violation, tt.wantRule, tt.wantConstraint)
+		}
+	}
+
+	var disabled *policyEngine
+	if violation := disabled.checkTransaction(newTransactionRequest(treasury, "ethereum-mainnet", testRecipient, "lots", "1", nil)); violation != nil {
+		t.Errorf("checkTransaction() without a policy file = %+v, want allowed", violation)
+	}
+}
+
+func TestPolicyCheckUndecodableTransaction(t *testing.T) {
+	engine := newTestPolicyEngine(t, `
+rules:
+  - name: no-signatures
+    denySignatures: true
+  - name: treasury-limits
+    pools: ["treasury"]
+    maxAmounts:
+      native: "1000"
+`)
+
+	// Only the rules in scope constraining transactions deny an input that cannot be decoded.
+	malformed := func(parent string) *mpcTransactions.CreateMPCTransactionRequest {
+		return newTransactionRequest(parent, "ethereum-goerli", testRecipient, "lots", "1", nil)
+	}
+	if violation := engine.checkTransaction(malformed("pools/other/mpcWallets/w")); violation != nil {
+		t.Errorf("checkTransaction() of a malformed value out of scope = %+v, want allowed", violation)
+	}
+	if violation := engine.checkTransaction(malformed("pools/treasury/mpcWallets/w")); violation == nil || violation.Rule != "treasury-limits" || violation.Constraint != "input" {
+		t.Errorf("checkTransaction() of a malformed value in scope = %+v, want rule treasury-limits constraint input", violation)
+	}
+}
+
+func TestPolicyCheckSignature(t *testing.T) {
+	engine := newTestPolicyEngine(t, `
+rules:
+  - name: no-signatures
+    pools: ["treasury"]
+    denySignatures: true
+  - name: wallet-scoped
+    mpcWallets: ["*"]
+    denySignatures: true
+`)
+
+	// Wallet-scoped rules apply to the keys of their wallets' device groups.
+	wallets := &newSeededWaaS().mpcWallet
+	for _, tt := range []struct {
+		parent   string
+		wantRule string
+	}{
+		{parent: "pools/treasury/deviceGroups/g/mpcKeys/k", wantRule: "no-signatures"},
+		{parent: "pools/pool-1/deviceGroups/group-1/mpcKeys/key-1", wantRule: "wallet-scoped"},
+		{parent: "pools/pool-1/deviceGroups/group-2/mpcKeys/key-1"},
+		{parent: "pools/other/deviceGroups/g/mpcKeys/k"},
+	} {
+		violation, err := engine.checkSignature(context.Background(), wallets, tt.parent)
+		if err != nil || (violation == nil) != (tt.wantRule == "") || (violation != nil && violation.Rule != tt.wantRule) {
+			t.Errorf("checkSignature(%s) = %+v, %v, want rule %q", tt.parent, violation, err, tt.wantRule)
+		}
+	}
+
+	wallets.err = status.Error(codes.Unavailable, "unavailable")
+	if _, err := engine.checkSignature(context.Background(), wallets, "pools/pool-1/deviceGroups/group-1/mpcKeys/key-1"); err == nil {
+		t.Error("checkSignature() with the wallets unavailable succeeded, want an error")
+	}
+}
+
+func TestLoadPolicyRulesErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, contents := range map[string]string{
+		"unnamed.yaml":   "rules:\n  - networks: [a]\n",
+		"duplicate.yaml": "rules:\n  - name: a\n  - name: a\n",
+		"unknown.yaml":   "rules:\n  - name: a\n    maxAmount: 1\n",
+		"calls.yaml":     "rules:\n  - name: a\n    contractCalls: sometimes\n",
+		"amount.yaml":    "rules:\n  - name: a\n    maxAmounts:\n      native: \"-1\"\n",
+		"fee.yaml":       "rules:\n  - name: a\n    maxFee: lots\n",
+		"pattern.yaml":   "rules:\n  - name: a\n    pools: [\"[treasury\"]\n",
+	} {
+		if _, err := loadPolicyRules(writeTestFile(t, dir, name, contents)); err == nil {
+			t.Errorf("loadPolicyRules(%s) succeeded, want an error", name)
+		}
+	}
+
+	if rules, err := loadPolicyRules(writeTestFile(t, dir, "empty.yaml", "")); err != nil || len(rules) != 0 {
+		t.Errorf("loadPolicyRules(empty.yaml) = %v, %v, want no rules", rules, err)
+	}
+}
+
+func TestPolicyReload(t *testing.T) {
+	engine := newTestPolicyEngine(t, "rules:\n  - name: mainnet\n    networks: [ethereum-mainnet]\n")
+	req := newTransactionRequest("pools/p/mpcWallets/w", "ethereum-goerli", testRecipient, "1", "1", nil)
+	if engine.checkTransaction(req) == nil {
+		t.Fatal("checkTransaction() = nil, want a networks violation")
+	}
+
+	touch := func(contents string, modTime time.Time) {
+		writeTestFile(t, filepath.Dir(engine.path), filepath.Base(engine.path), contents)
+		if err := os.Chtimes(engine.path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	touch("rules:\n  - name: goerli\n    networks: [ethereum-goerli]\n", time.Now().Add(time.Minute))
+	if reloaded, err := engine.reload(); !reloaded || err != nil {
+		t.Fatalf("reload() = %v, %v, want a reload", reloaded, err)
+	}
+	if violation := engine.checkTransaction(req); violation != nil {
+		t.Errorf("checkTransaction() after a reload = %+v, want allowed", violation)
+	}
+
+	// An invalid file is rejected and the current rules kept.
+	touch("rules: [", time.Now().Add(2*time.Minute))
+	if _, err := engine.reload(); err == nil {
+		t.Error("reload() of an invalid file succeeded, want an error")
+	}
+	if violation := engine.checkTransaction(req); violation != nil {
+		t.Errorf("checkTransaction() after a failed reload = %+v, want the previous rules", violation)
+	}
+}
+
+func TestEnforcePolicy(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+	if !enforcePolicy(c, nil) {
+		t.Fatal("enforcePolicy(nil) = false, want true")
+	}
+	if enforcePolicy(c, &policyViolation{Rule: "treasury-limits", Constraint: "maxAmounts", Message: "too much"}) {
+		t.Fatal("enforcePolicy(violation) = true, want false")
+	}
+
+	var response errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusForbidden || response.Code != "PERMISSION_DENIED" || len(response.Details) != 1 ||
+		response.Details[0].Reason != policyViolationReason || response.Details[0].Metadata["rule"] != "treasury-limits" ||
+		response.Details[0].Metadata["constraint"] != "maxAmounts" {
+		t.Errorf("enforcePolicy() wrote %d %s, want 403 naming the rule and constraint", w.Code, w.Body)
+	}
+}
@@ -2,15 +2,16 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
 
 	"github.com/coinbase/waas-client-library-go/auth"
-	"github.com/coinbase/waas-client-library-go/clients"
-	v1clients "github.com/coinbase/waas-client-library-go/clients/v1"
 	blockchain "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/blockchain/v1"
 	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
 	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
@@ -19,17 +20,23 @@ import (
 	protocols "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/v1"
 	v1types "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/types/v1"
 	"github.com/gin-gonic/gin"
-	"google.golang.org/api/iterator"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 )
 
 const (
 	version = "v1"
+)
+
+var (
+	// credentialsSource selects where the WaaS API Key is loaded from: env, file or secrets.
+	credentialsSource = flag.String("credentials", "env", "where to load the WaaS API key from: env, file or secrets")
 
-	// apiKeyName is the name of the API Key to use. Fill this out before running the main function.
-	apiKeyName = "<YOUR_API_KEY_NAME>"
+	// credentialsFile is the path of the JSON key file downloaded from the WaaS console.
+	credentialsFile = flag.String("credentials-file", "", "path to the WaaS API key JSON file (with -credentials=file)")
 
-	// apiKeyPrivateKey is the private key of the API Key to use. Fill this out before running the main function.
-	apiKeyPrivateKey = "<YOUR_PRIVATE_KEY>"
+	// secretsDir is the directory of mounted secrets holding the API Key.
+	secretsDir = flag.String("secrets-dir", "", "directory of mounted secrets holding the WaaS API key (with -credentials=secrets)")
 )
 
 func parseInt32(str string) (int32, error) {
@@ -42,858 +49,945 @@ func parseInt32(str string) (int32, error) {
 
 // An example function to demonstrate how to use the WaaS client libraries.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAuditCommand(os.Args[2:]))
+	}
+
+	flag.Parse()
 
 	ctx := context.Background()
 
-	authOpt := clients.WithAPIKey(&auth.APIKey{
-		Name:       apiKeyName,
-		PrivateKey: apiKeyPrivateKey,
-	})
+	if err := setupLogging(); err != nil {
+		log.Fatalf("Error configuring logging: %v", err)
+	}
+	defer logger.Sync()
+
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		logger.Fatal("Error configuring tracing", zap.Error(err))
+	}
 
-	// Create BlockchainServiceClient
-	blockchainClient, err := v1clients.NewBlockchainServiceClient(ctx, authOpt)
+	routeTimeouts, err = parseRouteTimeouts(*routeTimeoutsFlag)
 	if err != nil {
-		log.Fatalf("Error instantiating BlockchainServiceClient: %v", err)
+		logger.Fatal("Error parsing -route-timeouts", zap.Error(err))
 	}
 
-	// Create MPCKeyServiceClient
-	mpcKeyClient, err := v1clients.NewMPCKeyServiceClient(ctx, authOpt)
+	retryAttempts, err = parseRetryAttempts(*retryAttemptsFlag)
 	if err != nil {
-		log.Fatalf("Error instantiating MPCKeyServiceClient: %v", err)
+		logger.Fatal("Error parsing -retry-attempts", zap.Error(err))
 	}
 
-	// Create MPCTransactionServiceClient
-	mpcTransactionClient, err := v1clients.NewMPCTransactionServiceClient(ctx, authOpt)
+	upstreamConcurrency, err = parseUpstreamConcurrency(*upstreamConcurrencyFlag)
 	if err != nil {
-		log.Fatalf("Error instantiating MPCTransactionServiceClient: %v", err)
+		logger.Fatal("Error parsing -upstream-concurrency", zap.Error(err))
+	}
+
+	var apiKey *auth.APIKey
+	var svc *services
+	switch *backend {
+	case backendWaaS:
+		credentialProvider, err := newCredentialProvider(*credentialsSource, *credentialsFile, *secretsDir)
+		if err != nil {
+			logger.Fatal("Error configuring credentials", zap.Error(err))
+		}
+
+		apiKey, err = loadAPIKey(ctx, credentialProvider)
+		if err != nil {
+			logger.Fatal("Error loading WaaS API key", zap.Error(err))
+		}
+
+		svc, err = newUpstreamServices(ctx, apiKey)
+		if err != nil {
+			logger.Fatal("Error instantiating WaaS clients", zap.Error(err))
+		}
+	case backendEmulator:
+		waasEmulator, err := loadEmulator(*emulatorFixtureFile, *emulatorOperationDelay, *emulatorBlockTime)
+		if err != nil {
+			logger.Fatal("Error loading emulator fixture", zap.Error(err))
+		}
+		logger.Warn("Serving from the in-memory WaaS emulator")
+		svc = waasEmulator.services()
+	case backendReplay:
+		recorded, err := loadCassette(*cassetteDir, false)
+		if err != nil {
+			logger.Fatal("Error loading cassettes", zap.Error(err))
+		}
+		logger.Warn("Replaying WaaS traffic from cassettes", zap.String("dir", *cassetteDir))
+		svc = (&cassettePlayer{recorded}).services()
+	default:
+		logger.Fatal("-backend must be waas, emulator or replay", zap.String("backend", *backend))
+	}
+
+	// The readiness probes skip the recorder, so that cassettes only hold the traffic of
+	// the routes; there is nothing upstream to probe when replaying.
+	var probes []readinessProbe
+	if *backend != backendReplay {
+		probes = upstreamProbes(apiKey, svc)
+	}
+
+	if *recordCassettes {
+		if *backend == backendReplay {
+			logger.Fatal("-record-cassettes cannot record -backend=replay")
+		}
+		recording, err := loadCassette(*cassetteDir, true)
+		if err != nil {
+			logger.Fatal("Error loading cassettes", zap.Error(err))
+		}
+		logger.Warn("Recording WaaS traffic into cassettes", zap.String("dir", *cassetteDir))
+		svc = (&cassetteRecorder{next: svc, cassette: recording}).services()
+	}
+
+	var keys *keyStore
+	switch {
+	case *authKeysFile != "":
+		keys, err = loadKeyStore(*authKeysFile)
+		if err != nil {
+			logger.Fatal("Error loading proxy API keys", zap.Error(err))
+		}
+	case *authDisabled:
+		logger.Warn("Caller authentication is disabled")
+	default:
+		logger.Fatal("Either -auth-keys or -auth-disabled must be set")
+	}
+
+	var policy *policyEngine
+	if *policyFile != "" {
+		policy, err = newPolicyEngine(ctx, *policyFile, *policyReloadInterval)
+		if err != nil {
+			logger.Fatal("Error loading policy file", zap.Error(err))
+		}
+	}
+
+	var approvals *approvalStore
+	if *approvalStoreFile != "" {
+		if keys == nil {
+			logger.Fatal("-approval-store requires caller authentication to tell approvers apart")
+		}
+		approvals, err = newApprovalStore(ctx, *approvalStoreFile, *approvalThresholds, *approvalQuorum, *approvalTTL, svc.mpcTransaction.CreateMPCTransaction)
+		if err != nil {
+			logger.Fatal("Error loading approval store", zap.Error(err))
+		}
+	}
+
+	var auditTrail *auditLog
+	if *auditLogFile != "" {
+		auditTrail, err = openAuditLog(*auditLogFile)
+		if err != nil {
+			logger.Fatal("Error opening audit log", zap.Error(err))
+		}
 	}
 
-	// Create MPCWalletServiceClient
-	mpcWalletClient, err := v1clients.NewMPCWalletServiceClient(ctx, authOpt)
+	idempotencyResponses, err := newIdempotencyStore(ctx, *idempotencyStoreFile, *idempotencyTTL)
 	if err != nil {
-		log.Fatalf("Error instantiating MPCWalletServiceClient: %v", err)
+		logger.Fatal("Error loading idempotency store", zap.Error(err))
 	}
 
-	// Create PoolServiceClient
-	poolClient, err := v1clients.NewPoolServiceClient(ctx, authOpt)
+	readiness := newReadinessChecker(*readinessCacheTTL, *readinessProbeTimeout, probes...)
+
+	router := newRouter(svc, &routerConfig{
+		keys:        keys,
+		policy:      policy,
+		approvals:   approvals,
+		auditTrail:  auditTrail,
+		idempotency: idempotencyResponses,
+		readiness:   readiness,
+	})
+
+	tlsConfig, err := newTLSConfig(ctx)
 	if err != nil {
-		log.Fatalf("Error instantiating PoolServiceClient: %v", err)
+		logger.Fatal("Error configuring TLS", zap.Error(err))
 	}
 
-	// Create ProtocolServiceClient
-	protocolClient, err := v1clients.NewProtocolServiceClient(ctx, authOpt)
+	listeners, err := listen(*listenAddrs, tlsConfig)
 	if err != nil {
-		log.Fatalf("Error instantiating ProtocolServiceClient: %v", err)
+		logger.Fatal("Error listening", zap.Error(err))
+	}
+
+	server := &http.Server{
+		Handler: router,
 	}
 
+	serveErr := serve(server, listeners)
+
+	flushCtx, cancel := context.WithTimeout(ctx, *shutdownTimeout)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("Error flushing spans", zap.Error(err))
+	}
+
+	if serveErr != nil {
+		logger.Fatal("Error serving", zap.Error(serveErr))
+	}
+}
+
+// routerConfig holds the components of the proxy shared by the routes. A nil keys
+// disables authentication, and nil policy, approvals and auditTrail disable the policy
+// engine, the approval workflow and auditing respectively.
+type routerConfig struct {
+	keys        *keyStore
+	policy      *policyEngine
+	approvals   *approvalStore
+	auditTrail  *auditLog
+	idempotency *idempotencyStore
+	readiness   *readinessChecker
+}
+
+// newRouter returns the router serving the proxy's routes, calling the WaaS services of svc.
+func newRouter(svc *services, config *routerConfig) *gin.Engine {
 	// Create a Gin router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(logRequests(), recoverPanics())
+
+	// Health and readiness probes, registered ahead of the authentication middleware so
+	// that orchestrators can call them without an API key.
+	router.GET("/healthz", healthz)
+	router.GET("/readyz", readyz(config.readiness))
+	router.Use(traceRequests()...)
+	router.Use(instrument(), countRetries(), audit(config.auditTrail), authenticate(config.keys), authorizeResources(), idempotency(config.idempotency))
 
 	// Blockchain API - ListNetworks (GET)
-	router.GET("/blockchain/v1/networks", func(c *gin.Context) {
+	router.GET("/blockchain/v1/networks", requireScope(scopeBlockchainRead), deadline("ListNetworks"), func(c *gin.Context) {
 
-		pageSize, err := parseInt32(c.DefaultQuery("pageSize", "50"))
+		pageSize, pageToken, all, err := parsePageParams(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeBadRequest(c, err)
 			return
 		}
-		pageToken := c.Query("pageToken")
 
-		networksIter := blockchainClient.ListNetworks(context.Background(), &blockchain.ListNetworksRequest{PageSize: pageSize, PageToken: pageToken})
-
-		var networks []*blockchain.Network
-		for {
-			network, err := networksIter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			networks = append(networks, network)
+		networksIter := svc.blockchain.ListNetworks(c.Request.Context(), &blockchain.ListNetworksRequest{PageSize: pageSize, PageToken: pageToken})
+		if format := streamFormat(c); format != streamNone {
+			streamList[*blockchain.Network](c, format, networksIter)
+			return
 		}
 
-		networksJSON, err := json.Marshal(networks)
+		networks, nextPageToken, err := listPage[*blockchain.Network](networksIter, pageSize, pageToken, all)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(networksJSON)
+		writeProto(c, &blockchain.ListNetworksResponse{Networks: networks, NextPageToken: nextPageToken})
 	})
 
 	// Blockchain API - GetNetwork (GET)
-	router.GET("/blockchain/v1/networks/:networkId", func(c *gin.Context) {
+	router.GET("/blockchain/v1/networks/:networkId", requireScope(scopeBlockchainRead), deadline("GetNetwork"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		networkName := "networks/" + networkId
 
-		network, err := blockchainClient.GetNetwork(context.Background(), &blockchain.GetNetworkRequest{Name: networkName})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		networksJSON, err := json.Marshal(network)
+		network, err := svc.blockchain.GetNetwork(c.Request.Context(), &blockchain.GetNetworkRequest{Name: networkName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(networksJSON)
+		writeProto(c, network)
 	})
 
 	// Blockchain API - ListAssets (GET)
-	router.GET("/blockchain/v1/networks/:networkId/assets", func(c *gin.Context) {
+	router.GET("/blockchain/v1/networks/:networkId/assets", requireScope(scopeBlockchainRead), deadline("ListAssets"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		networkName := "networks/" + networkId
 
-		pageSize, err := parseInt32(c.DefaultQuery("pageSize", "50"))
+		pageSize, pageToken, all, err := parsePageParams(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeBadRequest(c, err)
 			return
 		}
-		pageToken := c.Query("pageToken")
 		filter := c.Query("filter")
 
-		assetsIter := blockchainClient.ListAssets(context.Background(), &blockchain.ListAssetsRequest{Parent: networkName, PageSize: pageSize, PageToken: pageToken, Filter: filter})
-
-		var assets []*blockchain.Asset
-		for {
-			asset, err := assetsIter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			assets = append(assets, asset)
+		assetsIter := svc.blockchain.ListAssets(c.Request.Context(), &blockchain.ListAssetsRequest{Parent: networkName, PageSize: pageSize, PageToken: pageToken, Filter: filter})
+		if format := streamFormat(c); format != streamNone {
+			streamList[*blockchain.Asset](c, format, assetsIter)
+			return
 		}
 
-		assetsJSON, err := json.Marshal(assets)
+		assets, nextPageToken, err := listPage[*blockchain.Asset](assetsIter, pageSize, pageToken, all)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(assetsJSON)
+		writeProto(c, &blockchain.ListAssetsResponse{Assets: assets, NextPageToken: nextPageToken})
 	})
 
 	// Blockchain API - GetAsset (GET)
-	router.GET("/blockchain/v1/networks/:networkId/assets/:assetId", func(c *gin.Context) {
+	router.GET("/blockchain/v1/networks/:networkId/assets/:assetId", requireScope(scopeBlockchainRead), deadline("GetAsset"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		assetId := c.Param("assetId")
 		var assetName = "networks/" + networkId + "/assets/" + assetId
 
-		asset, err := blockchainClient.GetAsset(context.Background(), &blockchain.GetAssetRequest{Name: assetName})
+		asset, err := svc.blockchain.GetAsset(c.Request.Context(), &blockchain.GetAssetRequest{Name: assetName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		assetJSON, err := json.Marshal(asset)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(assetJSON)
+		writeProto(c, asset)
 	})
 
 	// MPC Keys API - GetMPCKey (GET)
-	router.GET("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId/mpcKeys/:mpcKeyId", func(c *gin.Context) {
+	router.GET("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId/mpcKeys/:mpcKeyId", requireScope(scopeKeysRead), deadline("GetMPCKey"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		deviceGroupId := c.Param("deviceGroupId")
 		mpcKeyId := c.Param("mpcKeyId")
 		mpcKeyName := "pools/" + poolId + "/deviceGroups/" + deviceGroupId + "/mpcKeys/" + mpcKeyId
 
-		mpcKey, err := mpcKeyClient.GetMPCKey(context.Background(), &mpcKeys.GetMPCKeyRequest{Name: mpcKeyName})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		mpcKeyJSON, err := json.Marshal(mpcKey)
+		mpcKey, err := svc.mpcKey.GetMPCKey(c.Request.Context(), &mpcKeys.GetMPCKeyRequest{Name: mpcKeyName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(mpcKeyJSON)
+		writeProto(c, mpcKey)
 	})
 
 	// MPC Keys API - GetDevice (GET)
-	router.GET("/mpc_keys/v1/devices/:deviceId", func(c *gin.Context) {
+	router.GET("/mpc_keys/v1/devices/:deviceId", requireScope(scopeKeysRead), deadline("GetDevice"), func(c *gin.Context) {
 		deviceId := c.Param("deviceId")
 		deviceName := "devices/" + deviceId
 
-		device, err := mpcKeyClient.GetDevice(context.Background(), &mpcKeys.GetDeviceRequest{Name: deviceName})
+		device, err := svc.mpcKey.GetDevice(c.Request.Context(), &mpcKeys.GetDeviceRequest{Name: deviceName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		deviceJSON, err := json.Marshal(device)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(deviceJSON)
+		writeProto(c, device)
 	})
 
 	// MPC Keys API - GetDeviceGroup (GET)
-	router.GET("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId", func(c *gin.Context) {
+	router.GET("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId", requireScope(scopeKeysRead), deadline("GetDeviceGroup"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		deviceGroupId := c.Param("deviceGroupId")
 		deviceGroupName := "pools/" + poolId + "/deviceGroups/" + deviceGroupId
 
-		deviceGroup, err := mpcKeyClient.GetDeviceGroup(context.Background(), &mpcKeys.GetDeviceGroupRequest{Name: deviceGroupName})
+		deviceGroup, err := svc.mpcKey.GetDeviceGroup(c.Request.Context(), &mpcKeys.GetDeviceGroupRequest{Name: deviceGroupName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		deviceGroupJSON, err := json.Marshal(deviceGroup)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(deviceGroupJSON)
+		writeProto(c, deviceGroup)
 	})
 
 	// MPC Keys API - ListMPCOperations (GET)
-	router.GET("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId/mpcOperations", func(c *gin.Context) {
+	router.GET("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId/mpcOperations", requireScope(scopeKeysRead), deadline("ListMPCOperations"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		deviceGroupId := c.Param("deviceGroupId")
 		deviceGroupName := "pools/" + poolId + "/deviceGroups/" + deviceGroupId
 
-		mpcOperations, err := mpcKeyClient.ListMPCOperations(context.Background(), &mpcKeys.ListMPCOperationsRequest{Parent: deviceGroupName})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		mpcOperationsJSON, err := json.Marshal(mpcOperations)
+		mpcOperations, err := svc.mpcKey.ListMPCOperations(c.Request.Context(), &mpcKeys.ListMPCOperationsRequest{Parent: deviceGroupName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(mpcOperationsJSON)
+		writeProto(c, mpcOperations)
 	})
 
 	// MPC Keys API - RegisterDevice (POST)
-	router.POST("/mpc_keys/v1/device/register", func(c *gin.Context) {
-		var registerDeviceReq *mpcKeys.RegisterDeviceRequest
-		if err := c.BindJSON(&registerDeviceReq); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	router.POST("/mpc_keys/v1/device/register", requireScope(scopeDevicesCreate), deadline("RegisterDevice"), func(c *gin.Context) {
+		registerDeviceReq := &mpcKeys.RegisterDeviceRequest{}
+		if err := bindProto(c, registerDeviceReq); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
-		response, err := mpcKeyClient.RegisterDevice(ctx, registerDeviceReq)
+		registerDeviceReq.RequestId = idempotentRequestID(c, registerDeviceReq.RequestId)
+		response, err := svc.mpcKey.RegisterDevice(c.Request.Context(), registerDeviceReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		registerDeviceJSON, err := json.Marshal(response)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(registerDeviceJSON)
+		writeProto(c, response)
 	})
 
 	// MPC Keys API - CreateMPCKey (POST)
-	router.POST("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId/mpcKeys", func(c *gin.Context) {
+	router.POST("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId/mpcKeys", requireScope(scopeKeysCreate), deadline("CreateMPCKey"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		deviceGroupId := c.Param("deviceGroupId")
 		deviceGroupName := "pools/" + poolId + "/deviceGroups/" + deviceGroupId
 
-		requestId := c.Query("requestId")
+		requestId := idempotentRequestID(c, c.Query("requestId"))
 
-		var mpcKey *mpcKeys.MPCKey
-		if err := c.BindJSON(&mpcKey); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		mpcKey := &mpcKeys.MPCKey{}
+		if err := bindProto(c, mpcKey); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
 		createMpcKeyReq := &mpcKeys.CreateMPCKeyRequest{Parent: deviceGroupName, MpcKey: mpcKey, RequestId: requestId}
-		response, err := mpcKeyClient.CreateMPCKey(ctx, createMpcKeyReq)
+		response, err := svc.mpcKey.CreateMPCKey(c.Request.Context(), createMpcKeyReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		mpcKeyJSON, err := json.Marshal(response)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(mpcKeyJSON)
+		writeProto(c, response)
 	})
 
 	// MPC Keys API - CreateSignature (POST)
-	router.POST("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId/mpcKeys/:mpcKeyId/signatures", func(c *gin.Context) {
+	router.POST("/mpc_keys/v1/pools/:poolId/deviceGroups/:deviceGroupId/mpcKeys/:mpcKeyId/signatures", requireScope(scopeSignaturesCreate), deadline("CreateSignature"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		deviceGroupId := c.Param("deviceGroupId")
 		mpcKeyId := c.Param("mpcKeyId")
 		mpcKeyName := "pools/" + poolId + "/deviceGroups/" + deviceGroupId + "/mpcKeys/" + mpcKeyId
 
-		requestId := c.Query("requestId")
+		requestId := idempotentRequestID(c, c.Query("requestId"))
 
-		var signature *mpcKeys.Signature
-		if err := c.BindJSON(&signature); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		signature := &mpcKeys.Signature{}
+		if err := bindProto(c, signature); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
-		createSignatureReq := &mpcKeys.CreateSignatureRequest{Parent: mpcKeyName, Signature: signature, RequestId: requestId}
-		response, err := mpcKeyClient.CreateSignature(ctx, createSignatureReq)
+		violation, err := config.policy.checkSignature(c.Request.Context(), svc.mpcWallet, mpcKeyName)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
+			return
+		}
+		if !enforcePolicy(c, violation) {
 			return
 		}
 
-		signatureJSON, err := json.Marshal(response)
+		createSignatureReq := &mpcKeys.CreateSignatureRequest{Parent: mpcKeyName, Signature: signature, RequestId: requestId}
+		response, err := svc.mpcKey.CreateSignature(c.Request.Context(), createSignatureReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(signatureJSON)
+		writeOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata](c, operationCreateSignature, response, false)
 	})
 
 	// MPC Keys API - CreateDeviceGroup (POST)
-	router.POST("/mpc_keys/v1/pools/:poolId/deviceGroups", func(c *gin.Context) {
+	router.POST("/mpc_keys/v1/pools/:poolId/deviceGroups", requireScope(scopeKeysCreate), deadline("CreateDeviceGroup"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		mpcKeyName := "pools/" + poolId
 
 		deviceGroupId := c.Query("deviceGroupId")
-		requestId := c.Query("requestId")
+		requestId := idempotentRequestID(c, c.Query("requestId"))
 
-		var deviceGroup *mpcKeys.DeviceGroup
-		if err := c.BindJSON(&deviceGroup); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		deviceGroup := &mpcKeys.DeviceGroup{}
+		if err := bindProto(c, deviceGroup); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
 		createDeviceGroupReq := &mpcKeys.CreateDeviceGroupRequest{Parent: mpcKeyName, DeviceGroup: deviceGroup, DeviceGroupId: deviceGroupId, RequestId: requestId}
-		response, err := mpcKeyClient.CreateDeviceGroup(ctx, createDeviceGroupReq)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		deviceGroupJSON, err := json.Marshal(response)
+		response, err := svc.mpcKey.CreateDeviceGroup(c.Request.Context(), createDeviceGroupReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(deviceGroupJSON)
+		writeOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata](c, operationCreateDeviceGroup, response, false)
 	})
 
 	// MPC Transactions API - GetMPCTransaction (GET)
-	router.GET("/mpc_transactions/v1/pools/:poolId/mpcWallets/:mpcWalletId/mpcTransactions/:mpcTransactionId", func(c *gin.Context) {
+	router.GET("/mpc_transactions/v1/pools/:poolId/mpcWallets/:mpcWalletId/mpcTransactions/:mpcTransactionId", requireScope(scopeTransactionsRead), deadline("GetMPCTransaction"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		mpcWalletId := c.Param("mpcWalletId")
 		mpcTransactionId := c.Param("mpcTransactionId")
 
 		mpcTransactionName := "pools/" + poolId + "/mpcWallets/" + mpcWalletId + "/mpcTransactions/" + mpcTransactionId
 
-		mpcTx, err := mpcTransactionClient.GetMPCTransaction(context.Background(), &mpcTransactions.GetMPCTransactionRequest{Name: mpcTransactionName})
+		mpcTx, err := svc.mpcTransaction.GetMPCTransaction(c.Request.Context(), &mpcTransactions.GetMPCTransactionRequest{Name: mpcTransactionName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		mpcTxJSON, err := json.Marshal(mpcTx)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(mpcTxJSON)
+		writeProto(c, mpcTx)
 	})
 
 	// MPC Transactions API - ListMPCTransactions (GET)
-	router.GET("/mpc_transactions/v1/pools/:poolId/mpcWallets/:mpcWalletId/mpcTransactions", func(c *gin.Context) {
+	router.GET("/mpc_transactions/v1/pools/:poolId/mpcWallets/:mpcWalletId/mpcTransactions", requireScope(scopeTransactionsRead), deadline("ListMPCTransactions"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		mpcWalletId := c.Param("mpcWalletId")
 		mpcWalletName := "pools/" + poolId + "/mpcWallets/" + mpcWalletId
 
-		pageSize, err := parseInt32(c.DefaultQuery("pageSize", "50"))
+		pageSize, pageToken, all, err := parsePageParams(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeBadRequest(c, err)
 			return
 		}
-		pageToken := c.Query("pageToken")
 
-		mpxTxsIter := mpcTransactionClient.ListMPCTransactions(context.Background(), &mpcTransactions.ListMPCTransactionsRequest{Parent: mpcWalletName, PageSize: pageSize, PageToken: pageToken})
-
-		var mpxTxs []*mpcTransactions.MPCTransaction
-		for {
-			mpxTx, err := mpxTxsIter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			mpxTxs = append(mpxTxs, mpxTx)
+		mpxTxsIter := svc.mpcTransaction.ListMPCTransactions(c.Request.Context(), &mpcTransactions.ListMPCTransactionsRequest{Parent: mpcWalletName, PageSize: pageSize, PageToken: pageToken})
+		if format := streamFormat(c); format != streamNone {
+			streamList[*mpcTransactions.MPCTransaction](c, format, mpxTxsIter)
+			return
 		}
 
-		mpxTxsJSON, err := json.Marshal(mpxTxs)
+		mpxTxs, nextPageToken, err := listPage[*mpcTransactions.MPCTransaction](mpxTxsIter, pageSize, pageToken, all)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(mpxTxsJSON)
+		writeProto(c, &mpcTransactions.ListMPCTransactionsResponse{MpcTransactions: mpxTxs, NextPageToken: nextPageToken})
 	})
 
 	// MPC Transactions API - CreateMPCTransaction (POST)
-	router.POST("/mpc_transactions/v1/pools/:poolId/mpcWallets/:mpcWalletId/mpcTransactions", func(c *gin.Context) {
+	router.POST("/mpc_transactions/v1/pools/:poolId/mpcWallets/:mpcWalletId/mpcTransactions", requireScope(scopeTransactionsCreate), deadline("CreateMPCTransaction"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		mpcWalletId := c.Param("mpcWalletId")
 		mpcWalletName := "pools/" + poolId + "/mpcWallets/" + mpcWalletId
 
 		var requestBody mpcTransactions.CreateMPCTransactionRequest
-		if err := c.BindJSON(&requestBody); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := bindProto(c, &requestBody); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
-		createMpcTxReq := &mpcTransactions.CreateMPCTransactionRequest{Parent: mpcWalletName, MpcTransaction: requestBody.MpcTransaction, Input: requestBody.Input, OverrideNonce: requestBody.OverrideNonce, RequestId: requestBody.RequestId}
-		response, err := mpcTransactionClient.CreateMPCTransaction(ctx, createMpcTxReq)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		createMpcTxReq := &mpcTransactions.CreateMPCTransactionRequest{Parent: mpcWalletName, MpcTransaction: requestBody.MpcTransaction, Input: requestBody.Input, OverrideNonce: requestBody.OverrideNonce, RequestId: idempotentRequestID(c, requestBody.RequestId)}
+		if !enforcePolicy(c, config.policy.checkTransaction(createMpcTxReq)) {
+			return
+		}
+
+		if config.approvals.requiresApproval(createMpcTxReq) {
+			pendingTx, err := config.approvals.create(createMpcTxReq, identityFromContext(c).ID)
+			if err != nil {
+				writeApprovalError(c, err)
+				return
+			}
+
+			c.Header("Location", "/approvals/v1/"+pendingTx.Name)
+			c.JSON(http.StatusAccepted, pendingTx)
 			return
 		}
 
-		mpcTxJSON, err := json.Marshal(response)
+		response, err := svc.mpcTransaction.CreateMPCTransaction(c.Request.Context(), createMpcTxReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(mpcTxJSON)
+		writeOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata](c, operationCreateMPCTransaction, response, false)
 	})
 
 	// MPC Wallets API - GetMPCWallet (GET)
-	router.GET("/mpc_wallets/v1/pools/:poolId/mpcWallets/:mpcWalletId", func(c *gin.Context) {
+	router.GET("/mpc_wallets/v1/pools/:poolId/mpcWallets/:mpcWalletId", requireScope(scopeWalletsRead), deadline("GetMPCWallet"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		mpcWalletId := c.Param("mpcWalletId")
 
 		mpcWalletName := "pools/" + poolId + "/mpcWallets/" + mpcWalletId
 
-		wallet, err := mpcWalletClient.GetMPCWallet(context.Background(), &mpcWallet.GetMPCWalletRequest{Name: mpcWalletName})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		walletJSON, err := json.Marshal(wallet)
+		wallet, err := svc.mpcWallet.GetMPCWallet(c.Request.Context(), &mpcWallet.GetMPCWalletRequest{Name: mpcWalletName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(walletJSON)
+		writeProto(c, wallet)
 	})
 
 	// MPC Wallets API - ListMPCWallets (GET)
-	router.GET("/mpc_wallets/v1/pools/:poolId/mpcWallets", func(c *gin.Context) {
+	router.GET("/mpc_wallets/v1/pools/:poolId/mpcWallets", requireScope(scopeWalletsRead), deadline("ListMPCWallets"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		poolName := "pools/" + poolId
 
-		pageSize, err := parseInt32(c.DefaultQuery("pageSize", "50"))
+		pageSize, pageToken, all, err := parsePageParams(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeBadRequest(c, err)
 			return
 		}
-		pageToken := c.Query("pageToken")
-
-		walletsIter := mpcWalletClient.ListMPCWallets(context.Background(), &mpcWallet.ListMPCWalletsRequest{Parent: poolName, PageSize: pageSize, PageToken: pageToken})
 
-		var wallets []*mpcWallet.MPCWallet
-		for {
-			wallet, err := walletsIter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			wallets = append(wallets, wallet)
+		walletsIter := svc.mpcWallet.ListMPCWallets(c.Request.Context(), &mpcWallet.ListMPCWalletsRequest{Parent: poolName, PageSize: pageSize, PageToken: pageToken})
+		if format := streamFormat(c); format != streamNone {
+			streamList(c, format, filterAuthorizedIterator[*mpcWallet.MPCWallet](c, walletsIter, (*mpcWallet.MPCWallet).GetName))
+			return
 		}
 
-		walletsJSON, err := json.Marshal(wallets)
+		wallets, nextPageToken, err := listPage[*mpcWallet.MPCWallet](walletsIter, pageSize, pageToken, all)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(walletsJSON)
+		wallets = filterAuthorized(c, wallets, (*mpcWallet.MPCWallet).GetName)
+
+		writeProto(c, &mpcWallet.ListMPCWalletsResponse{MpcWallets: wallets, NextPageToken: nextPageToken})
 	})
 
 	// MPC Wallets API - GetAddress (GET)
-	router.GET("/mpc_wallets/v1/networks/:networkId/addresses/:addressId", func(c *gin.Context) {
+	router.GET("/mpc_wallets/v1/networks/:networkId/addresses/:addressId", requireScope(scopeWalletsRead), deadline("GetAddress"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		addressId := c.Param("addressId")
 		networkName := "networks/" + networkId + "/addresses/" + addressId
 
-		address, err := mpcWalletClient.GetAddress(context.Background(), &mpcWallet.GetAddressRequest{Name: networkName})
+		address, err := svc.mpcWallet.GetAddress(c.Request.Context(), &mpcWallet.GetAddressRequest{Name: networkName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		addressJSON, err := json.Marshal(address)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if !authorizeResource(c, address.GetMpcWallet()) {
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(addressJSON)
+		writeProto(c, address)
 	})
 
 	// MPC Wallets API - ListAddresses (GET)
-	router.GET("/mpc_wallets/v1/networks/:networkId/addresses", func(c *gin.Context) {
+	router.GET("/mpc_wallets/v1/networks/:networkId/addresses", requireScope(scopeWalletsRead), deadline("ListAddresses"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		networkName := "networks/" + networkId
 
-		pageSize, err := parseInt32(c.DefaultQuery("pageSize", "50"))
+		pageSize, pageToken, all, err := parsePageParams(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeBadRequest(c, err)
 			return
 		}
-		pageToken := c.Query("pageToken")
 		wallet := c.Query("mpcWallet")
+		if wallet != "" && !authorizeResource(c, wallet) {
+			return
+		}
 
-		addressesIter := mpcWalletClient.ListAddresses(context.Background(), &mpcWallet.ListAddressesRequest{Parent: networkName, MpcWallet: wallet, PageSize: pageSize, PageToken: pageToken})
-
-		var addresses []*mpcWallet.Address
-		for {
-			address, err := addressesIter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			addresses = append(addresses, address)
+		addressesIter := svc.mpcWallet.ListAddresses(c.Request.Context(), &mpcWallet.ListAddressesRequest{Parent: networkName, MpcWallet: wallet, PageSize: pageSize, PageToken: pageToken})
+		if format := streamFormat(c); format != streamNone {
+			streamList(c, format, filterAuthorizedIterator[*mpcWallet.Address](c, addressesIter, (*mpcWallet.Address).GetMpcWallet))
+			return
 		}
 
-		addressesJSON, err := json.Marshal(addresses)
+		addresses, nextPageToken, err := listPage[*mpcWallet.Address](addressesIter, pageSize, pageToken, all)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(addressesJSON)
+		addresses = filterAuthorized(c, addresses, (*mpcWallet.Address).GetMpcWallet)
+
+		writeProto(c, &mpcWallet.ListAddressesResponse{Addresses: addresses, NextPageToken: nextPageToken})
 	})
 
 	// MPC Wallets API - ListBalances (GET)
-	router.GET("/mpc_wallets/v1/networks/:networkId/addresses/:addressId/balances", func(c *gin.Context) {
+	router.GET("/mpc_wallets/v1/networks/:networkId/addresses/:addressId/balances", requireScope(scopeWalletsRead), deadline("ListBalances"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		addressId := c.Param("addressId")
 		addressName := "networks/" + networkId + "/addresses/" + addressId
 
-		pageSize, err := parseInt32(c.DefaultQuery("pageSize", "50"))
+		pageSize, pageToken, all, err := parsePageParams(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeBadRequest(c, err)
 			return
 		}
-		pageToken := c.Query("pageToken")
-
-		balancesIter := mpcWalletClient.ListBalances(context.Background(), &mpcWallet.ListBalancesRequest{Parent: addressName, PageSize: pageSize, PageToken: pageToken})
 
-		var balances []*mpcWallet.Balance
-		for {
-			balance, err := balancesIter.Next()
-			if err == iterator.Done {
-				break
-			}
+		// Addresses are not named after their Pool, so look up the owning MPCWallet of
+		// the Address for callers restricted to specific Pools or MPCWallets.
+		if identity := identityFromContext(c); identity != nil && identity.Restricted() {
+			address, err := svc.mpcWallet.GetAddress(c.Request.Context(), &mpcWallet.GetAddressRequest{Name: addressName})
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				writeError(c, err)
+				return
+			}
+			if !authorizeResource(c, address.GetMpcWallet()) {
 				return
 			}
-			balances = append(balances, balance)
 		}
 
-		balancesJSON, err := json.Marshal(balances)
+		balancesIter := svc.mpcWallet.ListBalances(c.Request.Context(), &mpcWallet.ListBalancesRequest{Parent: addressName, PageSize: pageSize, PageToken: pageToken})
+		if format := streamFormat(c); format != streamNone {
+			streamList[*mpcWallet.Balance](c, format, balancesIter)
+			return
+		}
+
+		balances, nextPageToken, err := listPage[*mpcWallet.Balance](balancesIter, pageSize, pageToken, all)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(balancesJSON)
+		writeProto(c, &mpcWallet.ListBalancesResponse{Balances: balances, NextPageToken: nextPageToken})
 	})
 
 	// MPC Wallets API - CreateMPCWallet (POST)
-	router.POST("/mpc_wallets/v1/pools/:poolId/mpcWallets", func(c *gin.Context) {
+	router.POST("/mpc_wallets/v1/pools/:poolId/mpcWallets", requireScope(scopeWalletsCreate), deadline("CreateMPCWallet"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		poolName := "pools/" + poolId
 
 		device := c.Query("device")
-		requestId := c.Query("requestId")
+		requestId := idempotentRequestID(c, c.Query("requestId"))
 
-		var wallet *mpcWallet.MPCWallet
-		if err := c.BindJSON(&wallet); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		wallet := &mpcWallet.MPCWallet{}
+		if err := bindProto(c, wallet); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
 		createMpcWalletReq := &mpcWallet.CreateMPCWalletRequest{Parent: poolName, MpcWallet: wallet, Device: device, RequestId: requestId}
-		response, err := mpcWalletClient.CreateMPCWallet(ctx, createMpcWalletReq)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		mpcWalletJSON, err := json.Marshal(response)
+		response, err := svc.mpcWallet.CreateMPCWallet(c.Request.Context(), createMpcWalletReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(mpcWalletJSON)
+		writeOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata](c, operationCreateMPCWallet, response, false)
 	})
 
 	// MPC Wallets API - GenerateAddress (POST)
-	router.POST("/mpc_wallets/v1/pools/:poolId/mpcWallets/:mpcWalletId/generateAddress", func(c *gin.Context) {
+	router.POST("/mpc_wallets/v1/pools/:poolId/mpcWallets/:mpcWalletId/generateAddress", requireScope(scopeWalletsCreate), deadline("GenerateAddress"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		mpcWalletId := c.Param("mpcWalletId")
 		mpcWalletName := "pools/" + poolId + "/mpcWallets/" + mpcWalletId
 
 		var requestBody mpcWallet.GenerateAddressRequest
-		if err := c.BindJSON(&requestBody); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		generateAddressReq := &mpcWallet.GenerateAddressRequest{MpcWallet: mpcWalletName, Network: requestBody.Network, RequestId: requestBody.RequestId}
-		response, err := mpcWalletClient.GenerateAddress(ctx, generateAddressReq)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if err := bindProto(c, &requestBody); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
-		addressJSON, err := json.Marshal(response)
+		generateAddressReq := &mpcWallet.GenerateAddressRequest{MpcWallet: mpcWalletName, Network: requestBody.Network, RequestId: idempotentRequestID(c, requestBody.RequestId)}
+		response, err := svc.mpcWallet.GenerateAddress(c.Request.Context(), generateAddressReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(addressJSON)
+		writeProto(c, response)
 	})
 
 	// Pools API - GetPool (GET)
-	router.GET("/pools/v1/pools/:poolId", func(c *gin.Context) {
+	router.GET("/pools/v1/pools/:poolId", requireScope(scopePoolsRead), deadline("GetPool"), func(c *gin.Context) {
 		poolId := c.Param("poolId")
 		poolName := "pools/" + poolId
 
-		pool, err := poolClient.GetPool(context.Background(), &pools.GetPoolRequest{Name: poolName})
+		pool, err := svc.pool.GetPool(c.Request.Context(), &pools.GetPoolRequest{Name: poolName})
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		poolJSON, err := json.Marshal(pool)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(poolJSON)
+		writeProto(c, pool)
 	})
 
 	// Pools API - ListPools (GET)
-	router.GET("/pools/v1/pools", func(c *gin.Context) {
+	router.GET("/pools/v1/pools", requireScope(scopePoolsRead), deadline("ListPools"), func(c *gin.Context) {
 
-		pageSize, err := parseInt32(c.DefaultQuery("pageSize", "50"))
+		pageSize, pageToken, all, err := parsePageParams(c)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			writeBadRequest(c, err)
 			return
 		}
-		pageToken := c.Query("pageToken")
 
-		poolsIter := poolClient.ListPools(context.Background(), &pools.ListPoolsRequest{PageSize: pageSize, PageToken: pageToken})
-
-		var pools []*pools.Pool
-		for {
-			pool, err := poolsIter.Next()
-			if err == iterator.Done {
-				break
-			}
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
-			}
-			pools = append(pools, pool)
+		poolsIter := svc.pool.ListPools(c.Request.Context(), &pools.ListPoolsRequest{PageSize: pageSize, PageToken: pageToken})
+		if format := streamFormat(c); format != streamNone {
+			streamList(c, format, filterAuthorizedIterator[*pools.Pool](c, poolsIter, (*pools.Pool).GetName))
+			return
 		}
 
-		poolsJSON, err := json.Marshal(pools)
+		poolList, nextPageToken, err := listPage[*pools.Pool](poolsIter, pageSize, pageToken, all)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(poolsJSON)
+		poolList = filterAuthorized(c, poolList, (*pools.Pool).GetName)
+
+		writeProto(c, &pools.ListPoolsResponse{Pools: poolList, NextPageToken: nextPageToken})
 	})
 
 	// Pools API - CreatePool (POST)
-	router.POST("/pools/v1/pools", func(c *gin.Context) {
+	router.POST("/pools/v1/pools", requireScope(scopePoolsAdmin), deadline("CreatePool"), func(c *gin.Context) {
 		poolId := c.Query("poolId")
-
-		var pool *pools.Pool
-		if err := c.BindJSON(&pool); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if !authorizeResource(c, "pools/"+poolId) {
 			return
 		}
 
-		createPoolReq := &pools.CreatePoolRequest{PoolId: poolId, Pool: pool}
-		response, err := poolClient.CreatePool(ctx, createPoolReq)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		pool := &pools.Pool{}
+		if err := bindProto(c, pool); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
-		poolJSON, err := json.Marshal(response)
+		createPoolReq := &pools.CreatePoolRequest{PoolId: poolId, Pool: pool}
+		response, err := svc.pool.CreatePool(c.Request.Context(), createPoolReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(poolJSON)
+		writeProto(c, response)
 	})
 
 	// Protocols API - BroadcastTransaction (POST)
-	router.POST("/protocols/v1/networks/:networkId/broadcastTransaction", func(c *gin.Context) {
+	router.POST("/protocols/v1/networks/:networkId/broadcastTransaction", requireScope(scopeTransactionsBroadcast), deadline("BroadcastTransaction"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		networkName := "networks/" + networkId
 
-		var transaction *v1types.Transaction
-		if err := c.BindJSON(&transaction); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		transaction := &v1types.Transaction{}
+		if err := bindProto(c, transaction); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
 		broadcastTxReq := &protocols.BroadcastTransactionRequest{Network: networkName, Transaction: transaction}
-		response, err := protocolClient.BroadcastTransaction(ctx, broadcastTxReq)
+		response, err := svc.protocol.BroadcastTransaction(c.Request.Context(), broadcastTxReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		txJSON, err := json.Marshal(response)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(txJSON)
+		writeProto(c, response)
 	})
 
 	// Protocols API - ConstructTransaction (POST)
-	router.POST("/protocols/v1/networks/:networkId/constructTransaction", func(c *gin.Context) {
+	router.POST("/protocols/v1/networks/:networkId/constructTransaction", requireScope(scopeTransactionsConstruct), deadline("ConstructTransaction"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		networkName := "networks/" + networkId
 
-		var input *v1types.TransactionInput
-		if err := c.BindJSON(&input); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		input := &v1types.TransactionInput{}
+		if err := bindProto(c, input); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
 		constructTxReq := &protocols.ConstructTransactionRequest{Network: networkName, Input: input}
-		response, err := protocolClient.ConstructTransaction(ctx, constructTxReq)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		txJSON, err := json.Marshal(response)
+		response, err := svc.protocol.ConstructTransaction(c.Request.Context(), constructTxReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(txJSON)
+		writeProto(c, response)
 	})
 
 	// Protocols API - ConstructTransferTransaction (POST)
-	router.POST("/protocols/v1/networks/:networkId/constructTransferTransaction", func(c *gin.Context) {
+	router.POST("/protocols/v1/networks/:networkId/constructTransferTransaction", requireScope(scopeTransactionsConstruct), deadline("ConstructTransferTransaction"), func(c *gin.Context) {
 		networkId := c.Param("networkId")
 		networkName := "networks/" + networkId
 
 		var requestBody protocols.ConstructTransferTransactionRequest
-		if err := c.BindJSON(&requestBody); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if err := bindProto(c, &requestBody); err != nil {
+			writeBadRequest(c, err)
 			return
 		}
 
-		constructTransferTxReq := &protocols.ConstructTransferTransactionRequest{Network: networkName,Asset: requestBody.Asset, Sender: requestBody.Sender, Recipient: requestBody.Recipient, Amount: requestBody.Amount, Nonce: requestBody.Nonce, Fee: requestBody.Fee}
-		response, err := protocolClient.ConstructTransferTransaction(ctx, constructTransferTxReq)
+		constructTransferTxReq := &protocols.ConstructTransferTransactionRequest{Network: networkName, Asset: requestBody.Asset, Sender: requestBody.Sender, Recipient: requestBody.Recipient, Amount: requestBody.Amount, Nonce: requestBody.Nonce, Fee: requestBody.Fee}
+		response, err := svc.protocol.ConstructTransferTransaction(c.Request.Context(), constructTransferTxReq)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			writeError(c, err)
 			return
 		}
 
-		transferTxJSON, err := json.Marshal(response)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeProto(c, response)
+	})
+
+	if config.approvals != nil {
+		// Approvals API - ListPendingTransactions (GET)
+		router.GET("/approvals/v1/pendingTransactions", requireScope(scopeApprovalsRead), deadline("ListPendingTransactions"), func(c *gin.Context) {
+			pendingTxs := config.approvals.list(c.Query("state"))
+			pendingTxs = filterAuthorized(c, pendingTxs, func(pt *pendingTransaction) string { return pt.Parent })
+
+			c.JSON(http.StatusOK, gin.H{"pendingTransactions": pendingTxs})
+		})
+
+		// Approvals API - GetPendingTransaction (GET)
+		router.GET("/approvals/v1/pendingTransactions/:pendingTransactionId", requireScope(scopeApprovalsRead), deadline("GetPendingTransaction"), func(c *gin.Context) {
+			pendingTx, err := config.approvals.get(pendingTransactionPrefix + c.Param("pendingTransactionId"))
+			if err != nil {
+				writeApprovalError(c, err)
+				return
+			}
+			if !authorizeResource(c, pendingTx.Parent) {
+				return
+			}
+
+			c.JSON(http.StatusOK, pendingTx)
+		})
+
+		// Approvals API - ApprovePendingTransaction (POST)
+		router.POST("/approvals/v1/pendingTransactions/:pendingTransactionId/approve", requireScope(scopeApprovalsReview), deadline("ApprovePendingTransaction"), func(c *gin.Context) {
+			pendingTxName := pendingTransactionPrefix + c.Param("pendingTransactionId")
+
+			review, err := bindReview(c)
+			if err != nil {
+				writeBadRequest(c, err)
+				return
+			}
+
+			pendingTx, err := config.approvals.get(pendingTxName)
+			if err != nil {
+				writeApprovalError(c, err)
+				return
+			}
+			if !authorizeResource(c, pendingTx.Parent) {
+				return
+			}
+
+			pendingTx, createMpcTxReq, err := config.approvals.approve(pendingTxName, identityFromContext(c).ID, review.Comment, config.policy)
+			if err != nil {
+				writeApprovalError(c, err)
+				return
+			}
+			if createMpcTxReq == nil {
+				c.JSON(http.StatusOK, pendingTx)
+				return
+			}
+
+			response, err := config.approvals.submitApproved(c.Request.Context(), pendingTxName, createMpcTxReq)
+			if err != nil {
+				writeError(c, err)
+				return
+			}
+
+			writeOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata](c, operationCreateMPCTransaction, response, false)
+		})
+
+		// Approvals API - RejectPendingTransaction (POST)
+		router.POST("/approvals/v1/pendingTransactions/:pendingTransactionId/reject", requireScope(scopeApprovalsReview), deadline("RejectPendingTransaction"), func(c *gin.Context) {
+			pendingTxName := pendingTransactionPrefix + c.Param("pendingTransactionId")
+
+			review, err := bindReview(c)
+			if err != nil {
+				writeBadRequest(c, err)
+				return
+			}
+
+			pendingTx, err := config.approvals.get(pendingTxName)
+			if err != nil {
+				writeApprovalError(c, err)
+				return
+			}
+			if !authorizeResource(c, pendingTx.Parent) {
+				return
+			}
+
+			pendingTx, err = config.approvals.reject(pendingTxName, identityFromContext(c).ID, review.Comment)
+			if err != nil {
+				writeApprovalError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, pendingTx)
+		})
+	}
+
+	// Operations API - GetOperation (GET)
+	router.GET("/operations/*name", requireScope(scopeOperationsRead), deadline("GetOperation"), func(c *gin.Context) {
+		operationName := strings.TrimPrefix(c.Param("name"), "/")
+		if operationName == "" {
+			writeBadRequest(c, errors.New("operation name is required"))
 			return
 		}
 
-		c.Header("Content-Type", "application/json")
-		c.Writer.Write(transferTxJSON)
+		switch kind := c.Query("kind"); kind {
+		case operationCreateDeviceGroup:
+			writeOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata](c, kind, svc.mpcKey.CreateDeviceGroupOperation(operationName), true)
+		case operationCreateSignature:
+			writeOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata](c, kind, svc.mpcKey.CreateSignatureOperation(operationName), true)
+		case operationCreateMPCWallet:
+			writeOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata](c, kind, svc.mpcWallet.CreateMPCWalletOperation(operationName), true)
+		case operationCreateMPCTransaction:
+			writeOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata](c, kind, svc.mpcTransaction.CreateMPCTransactionOperation(operationName), true)
+		default:
+			writeBadRequest(c, fmt.Errorf("unknown operation kind %q", kind))
+		}
 	})
 
-	server := http.Server{
-		Addr:    ":8080",
-		Handler: router,
-	}
+	// Metrics - Prometheus (GET)
+	router.GET("/metrics", requireScope(scopeMetricsRead), gin.WrapH(promhttp.Handler()))
 
-	log.Println("Proxy server listening on port 8080...")
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal(err)
-	}
+	return router
 }
@@ -0,0 +1,911 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	blockchain "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/blockchain/v1"
+	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	mpcWallet "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_wallets/v1"
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	"github.com/gin-gonic/gin"
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// API keys of the test callers.
+const (
+	testAdminKey     = "admin-key"
+	testApprover1Key = "approver-1-key"
+	testApprover2Key = "approver-2-key"
+	testPool1Key     = "pool-1-key"
+	testPool2Key     = "pool-2-key"
+	testWalletKey    = "wallet-1-key"
+)
+
+// Operations seeded in the fakes, one of each kind.
+const (
+	testDeviceGroupOperation    = "operations/device-group-op"
+	testSignatureOperation      = "operations/signature-op"
+	testMPCWalletOperation      = "operations/mpc-wallet-op"
+	testMPCTransactionOperation = "operations/mpc-transaction-op"
+)
+
+// testTransferInput is the EIP-1559 input of a transfer of amount wei.
+func testTransferInput(amount string) string {
+	return `{"ethereum1559Input": {"chainId": "5", "toAddress": "0x0000000000000000000000000000000000000001", "value": "` + amount + `", "maxFeePerGas": "1", "gas": "21000"}}`
+}
+
+// testTransaction is the body of a CreateMPCTransaction request transferring amount wei.
+func testTransaction(amount string) string {
+	return `{"mpcTransaction": {"network": "networks/ethereum-goerli"}, "input": ` + testTransferInput(amount) + `}`
+}
+
+// newSeededWaaS returns fakes holding a resource of each type, all reachable from
+// pools/pool-1 and networks/ethereum-goerli.
+func newSeededWaaS() *fakeWaaS {
+	waas := &fakeWaaS{}
+
+	waas.blockchain.networks.put(&blockchain.Network{Name: "networks/ethereum-goerli", DisplayName: "Ethereum Goerli"})
+	waas.blockchain.assets.put(&blockchain.Asset{Name: "networks/ethereum-goerli/assets/eth", AdvertisedSymbol: "ETH", Decimals: 18})
+
+	waas.pool.pools.put(&pools.Pool{Name: "pools/pool-1", DisplayName: "Pool 1"})
+
+	deviceGroup := &mpcKeys.DeviceGroup{Name: "pools/pool-1/deviceGroups/group-1", Devices: []string{"devices/device-1"}}
+	signature := &mpcKeys.Signature{Name: "pools/pool-1/deviceGroups/group-1/mpcKeys/key-1/signatures/signature-1", Payload: []byte("payload")}
+	waas.mpcKey.devices.put(&mpcKeys.Device{Name: "devices/device-1"})
+	waas.mpcKey.deviceGroups.put(deviceGroup)
+	waas.mpcKey.mpcKeys.put(&mpcKeys.MPCKey{Name: "pools/pool-1/deviceGroups/group-1/mpcKeys/key-1", DerivationPath: []int32{44, 60, 0, 0, 0}})
+	waas.mpcKey.operations.put(completedOperation(testDeviceGroupOperation, deviceGroup, &mpcKeys.CreateDeviceGroupMetadata{DeviceGroup: deviceGroup.Name}))
+	waas.mpcKey.operations.put(completedOperation(testSignatureOperation, signature, &mpcKeys.CreateSignatureMetadata{}))
+
+	wallet := &mpcWallet.MPCWallet{Name: "pools/pool-1/mpcWallets/wallet-1", DeviceGroup: deviceGroup.Name}
+	waas.mpcWallet.mpcWallets.put(wallet)
+	waas.mpcWallet.addresses.put(&mpcWallet.Address{Name: "networks/ethereum-goerli/addresses/address-1", Address: "0x0000000000000000000000000000000000000002", MpcWallet: wallet.Name})
+	waas.mpcWallet.balances.put(&mpcWallet.Balance{Name: "networks/ethereum-goerli/addresses/address-1/balances/eth", Asset: "networks/ethereum-goerli/assets/eth", Amount: "1000000000000000000"})
+	waas.mpcWallet.operations.put(completedOperation(testMPCWalletOperation, wallet, &mpcWallet.CreateMPCWalletMetadata{}))
+
+	mpcTx := &mpcTransactions.MPCTransaction{Name: wallet.Name + "/mpcTransactions/tx-1", Network: "networks/ethereum-goerli", State: mpcTransactions.MPCTransaction_CONFIRMED}
+	waas.mpcTransaction.mpcTransactions.put(mpcTx)
+	waas.mpcTransaction.operations.put(completedOperation(testMPCTransactionOperation, mpcTx, &mpcTransactions.CreateMPCTransactionMetadata{}))
+
+	return waas
+}
+
+// testProxy is a router backed by seeded fakes, with an admin caller and two approvers
+// of transfers above 1000 wei.
+type testProxy struct {
+	waas      *fakeWaaS
+	keys      *keyStore
+	approvals *approvalStore
+	router    *gin.Engine
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	waas := newSeededWaaS()
+	svc := waas.services()
+
+	keys := &keyStore{byHash: map[string]*Identity{}, byClientCert: map[string]*Identity{}}
+	for key, identity := range map[string]*Identity{
+		testAdminKey:     {ID: "admin", Scopes: []string{scopeAll}},
+		testApprover1Key: {ID: "approver-1", Scopes: []string{"approvals:*"}},
+		testApprover2Key: {ID: "approver-2", Scopes: []string{"approvals:*"}},
+		testPool1Key:     {ID: "pool-1", Scopes: []string{scopeOperationsRead}, Pools: []string{"pool-1"}},
+		testPool2Key:     {ID: "pool-2", Scopes: []string{scopeOperationsRead}, Pools: []string{"pool-2"}},
+		testWalletKey:    {ID: "wallet-1", Scopes: []string{scopeAll}, MPCWallets: []string{"pool-1/wallet-1"}},
+	} {
+		keys.byHash[hashKey(key)] = identity
+	}
+
+	approvals, err := newApprovalStore(ctx, filepath.Join(t.TempDir(), "approvals.json"), "native=1000", 2, time.Hour, svc.mpcTransaction.CreateMPCTransaction)
+	if err != nil {
+		t.Fatalf("newApprovalStore() = %v", err)
+	}
+
+	idempotencyResponses, err := newIdempotencyStore(ctx, "", time.Hour)
+	if err != nil {
+		t.Fatalf("newIdempotencyStore() = %v", err)
+	}
+
+	readiness := newReadinessChecker(0, time.Second, upstreamProbes(nil, svc)...)
+
+	router := newRouter(svc, &routerConfig{
+		keys:        keys,
+		approvals:   approvals,
+		idempotency: idempotencyResponses,
+		readiness:   readiness,
+	})
+	return &testProxy{waas: waas, keys: keys, approvals: approvals, router: router}
+}
+
+// addKey authenticates the caller with the given API key as identity.
+func (p *testProxy) addKey(key string, identity *Identity) {
+	p.keys.byHash[hashKey(key)] = identity
+}
+
+// testRequest is a request to the proxy.
+type testRequest struct {
+	method string
+	path   string
+	body   string
+}
+
+// do serves req on behalf of the caller with the given API key.
+func (p *testProxy) do(key string, req testRequest) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(req.method, req.path, strings.NewReader(req.body))
+	if key != "" {
+		r.Header.Set("Authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	p.router.ServeHTTP(w, r)
+	return w
+}
+
+// compactBody returns the body of w without insignificant whitespace, which protojson
+// inserts at random.
+func compactBody(w *httptest.ResponseRecorder) string {
+	var body bytes.Buffer
+	if err := json.Compact(&body, w.Body.Bytes()); err != nil {
+		return w.Body.String()
+	}
+	return body.String()
+}
+
+// checkError fails the test unless w is an error response with the given status and code.
+func checkError(t *testing.T, w *httptest.ResponseRecorder, wantStatus int, wantCode codes.Code) {
+	t.Helper()
+
+	var response errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("cannot parse error response %q: %v", w.Body.String(), err)
+	}
+	if w.Code != wantStatus || response.Code != codeName(wantCode) {
+		t.Errorf("got %d %s (%s), want %d %s", w.Code, response.Code, response.Message, wantStatus, codeName(wantCode))
+	}
+}
+
+// routeTests cover every route calling WaaS: ok succeeds with wantStatus and a body
+// containing wantBody, and bad is rejected with INVALID_ARGUMENT, either by the proxy or
+// by the fakes, as WaaS would.
+var routeTests = []struct {
+	rpc        string
+	ok         testRequest
+	wantStatus int
+	wantBody   string
+	bad        testRequest
+}{
+	{
+		rpc:        "ListNetworks",
+		ok:         testRequest{method: http.MethodGet, path: "/blockchain/v1/networks"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"networks/ethereum-goerli"`,
+		bad:        testRequest{method: http.MethodGet, path: "/blockchain/v1/networks?pageSize=0"},
+	},
+	{
+		rpc:        "GetNetwork",
+		ok:         testRequest{method: http.MethodGet, path: "/blockchain/v1/networks/ethereum-goerli"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"displayName":"Ethereum Goerli"`,
+		bad:        testRequest{method: http.MethodGet, path: "/blockchain/v1/networks/Ethereum_Goerli"},
+	},
+	{
+		rpc:        "ListAssets",
+		ok:         testRequest{method: http.MethodGet, path: "/blockchain/v1/networks/ethereum-goerli/assets"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"networks/ethereum-goerli/assets/eth"`,
+		bad:        testRequest{method: http.MethodGet, path: "/blockchain/v1/networks/ethereum-goerli/assets?pageSize=many"},
+	},
+	{
+		rpc:        "GetAsset",
+		ok:         testRequest{method: http.MethodGet, path: "/blockchain/v1/networks/ethereum-goerli/assets/eth"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"advertisedSymbol":"ETH"`,
+		bad:        testRequest{method: http.MethodGet, path: "/blockchain/v1/networks/ethereum-goerli/assets/ETH"},
+	},
+	{
+		rpc:        "GetMPCKey",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1/mpcKeys/key-1"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"derivationPath":[44,60,0,0,0]`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1/mpcKeys/-"},
+	},
+	{
+		rpc:        "GetDevice",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_keys/v1/devices/device-1"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"devices/device-1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_keys/v1/devices/Device1"},
+	},
+	{
+		rpc:        "GetDeviceGroup",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"devices":["devices/device-1"]`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/Group1"},
+	},
+	{
+		rpc:        "ListMPCOperations",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1/mpcOperations"},
+		wantStatus: http.StatusOK,
+		wantBody:   `{}`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_keys/v1/pools/Pool1/deviceGroups/group-1/mpcOperations"},
+	},
+	{
+		rpc:        "RegisterDevice",
+		ok:         testRequest{method: http.MethodPost, path: "/mpc_keys/v1/device/register", body: `{"registrationData": "cmVnaXN0cmF0aW9u"}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"devices/device-`,
+		bad:        testRequest{method: http.MethodPost, path: "/mpc_keys/v1/device/register", body: `{"registrationData": 42}`},
+	},
+	{
+		rpc:        "CreateMPCKey",
+		ok:         testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1/mpcKeys", body: `{"derivationPath": [44, 60, 0, 0, 1]}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"pools/pool-1/deviceGroups/group-1/mpcKeys/mpc-key-`,
+		bad:        testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1/mpcKeys", body: `{}`},
+	},
+	{
+		rpc:        "CreateSignature",
+		ok:         testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1/mpcKeys/key-1/signatures", body: `{"payload": "cGF5bG9hZA=="}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"kind":"CreateSignature","done":true`,
+		bad:        testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1/mpcKeys/key-1/signatures", body: `{"payloads": "cGF5bG9hZA=="}`},
+	},
+	{
+		rpc:        "CreateDeviceGroup",
+		ok:         testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/pool-1/deviceGroups?deviceGroupId=group-2", body: `{"devices": ["devices/device-1"]}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"pools/pool-1/deviceGroups/group-2"`,
+		bad:        testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/pool-1/deviceGroups"},
+	},
+	{
+		rpc:        "GetMPCTransaction",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_transactions/v1/pools/pool-1/mpcWallets/wallet-1/mpcTransactions/tx-1"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"state":"CONFIRMED"`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_transactions/v1/pools/pool-1/mpcWallets/wallet-1/mpcTransactions/TX"},
+	},
+	{
+		rpc:        "ListMPCTransactions",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_transactions/v1/pools/pool-1/mpcWallets/wallet-1/mpcTransactions"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"pools/pool-1/mpcWallets/wallet-1/mpcTransactions/tx-1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_transactions/v1/pools/pool-1/mpcWallets/wallet-1/mpcTransactions?pageSize=-1"},
+	},
+	{
+		rpc:        "CreateMPCTransaction",
+		ok:         testRequest{method: http.MethodPost, path: "/mpc_transactions/v1/pools/pool-1/mpcWallets/wallet-1/mpcTransactions", body: testTransaction("1")},
+		wantStatus: http.StatusOK,
+		wantBody:   `"kind":"CreateMPCTransaction","done":true`,
+		bad:        testRequest{method: http.MethodPost, path: "/mpc_transactions/v1/pools/pool-1/mpcWallets/wallet-1/mpcTransactions", body: `{"mpcTransaction": `},
+	},
+	{
+		rpc:        "GetMPCWallet",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets/wallet-1"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"deviceGroup":"pools/pool-1/deviceGroups/group-1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets/Wallet1"},
+	},
+	{
+		rpc:        "ListMPCWallets",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"pools/pool-1/mpcWallets/wallet-1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets?all=maybe"},
+	},
+	{
+		rpc:        "GetAddress",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/networks/ethereum-goerli/addresses/address-1"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"mpcWallet":"pools/pool-1/mpcWallets/wallet-1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/networks/ethereum-goerli/addresses/0xAB"},
+	},
+	{
+		rpc:        "ListAddresses",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/networks/ethereum-goerli/addresses?mpcWallet=pools/pool-1/mpcWallets/wallet-1"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"networks/ethereum-goerli/addresses/address-1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/networks/ethereum-goerli/addresses?pageSize=3000000000"},
+	},
+	{
+		rpc:        "ListBalances",
+		ok:         testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/networks/ethereum-goerli/addresses/address-1/balances"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"amount":"1000000000000000000"`,
+		bad:        testRequest{method: http.MethodGet, path: "/mpc_wallets/v1/networks/ethereum-goerli/addresses/address-1/balances?pageSize=0"},
+	},
+	{
+		rpc:        "CreateMPCWallet",
+		ok:         testRequest{method: http.MethodPost, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets?device=devices/device-1", body: `{}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"kind":"CreateMPCWallet","done":true`,
+		bad:        testRequest{method: http.MethodPost, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets?device=device-1", body: `{}`},
+	},
+	{
+		rpc:        "GenerateAddress",
+		ok:         testRequest{method: http.MethodPost, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets/wallet-1/generateAddress", body: `{"network": "networks/ethereum-goerli"}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"mpcWallet":"pools/pool-1/mpcWallets/wallet-1"`,
+		bad:        testRequest{method: http.MethodPost, path: "/mpc_wallets/v1/pools/pool-1/mpcWallets/wallet-1/generateAddress", body: `{"network": "ethereum-goerli"}`},
+	},
+	{
+		rpc:        "GetPool",
+		ok:         testRequest{method: http.MethodGet, path: "/pools/v1/pools/pool-1"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"displayName":"Pool 1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/pools/v1/pools/Pool_1"},
+	},
+	{
+		rpc:        "ListPools",
+		ok:         testRequest{method: http.MethodGet, path: "/pools/v1/pools"},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"pools/pool-1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/pools/v1/pools?pageSize=0"},
+	},
+	{
+		rpc:        "CreatePool",
+		ok:         testRequest{method: http.MethodPost, path: "/pools/v1/pools?poolId=pool-2", body: `{"displayName": "Pool 2"}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"pools/pool-2"`,
+		bad:        testRequest{method: http.MethodPost, path: "/pools/v1/pools?poolId=Pool_2", body: `{"displayName": "Pool 2"}`},
+	},
+	{
+		rpc:        "BroadcastTransaction",
+		ok:         testRequest{method: http.MethodPost, path: "/protocols/v1/networks/ethereum-goerli/broadcastTransaction", body: `{"rawSignedTransaction": "AQID"}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"hash":"0x`,
+		bad:        testRequest{method: http.MethodPost, path: "/protocols/v1/networks/ethereum-goerli/broadcastTransaction", body: `{"input": {}}`},
+	},
+	{
+		rpc:        "ConstructTransaction",
+		ok:         testRequest{method: http.MethodPost, path: "/protocols/v1/networks/ethereum-goerli/constructTransaction", body: testTransferInput("1")},
+		wantStatus: http.StatusOK,
+		wantBody:   `"toAddress":"0x0000000000000000000000000000000000000001"`,
+		bad:        testRequest{method: http.MethodPost, path: "/protocols/v1/networks/ethereum-goerli/constructTransaction", body: `{}`},
+	},
+	{
+		rpc:        "ConstructTransferTransaction",
+		ok:         testRequest{method: http.MethodPost, path: "/protocols/v1/networks/ethereum-goerli/constructTransferTransaction", body: `{"asset": "networks/ethereum-goerli/assets/eth", "recipient": "0x0000000000000000000000000000000000000003", "amount": "5"}`},
+		wantStatus: http.StatusOK,
+		wantBody:   `"toAddress":"0x0000000000000000000000000000000000000003"`,
+		bad:        testRequest{method: http.MethodPost, path: "/protocols/v1/networks/ethereum-goerli/constructTransferTransaction", body: `{"asset": "networks/ethereum-goerli/assets/eth"}`},
+	},
+	{
+		rpc:        "GetOperation",
+		ok:         testRequest{method: http.MethodGet, path: "/operations/" + testDeviceGroupOperation + "?kind=" + operationCreateDeviceGroup},
+		wantStatus: http.StatusOK,
+		wantBody:   `"name":"pools/pool-1/deviceGroups/group-1"`,
+		bad:        testRequest{method: http.MethodGet, path: "/operations/" + testDeviceGroupOperation + "?kind=CreateDevice"},
+	},
+}
+
+func TestRoutes(t *testing.T) {
+	for _, tt := range routeTests {
+		t.Run(tt.rpc, func(t *testing.T) {
+			t.Run("success", func(t *testing.T) {
+				w := newTestProxy(t).do(testAdminKey, tt.ok)
+				if w.Code != tt.wantStatus || !strings.Contains(compactBody(w), tt.wantBody) {
+					t.Errorf("%s %s = %d %s, want %d with %s", tt.ok.method, tt.ok.path, w.Code, w.Body, tt.wantStatus, tt.wantBody)
+				}
+			})
+
+			t.Run("bad request", func(t *testing.T) {
+				w := newTestProxy(t).do(testAdminKey, tt.bad)
+				checkError(t, w, http.StatusBadRequest, codes.InvalidArgument)
+			})
+
+			t.Run("upstream error", func(t *testing.T) {
+				p := newTestProxy(t)
+				p.waas.fail(status.Error(codes.Unavailable, "service unavailable"))
+				w := p.do(testAdminKey, tt.ok)
+				checkError(t, w, http.StatusServiceUnavailable, codes.Unavailable)
+			})
+		})
+	}
+}
+
+func TestRoutesNotFound(t *testing.T) {
+	p := newTestProxy(t)
+	for _, path := range []string{
+		"/blockchain/v1/networks/solana-devnet",
+		"/mpc_wallets/v1/pools/pool-1/mpcWallets/wallet-2",
+		"/operations/operations/unknown-op?kind=" + operationCreateMPCWallet,
+	} {
+		w := p.do(testAdminKey, testRequest{method: http.MethodGet, path: path})
+		checkError(t, w, http.StatusNotFound, codes.NotFound)
+	}
+}
+
+func TestGetOperationKinds(t *testing.T) {
+	p := newTestProxy(t)
+	for kind, name := range map[string]string{
+		operationCreateDeviceGroup:    testDeviceGroupOperation,
+		operationCreateSignature:      testSignatureOperation,
+		operationCreateMPCWallet:      testMPCWalletOperation,
+		operationCreateMPCTransaction: testMPCTransactionOperation,
+	} {
+		w := p.do(testAdminKey, testRequest{method: http.MethodGet, path: "/operations/" + name + "?kind=" + kind})
+
+		var response operationResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("GetOperation(%s) = %d %s: %v", kind, w.Code, w.Body, err)
+		}
+		if w.Code != http.StatusOK || response.Name != name || response.Kind != kind || !response.Done || response.Response == nil {
+			t.Errorf("GetOperation(%s) = %d %s, want the done operation %s", kind, w.Code, w.Body, name)
+		}
+	}
+}
+
+func TestGetOperationAuthorization(t *testing.T) {
+	p := newTestProxy(t)
+	for kind, name := range map[string]string{
+		operationCreateDeviceGroup:    testDeviceGroupOperation,
+		operationCreateSignature:      testSignatureOperation,
+		operationCreateMPCWallet:      testMPCWalletOperation,
+		operationCreateMPCTransaction: testMPCTransactionOperation,
+	} {
+		r := testRequest{method: http.MethodGet, path: "/operations/" + name + "?kind=" + kind}
+		if w := p.do(testPool1Key, r); w.Code != http.StatusOK {
+			t.Errorf("GetOperation(%s) by a pool-1 caller = %d %s, want the done operation", kind, w.Code, w.Body)
+		}
+		checkError(t, p.do(testPool2Key, r), http.StatusNotFound, codes.NotFound)
+	}
+
+	// A pending operation has no result yet, so it is authorized by its metadata.
+	pending := "operations/pending-mpc-transaction-op"
+	p.waas.mpcTransaction.operations.put(newLocalOperation(pending, &mpcTransactions.CreateMPCTransactionMetadata{MpcTransaction: "pools/pool-1/mpcWallets/wallet-1/mpcTransactions/tx-2"},
+		func() (*mpcTransactions.MPCTransaction, bool, error) { return nil, false, nil }))
+	r := testRequest{method: http.MethodGet, path: "/operations/" + pending + "?kind=" + operationCreateMPCTransaction}
+	if w := p.do(testPool1Key, r); w.Code != http.StatusAccepted {
+		t.Errorf("GetOperation(pending) by a pool-1 caller = %d %s, want the pending operation", w.Code, w.Body)
+	}
+	checkError(t, p.do(testPool2Key, r), http.StatusNotFound, codes.NotFound)
+}
+
+func TestWalletGrant(t *testing.T) {
+	// A caller restricted to a wallet may only act on the wallet, never on its pool.
+	denied := map[string]int{
+		"GetMPCKey":         http.StatusForbidden,
+		"GetDeviceGroup":    http.StatusForbidden,
+		"ListMPCOperations": http.StatusForbidden,
+		"CreateMPCKey":      http.StatusForbidden,
+		"CreateSignature":   http.StatusForbidden,
+		"CreateDeviceGroup": http.StatusForbidden,
+		"CreateMPCWallet":   http.StatusForbidden,
+		"GetPool":           http.StatusForbidden,
+		"CreatePool":        http.StatusForbidden,
+		"GetOperation":      http.StatusNotFound,
+	}
+	for _, tt := range routeTests {
+		t.Run(tt.rpc, func(t *testing.T) {
+			w := newTestProxy(t).do(testWalletKey, tt.ok)
+			switch {
+			case denied[tt.rpc] != 0:
+				if w.Code != denied[tt.rpc] {
+					t.Errorf("%s %s = %d %s, want %d", tt.ok.method, tt.ok.path, w.Code, w.Body, denied[tt.rpc])
+				}
+			case tt.rpc == "ListPools":
+				if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "pools/pool-1") {
+					t.Errorf("%s %s = %d %s, want no pools", tt.ok.method, tt.ok.path, w.Code, w.Body)
+				}
+			case w.Code != tt.wantStatus || !strings.Contains(compactBody(w), tt.wantBody):
+				t.Errorf("%s %s = %d %s, want %d with %s", tt.ok.method, tt.ok.path, w.Code, w.Body, tt.wantStatus, tt.wantBody)
+			}
+		})
+	}
+
+	p := newTestProxy(t)
+	for _, path := range []string{
+		"/mpc_wallets/v1/pools/pool-1/mpcWallets/wallet-2",
+		"/mpc_wallets/v1/pools/pool-2/mpcWallets/wallet-1",
+	} {
+		checkError(t, p.do(testWalletKey, testRequest{method: http.MethodGet, path: path}), http.StatusForbidden, codes.PermissionDenied)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	p := newTestProxy(t)
+	p.waas.pool.pools.put(&pools.Pool{Name: "pools/pool-2"})
+	p.waas.pool.pools.put(&pools.Pool{Name: "pools/pool-3"})
+
+	var page pools.ListPoolsResponse
+	w := p.do(testAdminKey, testRequest{method: http.MethodGet, path: "/pools/v1/pools?pageSize=2"})
+	if err := unmarshalOptions.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("ListPools() = %d %s: %v", w.Code, w.Body, err)
+	}
+	if len(page.Pools) != 2 || page.NextPageToken == "" {
+		t.Fatalf("ListPools(pageSize=2) = %s, want 2 pools and a next page token", w.Body)
+	}
+
+	w = p.do(testAdminKey, testRequest{method: http.MethodGet, path: "/pools/v1/pools?pageSize=2&pageToken=" + page.NextPageToken})
+	if err := unmarshalOptions.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("ListPools() = %d %s: %v", w.Code, w.Body, err)
+	}
+	if len(page.Pools) != 1 || page.Pools[0].Name != "pools/pool-3" || page.NextPageToken != "" {
+		t.Errorf("ListPools(pageToken=%s) = %s, want the last pool only", page.NextPageToken, w.Body)
+	}
+
+	w = p.do(testAdminKey, testRequest{method: http.MethodGet, path: "/pools/v1/pools?pageSize=1&all=true"})
+	if err := unmarshalOptions.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("ListPools() = %d %s: %v", w.Code, w.Body, err)
+	}
+	if len(page.Pools) != 3 {
+		t.Errorf("ListPools(all=true) = %s, want 3 pools", w.Body)
+	}
+}
+
+func TestListStreaming(t *testing.T) {
+	p := newTestProxy(t)
+	r := testRequest{method: http.MethodGet, path: "/blockchain/v1/networks/ethereum-goerli/assets?stream=true"}
+
+	w := p.do(testAdminKey, r)
+	if w.Code != http.StatusOK || compactBody(w) != `[{"name":"networks/ethereum-goerli/assets/eth","advertisedSymbol":"ETH","decimals":18}]` {
+		t.Errorf("ListAssets(stream=true) = %d %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get(trailerStreamStatus); got != codeName(codes.OK) {
+		t.Errorf("%s = %q, want OK", trailerStreamStatus, got)
+	}
+
+	p.waas.fail(status.Error(codes.Unavailable, "service unavailable"))
+	checkError(t, p.do(testAdminKey, r), http.StatusServiceUnavailable, codes.Unavailable)
+}
+
+func TestApprovalRoutes(t *testing.T) {
+	p := newTestProxy(t)
+	create := testRequest{method: http.MethodPost, path: "/mpc_transactions/v1/pools/pool-1/mpcWallets/wallet-1/mpcTransactions", body: testTransaction("5000")}
+
+	// newPendingTransaction requests a transfer above the threshold.
+	newPendingTransaction := func() string {
+		w := p.do(testAdminKey, create)
+		var pendingTx pendingTransaction
+		if err := json.Unmarshal(w.Body.Bytes(), &pendingTx); err != nil || w.Code != http.StatusAccepted || pendingTx.State != approvalPending {
+			t.Fatalf("CreateMPCTransaction() = %d %s, want a pending transaction", w.Code, w.Body)
+		}
+		return "/approvals/v1/" + pendingTx.Name
+	}
+	review := func(key, path, body string) (*httptest.ResponseRecorder, *pendingTransaction) {
+		w := p.do(key, testRequest{method: http.MethodPost, path: path, body: body})
+		pendingTx := &pendingTransaction{}
+		json.Unmarshal(w.Body.Bytes(), pendingTx)
+		return w, pendingTx
+	}
+
+	t.Run("list and get", func(t *testing.T) {
+		path := newPendingTransaction()
+
+		w := p.do(testApprover1Key, testRequest{method: http.MethodGet, path: "/approvals/v1/pendingTransactions?state=" + approvalPending})
+		if w.Code != http.StatusOK || !strings.Contains(compactBody(w), strings.TrimPrefix(path, "/approvals/v1/")) {
+			t.Errorf("ListPendingTransactions() = %d %s, want %s", w.Code, w.Body, path)
+		}
+
+		w = p.do(testApprover1Key, testRequest{method: http.MethodGet, path: path})
+		if w.Code != http.StatusOK || !strings.Contains(compactBody(w), `"amount":"5000"`) {
+			t.Errorf("GetPendingTransaction() = %d %s", w.Code, w.Body)
+		}
+
+		w = p.do(testApprover1Key, testRequest{method: http.MethodGet, path: "/approvals/v1/pendingTransactions/unknown"})
+		checkError(t, w, http.StatusNotFound, codes.NotFound)
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		path := newPendingTransaction()
+
+		w, _ := review(testApprover1Key, path+"/approve", `{"comment": `)
+		checkError(t, w, http.StatusBadRequest, codes.InvalidArgument)
+
+		w, pendingTx := review(testApprover1Key, path+"/approve", `{"comment": "ok"}`)
+		if w.Code != http.StatusOK || pendingTx.State != approvalPending || len(pendingTx.Approvals) != 1 {
+			t.Fatalf("ApprovePendingTransaction() = %d %s, want one approval", w.Code, w.Body)
+		}
+
+		w, _ = review(testApprover2Key, path+"/approve", "")
+		if w.Code != http.StatusOK || !strings.Contains(compactBody(w), `"kind":"CreateMPCTransaction","done":true`) {
+			t.Fatalf("ApprovePendingTransaction() = %d %s, want the submitted operation", w.Code, w.Body)
+		}
+
+		w = p.do(testApprover1Key, testRequest{method: http.MethodGet, path: path})
+		if !strings.Contains(compactBody(w), `"state":"`+approvalSubmitted+`"`) {
+			t.Errorf("GetPendingTransaction() = %s, want it submitted", w.Body)
+		}
+	})
+
+	t.Run("approve upstream error", func(t *testing.T) {
+		path := newPendingTransaction()
+		review(testApprover1Key, path+"/approve", "")
+
+		p.waas.fail(status.Error(codes.Unavailable, "service unavailable"))
+		defer p.waas.fail(nil)
+
+		w, _ := review(testApprover2Key, path+"/approve", "")
+		checkError(t, w, http.StatusServiceUnavailable, codes.Unavailable)
+
+		w = p.do(testApprover1Key, testRequest{method: http.MethodGet, path: path})
+		if !strings.Contains(compactBody(w), `"state":"`+approvalApproved+`"`) {
+			t.Errorf("GetPendingTransaction() = %s, want it approved", w.Body)
+		}
+
+		// Approving it again retries the submission.
+		p.waas.fail(nil)
+		w, _ = review(testApprover1Key, path+"/approve", "")
+		if w.Code != http.StatusOK || !strings.Contains(compactBody(w), `"done":true`) {
+			t.Errorf("ApprovePendingTransaction() retry = %d %s, want the operation", w.Code, w.Body)
+		}
+
+		p.waas.fail(status.Error(codes.InvalidArgument, "invalid transaction"))
+		path = newPendingTransaction()
+		review(testApprover1Key, path+"/approve", "")
+		review(testApprover2Key, path+"/approve", "")
+		w = p.do(testApprover1Key, testRequest{method: http.MethodGet, path: path})
+		if !strings.Contains(compactBody(w), `"state":"`+approvalFailed+`"`) {
+			t.Errorf("GetPendingTransaction() = %s, want it failed", w.Body)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		path := newPendingTransaction()
+
+		w, _ := review(testApprover1Key, path+"/reject", `[]`)
+		checkError(t, w, http.StatusBadRequest, codes.InvalidArgument)
+
+		w, pendingTx := review(testApprover1Key, path+"/reject", `{"comment": "too much"}`)
+		if w.Code != http.StatusOK || pendingTx.State != approvalRejected {
+			t.Fatalf("RejectPendingTransaction() = %d %s, want it rejected", w.Code, w.Body)
+		}
+
+		w, _ = review(testApprover2Key, path+"/approve", "")
+		checkError(t, w, http.StatusBadRequest, codes.FailedPrecondition)
+	})
+}
+
+func TestSubmitApprovedContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var submitted context.Context
+	var submittedErr error
+	submit := func(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error) {
+		submitted, submittedErr = ctx, ctx.Err()
+		return nil, status.Error(codes.Unavailable, "service unavailable")
+	}
+	approvals, err := newApprovalStore(ctx, "", "native=1000", 2, time.Hour, submit)
+	if err != nil {
+		t.Fatalf("newApprovalStore() = %v", err)
+	}
+
+	// The approver's request is already cancelled; the submission must not be.
+	parent, cancelParent := context.WithCancel(metadata.AppendToOutgoingContext(ctx, requestIDMetadataKey, "request-1"))
+	cancelParent()
+	approvals.submitApproved(parent, "pendingTransactions/tx-1", &mpcTransactions.CreateMPCTransactionRequest{})
+
+	if submittedErr != nil {
+		t.Errorf("submission context error = %v, want it detached from the approver's request", submittedErr)
+	}
+	if deadline, ok := submitted.Deadline(); !ok || time.Until(deadline) <= defaultMutationTimeout {
+		t.Errorf("submission deadline = %v, want the %v CreateMPCTransaction timeout", deadline, routeTimeout(operationCreateMPCTransaction))
+	}
+	if md, _ := metadata.FromOutgoingContext(submitted); len(md.Get(requestIDMetadataKey)) != 1 || md.Get(requestIDMetadataKey)[0] != "request-1" {
+		t.Errorf("submission metadata = %v, want the approver's request ID", md)
+	}
+	if rpc := rpcFromContext(submitted); rpc != operationCreateMPCTransaction {
+		t.Errorf("submission RPC = %q, want %q", rpc, operationCreateMPCTransaction)
+	}
+}
+
+func TestHealthRoutes(t *testing.T) {
+	p := newTestProxy(t)
+
+	if w := p.do("", testRequest{method: http.MethodGet, path: "/healthz"}); w.Code != http.StatusOK {
+		t.Errorf("/healthz = %d %s", w.Code, w.Body)
+	}
+
+	w := p.do("", testRequest{method: http.MethodGet, path: "/readyz"})
+	if w.Code != http.StatusOK || !strings.Contains(compactBody(w), `"status":"`+readinessReady+`"`) {
+		t.Errorf("/readyz = %d %s, want ready", w.Code, w.Body)
+	}
+
+	for _, err := range []error{
+		status.Error(codes.Unavailable, "service unavailable"),
+		status.Error(codes.PermissionDenied, "API key revoked"),
+	} {
+		p.waas.fail(err)
+		w = p.do("", testRequest{method: http.MethodGet, path: "/readyz"})
+		if w.Code != http.StatusServiceUnavailable || !strings.Contains(compactBody(w), `"status":"`+readinessNotReady+`"`) {
+			t.Errorf("/readyz with upstream error %v = %d %s, want not ready", err, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	p := newTestProxy(t)
+	r := testRequest{method: http.MethodGet, path: "/pools/v1/pools/pool-1"}
+
+	for _, key := range []string{"", "wrong-key", hashKey(testAdminKey), strings.ToUpper(testAdminKey)} {
+		checkError(t, p.do(key, r), http.StatusUnauthorized, codes.Unauthenticated)
+	}
+	checkError(t, p.do(testApprover1Key, r), http.StatusForbidden, codes.PermissionDenied)
+}
+
+// routeScopes are the scopes required by the routes of routeTests.
+var routeScopes = map[string]string{
+	"ListNetworks":                 scopeBlockchainRead,
+	"GetNetwork":                   scopeBlockchainRead,
+	"ListAssets":                   scopeBlockchainRead,
+	"GetAsset":                     scopeBlockchainRead,
+	"GetMPCKey":                    scopeKeysRead,
+	"GetDevice":                    scopeKeysRead,
+	"GetDeviceGroup":               scopeKeysRead,
+	"ListMPCOperations":            scopeKeysRead,
+	"RegisterDevice":               scopeDevicesCreate,
+	"CreateMPCKey":                 scopeKeysCreate,
+	"CreateSignature":              scopeSignaturesCreate,
+	"CreateDeviceGroup":            scopeKeysCreate,
+	"GetMPCTransaction":            scopeTransactionsRead,
+	"ListMPCTransactions":          scopeTransactionsRead,
+	"CreateMPCTransaction":         scopeTransactionsCreate,
+	"GetMPCWallet":                 scopeWalletsRead,
+	"ListMPCWallets":               scopeWalletsRead,
+	"GetAddress":                   scopeWalletsRead,
+	"ListAddresses":                scopeWalletsRead,
+	"ListBalances":                 scopeWalletsRead,
+	"CreateMPCWallet":              scopeWalletsCreate,
+	"GenerateAddress":              scopeWalletsCreate,
+	"GetPool":                      scopePoolsRead,
+	"ListPools":                    scopePoolsRead,
+	"CreatePool":                   scopePoolsAdmin,
+	"BroadcastTransaction":         scopeTransactionsBroadcast,
+	"ConstructTransaction":         scopeTransactionsConstruct,
+	"ConstructTransferTransaction": scopeTransactionsConstruct,
+	"GetOperation":                 scopeOperationsRead,
+}
+
+func TestRouteScopes(t *testing.T) {
+	for _, tt := range routeTests {
+		scope, ok := routeScopes[tt.rpc]
+		if !ok {
+			t.Fatalf("no scope listed for %s", tt.rpc)
+		}
+		resource, _, _ := strings.Cut(scope, ":")
+
+		var others []string
+		for _, other := range routeScopes {
+			if other != scope {
+				others = append(others, other)
+			}
+		}
+
+		t.Run(tt.rpc, func(t *testing.T) {
+			for _, granted := range [][]string{{scope}, {resource + ":*"}, {scopeAll}} {
+				p := newTestProxy(t)
+				p.addKey("scoped-key", &Identity{ID: "scoped", Scopes: granted})
+				if w := p.do("scoped-key", tt.ok); w.Code != tt.wantStatus {
+					t.Errorf("%s %s with scopes %v = %d %s, want %d", tt.ok.method, tt.ok.path, granted, w.Code, w.Body, tt.wantStatus)
+				}
+			}
+
+			// Every other scope, including those of the same resource, is not enough.
+			p := newTestProxy(t)
+			p.addKey("other-key", &Identity{ID: "other", Scopes: others})
+			checkError(t, p.do("other-key", tt.ok), http.StatusForbidden, codes.PermissionDenied)
+		})
+	}
+}
+
+func TestAuditRequestBodyLimit(t *testing.T) {
+	defer func(limit int64) { *maxRequestBody = limit }(*maxRequestBody)
+	*maxRequestBody = 64
+
+	auditTrail, err := openAuditLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("openAuditLog() = %v", err)
+	}
+	waas := newSeededWaaS()
+	p := &testProxy{waas: waas, router: newRouter(waas.services(), &routerConfig{auditTrail: auditTrail})}
+
+	p.call(t, testRequest{method: http.MethodPost, path: "/pools/v1/pools?poolId=pool-2", body: `{"displayName": "Pool 2"}`}, http.StatusOK, nil)
+	w := p.do("", testRequest{method: http.MethodPost, path: "/pools/v1/pools?poolId=pool-3", body: `{"displayName": "` + strings.Repeat("x", 64) + `"}`})
+	checkError(t, w, http.StatusRequestEntityTooLarge, codes.InvalidArgument)
+}
+
+func TestIdempotencyRequestBodyLimit(t *testing.T) {
+	defer func(limit int64) { *maxRequestBody = limit }(*maxRequestBody)
+	*maxRequestBody = 64
+
+	p := newTestProxy(t)
+	r := testRequest{method: http.MethodPost, path: "/pools/v1/pools?poolId=pool-2", body: `{"displayName": "` + strings.Repeat("x", 64) + `"}`}
+	req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	req.Header.Set(idempotencyKeyHeader, "key-1")
+	w := httptest.NewRecorder()
+	p.router.ServeHTTP(w, req)
+	checkError(t, w, http.StatusRequestEntityTooLarge, codes.InvalidArgument)
+}
+
+// newPolicyProxy returns a router without authentication over the seeded fakes, enforcing
+// the given policy file.
+func newPolicyProxy(t *testing.T, policy string) *testProxy {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	engine, err := newPolicyEngine(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("newPolicyEngine() = %v", err)
+	}
+
+	waas := newSeededWaaS()
+	return &testProxy{waas: waas, router: newRouter(waas.services(), &routerConfig{policy: engine})}
+}
+
+// checkPolicyDenial fails the test unless w denies a request by the given constraint, or
+// succeeds if constraint is empty.
+func checkPolicyDenial(t *testing.T, w *httptest.ResponseRecorder, constraint string) {
+	t.Helper()
+
+	if constraint == "" {
+		if w.Code != http.StatusOK {
+			t.Errorf("got %d %s, want the request allowed", w.Code, w.Body)
+		}
+		return
+	}
+	var response errorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || w.Code != http.StatusForbidden ||
+		len(response.Details) != 1 || response.Details[0].Metadata["constraint"] != constraint {
+		t.Errorf("got %d %s, want a denial by %s", w.Code, w.Body, constraint)
+	}
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	createSignature := testRequest{method: http.MethodPost, path: "/mpc_keys/v1/pools/pool-1/deviceGroups/group-1/mpcKeys/key-1/signatures", body: `{"payload": "cGF5bG9hZA=="}`}
+
+	for _, tt := range []struct {
+		name           string
+		rule           string
+		wantConstraint string
+	}{
+		{"deny signatures", `denySignatures: true`, "denySignatures"},
+		{"max amounts", `maxAmounts: {native: "1000"}`, "input"},
+		{"recipients", `recipients: {deny: ["0x0000000000000000000000000000000000000001"]}`, "input"},
+		{"max fee", `maxFee: "1000"`, "input"},
+		{"contract calls", `contractCalls: deny`, "input"},
+		{"networks", `networks: ["ethereum-goerli"]`, "networks"},
+		{"other pool", `pools: ["pool-2"], maxFee: "1000"`, ""},
+		{"key's wallet", `mpcWallets: ["wallet-*"], maxFee: "1000"`, "input"},
+		{"other wallet", `mpcWallets: ["wallet-2"], maxFee: "1000"`, ""},
+		{"no constraint", `pools: ["pool-1"]`, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPolicyProxy(t, "rules: [{name: rule, "+tt.rule+"}]")
+			checkPolicyDenial(t, p.do("", createSignature), tt.wantConstraint)
+		})
+	}
+}
+
+func TestTransactionPolicyInputs(t *testing.T) {
+	p := newPolicyProxy(t, `rules: [{name: limits, maxAmounts: {native: "1000"}}]`)
+	path := "/mpc_transactions/v1/pools/pool-1/mpcWallets/wallet-1/mpcTransactions"
+	withEmbeddedInput := func(input, embedded string) string {
+		return `{"mpcTransaction": {"network": "networks/ethereum-goerli", "transaction": {"input": ` + testTransferInput(embedded) + `}}, "input": ` + testTransferInput(input) + `}`
+	}
+
+	checkPolicyDenial(t, p.do("", testRequest{method: http.MethodPost, path: path, body: withEmbeddedInput("1", "1")}), "")
+	checkPolicyDenial(t, p.do("", testRequest{method: http.MethodPost, path: path, body: withEmbeddedInput("1", "5000")}), "input")
+	checkPolicyDenial(t, p.do("", testRequest{method: http.MethodPost, path: path, body: withEmbeddedInput("5000", "1")}), "input")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coinbase/waas-client-library-go/auth"
+	v1clients "github.com/coinbase/waas-client-library-go/clients/v1"
+	blockchain "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/blockchain/v1"
+	mpcKeys "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_keys/v1"
+	mpcTransactions "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_transactions/v1"
+	mpcWallet "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/mpc_wallets/v1"
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	protocols "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/protocols/v1"
+	v1types "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/types/v1"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// The long-running operations started by the WaaS services.
+type (
+	createDeviceGroupOperation    = longRunningOperation[*mpcKeys.DeviceGroup, *mpcKeys.CreateDeviceGroupMetadata]
+	createSignatureOperation      = longRunningOperation[*mpcKeys.Signature, *mpcKeys.CreateSignatureMetadata]
+	createMPCTransactionOperation = longRunningOperation[*mpcTransactions.MPCTransaction, *mpcTransactions.CreateMPCTransactionMetadata]
+	createMPCWalletOperation      = longRunningOperation[*mpcWallet.MPCWallet, *mpcWallet.CreateMPCWalletMetadata]
+)
+
+// blockchainAPI is the part of the Blockchain service called by the proxy.
+type blockchainAPI interface {
+	GetNetwork(ctx context.Context, req *blockchain.GetNetworkRequest, opts ...gax.CallOption) (*blockchain.Network, error)
+	ListNetworks(ctx context.Context, req *blockchain.ListNetworksRequest, opts ...gax.CallOption) v1clients.NetworkIterator
+	GetAsset(ctx context.Context, req *blockchain.GetAssetRequest, opts ...gax.CallOption) (*blockchain.Asset, error)
+	ListAssets(ctx context.Context, req *blockchain.ListAssetsRequest, opts ...gax.CallOption) v1clients.AssetIterator
+}
+
+// mpcKeyAPI is the part of the MPC Key service called by the proxy. The device backup and
+// recovery RPCs (PrepareDeviceArchive, PrepareDeviceBackup and AddDevice) are missing from
+// the pinned client library, so they cannot be proxied yet.
+type mpcKeyAPI interface {
+	RegisterDevice(ctx context.Context, req *mpcKeys.RegisterDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error)
+	GetDevice(ctx context.Context, req *mpcKeys.GetDeviceRequest, opts ...gax.CallOption) (*mpcKeys.Device, error)
+	CreateDeviceGroup(ctx context.Context, req *mpcKeys.CreateDeviceGroupRequest, opts ...gax.CallOption) (createDeviceGroupOperation, error)
+	CreateDeviceGroupOperation(name string) createDeviceGroupOperation
+	GetDeviceGroup(ctx context.Context, req *mpcKeys.GetDeviceGroupRequest, opts ...gax.CallOption) (*mpcKeys.DeviceGroup, error)
+	ListMPCOperations(ctx context.Context, req *mpcKeys.ListMPCOperationsRequest, opts ...gax.CallOption) (*mpcKeys.ListMPCOperationsResponse, error)
+	CreateMPCKey(ctx context.Context, req *mpcKeys.CreateMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error)
+	GetMPCKey(ctx context.Context, req *mpcKeys.GetMPCKeyRequest, opts ...gax.CallOption) (*mpcKeys.MPCKey, error)
+	CreateSignature(ctx context.Context, req *mpcKeys.CreateSignatureRequest, opts ...gax.CallOption) (createSignatureOperation, error)
+	CreateSignatureOperation(name string) createSignatureOperation
+}
+
+// mpcTransactionAPI is the part of the MPC Transaction service called by the proxy.
+type mpcTransactionAPI interface {
+	CreateMPCTransaction(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error)
+	CreateMPCTransactionOperation(name string) createMPCTransactionOperation
+	GetMPCTransaction(ctx context.Context, req *mpcTransactions.GetMPCTransactionRequest, opts ...gax.CallOption) (*mpcTransactions.MPCTransaction, error)
+	ListMPCTransactions(ctx context.Context, req *mpcTransactions.ListMPCTransactionsRequest, opts ...gax.CallOption) v1clients.MPCTransactionIterator
+}
+
+// mpcWalletAPI is the part of the MPC Wallet service called by the proxy.
+type mpcWalletAPI interface {
+	CreateMPCWallet(ctx context.Context, req *mpcWallet.CreateMPCWalletRequest, opts ...gax.CallOption) (createMPCWalletOperation, error)
+	CreateMPCWalletOperation(name string) createMPCWalletOperation
+	GetMPCWallet(ctx context.Context, req *mpcWallet.GetMPCWalletRequest, opts ...gax.CallOption) (*mpcWallet.MPCWallet, error)
+	ListMPCWallets(ctx context.Context, req *mpcWallet.ListMPCWalletsRequest, opts ...gax.CallOption) v1clients.MPCWalletIterator
+	GenerateAddress(ctx context.Context, req *mpcWallet.GenerateAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error)
+	GetAddress(ctx context.Context, req *mpcWallet.GetAddressRequest, opts ...gax.CallOption) (*mpcWallet.Address, error)
+	ListAddresses(ctx context.Context, req *mpcWallet.ListAddressesRequest, opts ...gax.CallOption) v1clients.AddressIterator
+	ListBalances(ctx context.Context, req *mpcWallet.ListBalancesRequest, opts ...gax.CallOption) v1clients.BalanceIterator
+}
+
+// poolAPI is the part of the Pool service called by the proxy.
+type poolAPI interface {
+	CreatePool(ctx context.Context, req *pools.CreatePoolRequest, opts ...gax.CallOption) (*pools.Pool, error)
+	GetPool(ctx context.Context, req *pools.GetPoolRequest, opts ...gax.CallOption) (*pools.Pool, error)
+	ListPools(ctx context.Context, req *pools.ListPoolsRequest, opts ...gax.CallOption) v1clients.PoolIterator
+}
+
+// protocolAPI is the part of the Protocol service called by the proxy. The pinned client
+// library has no fee estimation RPC, so fees cannot be estimated yet.
+type protocolAPI interface {
+	ConstructTransaction(ctx context.Context, req *protocols.ConstructTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error)
+	ConstructTransferTransaction(ctx context.Context, req *protocols.ConstructTransferTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error)
+	BroadcastTransaction(ctx context.Context, req *protocols.BroadcastTransactionRequest, opts ...gax.CallOption) (*v1types.Transaction, error)
+}
+
+// services are the WaaS services behind the proxy's routes.
+type services struct {
+	blockchain     blockchainAPI
+	mpcKey         mpcKeyAPI
+	mpcTransaction mpcTransactionAPI
+	mpcWallet      mpcWalletAPI
+	pool           poolAPI
+	protocol       protocolAPI
+}
+
+// newUpstreamServices returns the clients of the WaaS services, authenticating with apiKey.
+func newUpstreamServices(ctx context.Context, apiKey *auth.APIKey) (*services, error) {
+	blockchainClient, err := v1clients.NewBlockchainServiceClient(ctx, upstreamOption(blockchainService, apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot instantiate BlockchainServiceClient: %v", err)
+	}
+
+	mpcKeyClient, err := v1clients.NewMPCKeyServiceClient(ctx, upstreamOption(mpcKeyService, apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot instantiate MPCKeyServiceClient: %v", err)
+	}
+
+	mpcTransactionClient, err := v1clients.NewMPCTransactionServiceClient(ctx, upstreamOption(mpcTransactionService, apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot instantiate MPCTransactionServiceClient: %v", err)
+	}
+
+	mpcWalletClient, err := v1clients.NewMPCWalletServiceClient(ctx, upstreamOption(mpcWalletService, apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot instantiate MPCWalletServiceClient: %v", err)
+	}
+
+	poolClient, err := v1clients.NewPoolServiceClient(ctx, upstreamOption(poolService, apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot instantiate PoolServiceClient: %v", err)
+	}
+
+	protocolClient, err := v1clients.NewProtocolServiceClient(ctx, upstreamOption(protocolService, apiKey))
+	if err != nil {
+		return nil, fmt.Errorf("cannot instantiate ProtocolServiceClient: %v", err)
+	}
+
+	return &services{
+		blockchain:     blockchainClient,
+		mpcKey:         mpcKeyAdapter{mpcKeyClient},
+		mpcTransaction: mpcTransactionAdapter{mpcTransactionClient},
+		mpcWallet:      mpcWalletAdapter{mpcWalletClient},
+		pool:           poolClient,
+		protocol:       protocolClient,
+	}, nil
+}
+
+// The v1clients return their long-running operations as concrete types, so the clients
+// of the services starting operations are adapted to return them as interfaces.
+
+// mpcKeyAdapter adapts a v1clients.MPCKeyServiceClient to mpcKeyAPI.
+type mpcKeyAdapter struct {
+	*v1clients.MPCKeyServiceClient
+}
+
+func (c mpcKeyAdapter) CreateDeviceGroup(ctx context.Context, req *mpcKeys.CreateDeviceGroupRequest, opts ...gax.CallOption) (createDeviceGroupOperation, error) {
+	op, err := c.MPCKeyServiceClient.CreateDeviceGroup(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (c mpcKeyAdapter) CreateDeviceGroupOperation(name string) createDeviceGroupOperation {
+	return c.MPCKeyServiceClient.CreateDeviceGroupOperation(name)
+}
+
+func (c mpcKeyAdapter) CreateSignature(ctx context.Context, req *mpcKeys.CreateSignatureRequest, opts ...gax.CallOption) (createSignatureOperation, error) {
+	op, err := c.MPCKeyServiceClient.CreateSignature(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (c mpcKeyAdapter) CreateSignatureOperation(name string) createSignatureOperation {
+	return c.MPCKeyServiceClient.CreateSignatureOperation(name)
+}
+
+// mpcTransactionAdapter adapts a v1clients.MPCTransactionServiceClient to mpcTransactionAPI.
+type mpcTransactionAdapter struct {
+	*v1clients.MPCTransactionServiceClient
+}
+
+func (c mpcTransactionAdapter) CreateMPCTransaction(ctx context.Context, req *mpcTransactions.CreateMPCTransactionRequest, opts ...gax.CallOption) (createMPCTransactionOperation, error) {
+	op, err := c.MPCTransactionServiceClient.CreateMPCTransaction(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (c mpcTransactionAdapter) CreateMPCTransactionOperation(name string) createMPCTransactionOperation {
+	return c.MPCTransactionServiceClient.CreateMPCTransactionOperation(name)
+}
+
+// mpcWalletAdapter adapts a v1clients.MPCWalletServiceClient to mpcWalletAPI.
+type mpcWalletAdapter struct {
+	*v1clients.MPCWalletServiceClient
+}
+
+func (c mpcWalletAdapter) CreateMPCWallet(ctx context.Context, req *mpcWallet.CreateMPCWalletRequest, opts ...gax.CallOption) (createMPCWalletOperation, error) {
+	op, err := c.MPCWalletServiceClient.CreateMPCWallet(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (c mpcWalletAdapter) CreateMPCWalletOperation(name string) createMPCWalletOperation {
+	return c.MPCWalletServiceClient.CreateMPCWalletOperation(name)
+}
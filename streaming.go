@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+
+	// Trailers sent after a streamed List response, as the status code is already
+	// committed by the time a mid-stream error occurs.
+	trailerStreamStatus = "X-Stream-Status"
+	trailerStreamError  = "X-Stream-Error"
+	trailerStreamCount  = "X-Stream-Count"
+)
+
+// Supported streaming formats for List routes.
+const (
+	streamNone = iota
+	streamNDJSON
+	streamJSONArray
+)
+
+// itemIterator is the Next method shared by the v1clients List iterators.
+type itemIterator[T proto.Message] interface {
+	Next() (T, error)
+}
+
+// streamFormat returns the streaming format requested for a List route: NDJSON when the
+// Accept header asks for it, a chunked JSON array with ?stream=true, or streamNone.
+func streamFormat(c *gin.Context) int {
+	if strings.Contains(c.GetHeader("Accept"), contentTypeNDJSON) {
+		return streamNDJSON
+	}
+	if stream, _ := strconv.ParseBool(c.Query("stream")); stream {
+		return streamJSONArray
+	}
+	return streamNone
+}
+
+// streamList writes every item from iter as it arrives, flushing after each one. Errors
+// before the first item are reported as a regular error response. Errors after that are
+// reported in the X-Stream-Status and X-Stream-Error trailers; in NDJSON mode a final
+// {"error": ...} line is written too, and in JSON array mode the array is left open so
+// that the truncated body fails to parse.
+func streamList[T proto.Message](c *gin.Context, format int, iter itemIterator[T]) {
+	opts := marshalOptions()
+
+	count := 0
+	for {
+		item, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+
+		var data []byte
+		if err == nil {
+			data, err = opts.Marshal(item)
+		}
+		if err != nil {
+			if count == 0 {
+				writeError(c, err)
+				return
+			}
+			failStream(c, format, count, err)
+			return
+		}
+
+		if count == 0 {
+			startStream(c, format)
+		} else if format == streamJSONArray {
+			c.Writer.WriteString(",")
+		}
+		c.Writer.Write(data)
+		if format == streamNDJSON {
+			c.Writer.WriteString("\n")
+		}
+		c.Writer.Flush()
+		count++
+	}
+
+	if count == 0 {
+		startStream(c, format)
+	}
+	if format == streamJSONArray {
+		c.Writer.WriteString("]")
+	}
+	c.Writer.Header().Set(trailerStreamStatus, codeName(codes.OK))
+	c.Writer.Header().Set(trailerStreamCount, strconv.Itoa(count))
+}
+
+// startStream commits the headers of a streamed List response.
+func startStream(c *gin.Context, format int) {
+	c.Header("Trailer", strings.Join([]string{trailerStreamStatus, trailerStreamError, trailerStreamCount}, ", "))
+	if format == streamNDJSON {
+		c.Header("Content-Type", contentTypeNDJSON)
+	} else {
+		c.Header("Content-Type", contentTypeJSON)
+	}
+	c.Status(http.StatusOK)
+	if format == streamJSONArray {
+		c.Writer.WriteString("[")
+	}
+}
+
+// failStream reports an error that occurred after count items were streamed.
+func failStream(c *gin.Context, format int, count int, err error) {
+	_, response := translateError(err)
+	if format == streamNDJSON {
+		line, _ := json.Marshal(gin.H{"error": response})
+		c.Writer.Write(append(line, '\n'))
+	}
+	c.Writer.Header().Set(trailerStreamStatus, response.Code)
+	c.Writer.Header().Set(trailerStreamError, response.Message)
+	c.Writer.Header().Set(trailerStreamCount, strconv.Itoa(count))
+}
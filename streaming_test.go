@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pools "github.com/coinbase/waas-client-library-go/gen/go/coinbase/cloud/pools/v1"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// poolIterator returns the named pools, then err if set.
+type poolIterator struct {
+	names []string
+	err   error
+}
+
+func (it *poolIterator) Next() (*pools.Pool, error) {
+	if len(it.names) == 0 {
+		if it.err != nil {
+			return nil, it.err
+		}
+		return nil, iterator.Done
+	}
+	pool := &pools.Pool{Name: it.names[0]}
+	it.names = it.names[1:]
+	return pool, nil
+}
+
+// serveStream streams the items of iter in format, returning the recorded response.
+func serveStream(format int, iter *poolIterator) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	streamList[*pools.Pool](c, format, iter)
+	return w
+}
+
+func TestStreamList(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	for _, tt := range []struct {
+		name          string
+		format        int
+		iter          *poolIterator
+		wantStatus    int
+		wantBody      string
+		wantStreamErr string
+	}{
+		{
+			name:       "ndjson",
+			format:     streamNDJSON,
+			iter:       &poolIterator{names: []string{"pools/a", "pools/b"}},
+			wantStatus: http.StatusOK,
+			wantBody:   `{"name":"pools/a"}` + "\n" + `{"name":"pools/b"}` + "\n",
+		},
+		{
+			name:       "json array",
+			format:     streamJSONArray,
+			iter:       &poolIterator{names: []string{"pools/a", "pools/b"}},
+			wantStatus: http.StatusOK,
+			wantBody:   `[{"name":"pools/a"},{"name":"pools/b"}]`,
+		},
+		{
+			name:       "empty json array",
+			format:     streamJSONArray,
+			iter:       &poolIterator{},
+			wantStatus: http.StatusOK,
+			wantBody:   `[]`,
+		},
+		{
+			name:       "error before the first item",
+			format:     streamNDJSON,
+			iter:       &poolIterator{err: unavailable},
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `{"code":"UNAVAILABLE","message":"unavailable"}`,
+		},
+		{
+			name:          "ndjson error mid-stream",
+			format:        streamNDJSON,
+			iter:          &poolIterator{names: []string{"pools/a"}, err: unavailable},
+			wantStatus:    http.StatusOK,
+			wantBody:      `{"name":"pools/a"}` + "\n" + `{"error":{"code":"UNAVAILABLE","message":"unavailable"}}` + "\n",
+			wantStreamErr: "UNAVAILABLE",
+		},
+		{
+			name:          "json array error mid-stream",
+			format:        streamJSONArray,
+			iter:          &poolIterator{names: []string{"pools/a"}, err: unavailable},
+			wantStatus:    http.StatusOK,
+			wantBody:      `[{"name":"pools/a"}`,
+			wantStreamErr: "UNAVAILABLE",
+		},
+	} {
+		w := serveStream(tt.format, tt.iter)
+		body := strings.ReplaceAll(w.Body.String(), " ", "")
+		if w.Code != tt.wantStatus || body != tt.wantBody {
+			t.Errorf("streamList(%s) = %d %s, want %d %s", tt.name, w.Code, body, tt.wantStatus, tt.wantBody)
+		}
+		wantStatus := codeName(codes.OK)
+		if tt.wantStreamErr != "" {
+			wantStatus = tt.wantStreamErr
+		}
+		if tt.wantStatus == http.StatusOK && w.Header().Get(trailerStreamStatus) != wantStatus {
+			t.Errorf("streamList(%s) %s = %q, want %s", tt.name, trailerStreamStatus, w.Header().Get(trailerStreamStatus), wantStatus)
+		}
+	}
+}
+
+func TestStreamFormat(t *testing.T) {
+	for _, tt := range []struct {
+		accept, query string
+		want          int
+	}{
+		{want: streamNone},
+		{accept: contentTypeNDJSON, want: streamNDJSON},
+		{accept: "application/json, " + contentTypeNDJSON, want: streamNDJSON},
+		{query: "stream=true", want: streamJSONArray},
+		{query: "stream=no", want: streamNone},
+	} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil)
+		c.Request.Header.Set("Accept", tt.accept)
+		if got := streamFormat(c); got != tt.want {
+			t.Errorf("streamFormat(Accept %q, %q) = %d, want %d", tt.accept, tt.query, got, tt.want)
+		}
+	}
+}
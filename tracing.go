@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracingServiceName is the default service.name of the proxy's spans.
+	tracingServiceName = "waas-proxy"
+
+	// tracerName is the instrumentation scope of the spans started by the proxy.
+	tracerName = "waas/proxy"
+
+	// Trace exporters selected by -trace-exporter.
+	traceExporterNone   = "none"
+	traceExporterOTLP   = "otlp"
+	traceExporterStdout = "stdout"
+	traceExporterFile   = "file"
+)
+
+var (
+	// traceExporter selects where spans are sent.
+	traceExporter = flag.String("trace-exporter", traceExporterNone, "where to export OpenTelemetry spans: none, otlp (configured by the OTEL_EXPORTER_OTLP_* variables), stdout or file")
+
+	// traceFile is the file spans are written to with -trace-exporter=file.
+	traceFile = flag.String("trace-file", "traces.jsonl", "file to append spans to with -trace-exporter=file")
+
+	// traceSampleRatio is the fraction of new traces sampled.
+	traceSampleRatio = flag.Float64("trace-sample-ratio", 1, "fraction of the traces started by the proxy that are sampled; incoming sampled traces are always sampled")
+)
+
+// tracer starts the spans of the proxy's own code.
+var tracer = otel.Tracer(tracerName)
+
+// setupTracing installs the global tracer provider exporting spans as configured by the
+// flags, and the W3C trace context propagator. It returns the function flushing the
+// spans on shutdown.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch *traceExporter {
+	case traceExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case traceExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case traceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case traceExporterFile:
+		var f io.Writer
+		f, err = os.OpenFile(*traceFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err == nil {
+			exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		}
+	default:
+		return nil, fmt.Errorf("unknown -trace-exporter %q", *traceExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create %s trace exporter: %v", *traceExporter, err)
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the defaults.
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(tracingServiceName)),
+	)
+	if err == nil {
+		res, err = resource.Merge(res, resource.Environment())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot build trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*traceSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// traceRequests returns the middleware starting a server span for every request, named
+// after its route template and continuing the caller's traceparent, and recording the
+// RPC and resources addressed by the route as span attributes.
+func traceRequests() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		otelgin.Middleware(tracingServiceName),
+		func(c *gin.Context) {
+			span := trace.SpanFromContext(c.Request.Context())
+			// The RPC is only known once the route's handlers have run.
+			c.Next()
+			if !span.IsRecording() {
+				return
+			}
+
+			attributes := []attribute.KeyValue{}
+			if rpc := c.GetString(rpcContextKey); rpc != "" {
+				attributes = append(attributes, attribute.String("waas.rpc", rpc))
+			}
+			if resource := auditResource(c); resource != "" {
+				attributes = append(attributes, attribute.String("waas.resource", resource))
+			}
+			for _, param := range c.Params {
+				attributes = append(attributes, attribute.String("waas."+snakeCase(param.Key), param.Value))
+			}
+			span.SetAttributes(attributes...)
+		},
+	}
+}
+
+// snakeCase converts a camel-case path parameter name to snake case, e.g. mpcWalletId to
+// mpc_wallet_id.
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spanRecorder     *tracetest.SpanRecorder
+	spanRecorderOnce sync.Once
+)
+
+// recordSpans installs a tracer provider recording every span ended. It is installed
+// once, as the proxy's tracer is bound to the first global provider set.
+func recordSpans() *tracetest.SpanRecorder {
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return spanRecorder
+}
+
+// endedSpans returns the spans of the trace with the given ID recorded by recorder.
+func endedSpans(recorder *tracetest.SpanRecorder, traceID trace.TraceID) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// spanAttributes returns the attributes of span by key.
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{
+		"poolId":        "pool_id",
+		"mpcWalletId":   "mpc_wallet_id",
+		"networkId":     "network_id",
+		"operation":     "operation",
+		"DeviceGroupId": "device_group_id",
+	} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestTraceRequests(t *testing.T) {
+	recorder := recordSpans()
+
+	router := gin.New()
+	router.Use(traceRequests()...)
+	router.GET("/mpc_wallets/v1/pools/:poolId/mpcWallets/:mpcWalletId", func(c *gin.Context) {
+		c.Set(rpcContextKey, "GetMPCWallet")
+		c.Status(http.StatusOK)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r := httptest.NewRequest(http.MethodGet, "/mpc_wallets/v1/pools/pool-1/mpcWallets/wallet-1", nil)
+	r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	id, _ := trace.TraceIDFromHex(traceID)
+	spans := endedSpans(recorder, id)
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans of the trace, want 1", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "/mpc_wallets/v1/pools/:poolId/mpcWallets/:mpcWalletId" || span.SpanKind() != trace.SpanKindServer {
+		t.Errorf("span = %s of kind %v, want a server span named after the route template", span.Name(), span.SpanKind())
+	}
+
+	attributes := spanAttributes(span)
+	for key, want := range map[attribute.Key]string{
+		"waas.rpc":           "GetMPCWallet",
+		"waas.resource":      "pools/pool-1/mpcWallets/wallet-1",
+		"waas.pool_id":       "pool-1",
+		"waas.mpc_wallet_id": "wallet-1",
+	} {
+		if got := attributes[key].AsString(); got != want {
+			t.Errorf("span attribute %s = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestTraceUpstreamCalls(t *testing.T) {
+	recorder := recordSpans()
+	upstream := newTestUpstream(t, upstreamReply{status: http.StatusNotFound, body: `{"error": {"status": "NOT_FOUND"}}`})
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	req, _ := http.NewRequestWithContext(withRPC(ctx, "GetPool"), http.MethodGet, upstream.URL, nil)
+	resp, err := newTestTransport(t).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() = %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	var span sdktrace.ReadOnlySpan
+	for _, s := range endedSpans(recorder, parent.SpanContext().TraceID()) {
+		if s.Name() == "pool/GetPool" {
+			span = s
+		}
+	}
+	if span == nil {
+		t.Fatal("no pool/GetPool span recorded")
+	}
+	if span.SpanKind() != trace.SpanKindClient || span.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("span = %v with parent %v, want a client span of the request", span.SpanKind(), span.Parent().SpanID())
+	}
+	if span.Status().Code != otelcodes.Error || span.Status().Description != "NOT_FOUND" {
+		t.Errorf("span status = %+v, want an error NOT_FOUND", span.Status())
+	}
+	if got := spanAttributes(span)["rpc.method"].AsString(); got != "GetPool" {
+		t.Errorf("span attribute rpc.method = %q, want GetPool", got)
+	}
+}
+
+func TestSetupTracing(t *testing.T) {
+	defer func(exporter, file string) { *traceExporter, *traceFile = exporter, file }(*traceExporter, *traceFile)
+	// setupTracing replaces the global provider; the recording one is restored afterwards.
+	recordSpans()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	defer func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	}()
+
+	*traceExporter = "jaeger"
+	if _, err := setupTracing(context.Background()); err == nil {
+		t.Error("setupTracing() with an unknown exporter succeeded, want an error")
+	}
+
+	*traceExporter, *traceFile = traceExporterFile, filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := setupTracing(context.Background())
+	if err != nil {
+		t.Fatalf("setupTracing() = %v", err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "exported")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() = %v", err)
+	}
+
+	data, err := os.ReadFile(*traceFile)
+	if err != nil || !strings.Contains(string(data), `"Name":"exported"`) || !strings.Contains(string(data), tracingServiceName) {
+		t.Errorf("trace file = %s, %v, want the exported span of the %s service", data, err, tracingServiceName)
+	}
+}
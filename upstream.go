@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/coinbase/waas-client-library-go/auth"
+	"github.com/coinbase/waas-client-library-go/clients"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+)
+
+// WaaS services, named as the v1clients name them when authenticating.
+const (
+	blockchainService     = "waas_blockchain_service"
+	mpcKeyService         = "waas_mpc_key_service"
+	mpcTransactionService = "waas_mpc_transaction_service"
+	mpcWalletService      = "waas_mpc_wallet_service"
+	poolService           = "waas_pool_service"
+	protocolService       = "waas_protocol_service"
+)
+
+const (
+	// upstreamRetriesHeader reports how many times the upstream calls of a request were retried.
+	upstreamRetriesHeader = "X-Upstream-Retries"
+
+	// maxRetryErrorBody caps how much of an upstream error body is read to decide on a retry.
+	maxRetryErrorBody = 64 << 10
+)
+
+var (
+	// retryMaxAttempts is the default number of attempts of a safe upstream call.
+	retryMaxAttempts = flag.Int("retry-max-attempts", 3, "attempts of a safe upstream call failing with UNAVAILABLE, RESOURCE_EXHAUSTED or DEADLINE_EXCEEDED; 1 disables retries")
+
+	// retryAttemptsFlag overrides retryMaxAttempts for individual RPCs.
+	retryAttemptsFlag = flag.String("retry-attempts", "", "comma-separated per-RPC attempts overriding -retry-max-attempts, e.g. ListBalances=5,GetNetwork=1")
+
+	// retryInitialBackoff and retryMaxBackoff bound the exponential backoff between attempts.
+	retryInitialBackoff = flag.Duration("retry-initial-backoff", 100*time.Millisecond, "backoff before the first retry, doubled for each further retry")
+	retryMaxBackoff     = flag.Duration("retry-max-backoff", 5*time.Second, "maximum backoff between retries")
+)
+
+// retryAttempts holds the parsed -retry-attempts overrides.
+var retryAttempts map[string]int
+
+var upstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "upstream_retries_total",
+	Help:      "Retries of WaaS calls, by service and RPC of the route making them.",
+}, []string{"service", "method"})
+
+// rpcKey is the request context key holding the name of the RPC served by the route.
+type rpcKey struct{}
+
+// retryCountKey is the request context key holding the request's *int32 retry count.
+type retryCountKey struct{}
+
+// withRPC returns a copy of ctx naming the RPC its upstream calls are made for.
+func withRPC(ctx context.Context, rpc string) context.Context {
+	return context.WithValue(ctx, rpcKey{}, rpc)
+}
+
+// rpcFromContext returns the name of the RPC served by the route handling ctx's request.
+func rpcFromContext(ctx context.Context) string {
+	rpc, _ := ctx.Value(rpcKey{}).(string)
+	return rpc
+}
+
+// parseRetryAttempts parses a comma-separated list of RPC=attempts pairs.
+func parseRetryAttempts(value string) (map[string]int, error) {
+	attempts := map[string]int{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		rpc, count, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("retry attempts %q must have the form RPC=attempts", pair)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("retry attempts %q must be a positive integer", pair)
+		}
+		attempts[rpc] = n
+	}
+	return attempts, nil
+}
+
+// upstreamOption returns the client option sending the calls of the named WaaS service
+// through an upstreamTransport authenticated with apiKey.
+func upstreamOption(service string, apiKey *auth.APIKey) clients.WaaSClientOption {
+	return clients.WithHTTPClient(&http.Client{
+		Transport: &upstreamTransport{
+			service:       service,
+			authenticator: auth.NewAuthenticator(apiKey),
+			guard:         newServiceGuard(service),
+			base:          otelhttp.NewTransport(http.DefaultTransport),
+		},
+	})
+}
+
+// upstreamTransport is the transport of the WaaS clients. It authenticates every attempt
+// of a call with a fresh JWT, as each JWT carries a single-use nonce, retries safe calls
+// failing with a transient error, and fails calls fast through the service's guard.
+type upstreamTransport struct {
+	service       string
+	authenticator *auth.Authenticator
+	guard         *serviceGuard
+	base          http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	service, rpc := serviceLabel(t.service), rpcFromContext(ctx)
+
+	if rejected := t.guard.acquire(req); rejected != nil {
+		return rejected, nil
+	}
+	defer t.guard.release()
+
+	ctx, span := tracer.Start(ctx, service+"/"+rpc, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.RPCSystemKey.String("waas"),
+		semconv.RPCService(t.service),
+		semconv.RPCMethod(rpc),
+	))
+	defer span.End()
+
+	start := time.Now()
+	upstreamCallsInFlight.WithLabelValues(service).Inc()
+	defer upstreamCallsInFlight.WithLabelValues(service).Dec()
+
+	resp, err := t.retry(req.WithContext(ctx))
+	code := upstreamCode(ctx, resp, err)
+	upstreamCalls.WithLabelValues(service, rpc, codeName(code)).Inc()
+	upstreamCallDuration.WithLabelValues(service, rpc).Observe(time.Since(start).Seconds())
+
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+	if code != codes.OK {
+		span.SetStatus(otelcodes.Error, codeName(code))
+	}
+	return resp, err
+}
+
+// retry sends req, retrying it while it fails transiently if it is safe to.
+func (t *upstreamTransport) retry(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	rpc := rpcFromContext(ctx)
+
+	attempts := *retryMaxAttempts
+	if n, ok := retryAttempts[rpc]; ok {
+		attempts = n
+	}
+	if attempts > 1 && !safeToRetry(req) {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := t.attempt(req)
+		// Once the breaker opens, the remaining attempts would fail fast anyway.
+		if attempt >= attempts || !t.guard.breaker.closed() {
+			return resp, err
+		}
+
+		var retryable bool
+		var delay time.Duration
+		if resp, retryable, delay = retryableResponse(resp, err); !retryable {
+			return resp, err
+		}
+		if delay == 0 {
+			delay = backoff(attempt)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		case <-timer.C:
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		upstreamRetries.WithLabelValues(serviceLabel(t.service), rpc).Inc()
+		if count, ok := ctx.Value(retryCountKey{}).(*int32); ok {
+			atomic.AddInt32(count, 1)
+		}
+	}
+}
+
+// attempt sends a copy of req with a fresh Authorization header, recording its outcome
+// in the service's breaker. Attempts failing before reaching WaaS are recorded as
+// ignored, so that a half-open breaker lets another probe through.
+func (t *upstreamTransport) attempt(req *http.Request) (*http.Response, error) {
+	outcome := attemptIgnored
+	defer func() { t.guard.breaker.record(outcome) }()
+
+	jwt, err := t.authenticator.BuildJWT(t.service, fmt.Sprintf("%s %s%s", req.Method, req.URL.Host, req.URL.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if attempt.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	attempt.Header.Set("Authorization", "Bearer "+jwt)
+	resp, err := t.base.RoundTrip(attempt)
+	outcome = classifyAttempt(req.Context(), resp, err)
+	return resp, err
+}
+
+// safeToRetry reports whether req may be sent again without risk of applying it twice:
+// reads, and creates carrying a request ID that WaaS deduplicates them by.
+func safeToRetry(req *http.Request) bool {
+	if req.Method == http.MethodGet {
+		return true
+	}
+	if req.URL.Query().Get("requestId") != "" {
+		return true
+	}
+	if req.GetBody == nil {
+		return false
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return false
+	}
+	defer body.Close()
+
+	var fields struct {
+		RequestID string `json:"requestId"`
+	}
+	return json.NewDecoder(body).Decode(&fields) == nil && fields.RequestID != ""
+}
+
+// retryableResponse reports whether the outcome of an attempt is a transient failure,
+// and the delay requested by the server through RetryInfo, if any. The body of an error
+// response is read to find out and replaced so that it can still be read by the caller.
+func retryableResponse(resp *http.Response, err error) (*http.Response, bool, time.Duration) {
+	if err != nil {
+		return resp, true, 0
+	}
+	switch resp.StatusCode {
+	case http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusGatewayTimeout:
+	default:
+		return resp, false, 0
+	}
+
+	body, readErr := io.ReadAll(io.LimitReader(resp.Body, maxRetryErrorBody))
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return resp, true, 0
+	}
+
+	var errBody struct {
+		Error struct {
+			Status  string `json:"status"`
+			Details []struct {
+				Type       string `json:"@type"`
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &errBody)
+
+	switch errBody.Error.Status {
+	case "", "UNAVAILABLE", "RESOURCE_EXHAUSTED", "DEADLINE_EXCEEDED":
+	default:
+		return resp, false, 0
+	}
+
+	var delay time.Duration
+	for _, detail := range errBody.Error.Details {
+		if strings.HasSuffix(detail.Type, "google.rpc.RetryInfo") {
+			delay, _ = time.ParseDuration(detail.RetryDelay)
+		}
+	}
+	return resp, true, delay
+}
+
+// backoff returns the delay before the given retry: exponential, capped and fully jittered.
+func backoff(retry int) time.Duration {
+	ceiling := *retryMaxBackoff
+	if shift := retry - 1; shift < 32 {
+		if d := *retryInitialBackoff << shift; d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+// countRetries returns middleware counting the retried upstream calls of each request and
+// reporting them in the X-Upstream-Retries header.
+func countRetries() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		count := new(int32)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), retryCountKey{}, count))
+		c.Writer = &retriesWriter{ResponseWriter: c.Writer, count: count}
+	}
+}
+
+// retriesWriter sets the X-Upstream-Retries header when the response is committed.
+type retriesWriter struct {
+	gin.ResponseWriter
+	count *int32
+}
+
+func (w *retriesWriter) setHeader() {
+	if n := atomic.LoadInt32(w.count); n > 0 && !w.Written() {
+		w.Header().Set(upstreamRetriesHeader, strconv.Itoa(int(n)))
+	}
+}
+
+func (w *retriesWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *retriesWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *retriesWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coinbase/waas-client-library-go/auth"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/codes"
+)
+
+// upstreamReply is a response of the test upstream server.
+type upstreamReply struct {
+	status int
+	body   string
+}
+
+// testUpstream is a WaaS server replying to successive requests with replies, repeating
+// the last one, and recording the requests it received.
+type testUpstream struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	replies        []upstreamReply
+	bodies         []string
+	authorizations []string
+}
+
+func newTestUpstream(t *testing.T, replies ...upstreamReply) *testUpstream {
+	u := &testUpstream{replies: replies}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		u.mu.Lock()
+		reply := u.replies[0]
+		if len(u.replies) > 1 {
+			u.replies = u.replies[1:]
+		}
+		u.bodies = append(u.bodies, string(body))
+		u.authorizations = append(u.authorizations, r.Header.Get("Authorization"))
+		u.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(reply.status)
+		io.WriteString(w, reply.body)
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// calls returns the number of requests the server received.
+func (u *testUpstream) calls() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.bodies)
+}
+
+// newTestTransport returns an upstreamTransport of the pool service authenticating with a
+// generated API key, and shortens the retry backoff for the duration of the test.
+func newTestTransport(t *testing.T) *upstreamTransport {
+	t.Helper()
+
+	initial, max := *retryInitialBackoff, *retryMaxBackoff
+	*retryInitialBackoff, *retryMaxBackoff = time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { *retryInitialBackoff, *retryMaxBackoff = initial, max })
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKey := &auth.APIKey{
+		Name:       "organizations/test/apiKeys/test",
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "ECDSA Private Key", Bytes: der})),
+	}
+	return &upstreamTransport{service: poolService, authenticator: auth.NewAuthenticator(apiKey), guard: newServiceGuard(poolService), base: http.DefaultTransport}
+}
+
+// unavailable is an UNAVAILABLE error body, asking for a retry after retryDelay if set.
+func unavailable(retryDelay string) upstreamReply {
+	body := `{"error": {"code": 503, "status": "UNAVAILABLE", "message": "try again"}}`
+	if retryDelay != "" {
+		body = `{"error": {"code": 503, "status": "UNAVAILABLE", "message": "try again", "details": [
+			{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "` + retryDelay + `"}
+		]}}`
+	}
+	return upstreamReply{status: http.StatusServiceUnavailable, body: body}
+}
+
+var upstreamOK = upstreamReply{status: http.StatusOK, body: `{"name": "pools/p"}`}
+
+func TestUpstreamRetries(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		method     string
+		query      string
+		body       string
+		replies    []upstreamReply
+		wantStatus int
+		wantCalls  int
+	}{
+		{name: "GET", method: http.MethodGet, replies: []upstreamReply{unavailable(""), upstreamOK}, wantStatus: http.StatusOK, wantCalls: 2},
+		{name: "GET exhausted", method: http.MethodGet, replies: []upstreamReply{unavailable("")}, wantStatus: http.StatusServiceUnavailable, wantCalls: 3},
+		{name: "POST", method: http.MethodPost, body: `{"pool": {}}`, replies: []upstreamReply{unavailable(""), upstreamOK}, wantStatus: http.StatusServiceUnavailable, wantCalls: 1},
+		{name: "POST requestId in body", method: http.MethodPost, body: `{"pool": {}, "requestId": "r-1"}`, replies: []upstreamReply{unavailable(""), upstreamOK}, wantStatus: http.StatusOK, wantCalls: 2},
+		{name: "POST requestId in query", method: http.MethodPost, query: "?requestId=r-1", body: `{}`, replies: []upstreamReply{unavailable(""), upstreamOK}, wantStatus: http.StatusOK, wantCalls: 2},
+		{name: "resource exhausted", method: http.MethodGet, replies: []upstreamReply{{status: http.StatusTooManyRequests, body: `{"error": {"status": "RESOURCE_EXHAUSTED"}}`}, upstreamOK}, wantStatus: http.StatusOK, wantCalls: 2},
+		{name: "not found", method: http.MethodGet, replies: []upstreamReply{{status: http.StatusNotFound, body: `{"error": {"status": "NOT_FOUND"}}`}, upstreamOK}, wantStatus: http.StatusNotFound, wantCalls: 1},
+		{name: "permanent 503", method: http.MethodGet, replies: []upstreamReply{{status: http.StatusServiceUnavailable, body: `{"error": {"status": "FAILED_PRECONDITION"}}`}, upstreamOK}, wantStatus: http.StatusServiceUnavailable, wantCalls: 1},
+	} {
+		upstream := newTestUpstream(t, tt.replies...)
+		transport := newTestTransport(t)
+
+		req, err := http.NewRequest(tt.method, upstream.URL+"/v1/pools"+tt.query, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("%s: RoundTrip() = %v", tt.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.wantStatus || upstream.calls() != tt.wantCalls {
+			t.Errorf("%s: RoundTrip() = %d %s after %d calls, want %d after %d calls", tt.name, resp.StatusCode, body, upstream.calls(), tt.wantStatus, tt.wantCalls)
+		}
+		// Every attempt sends the whole body with a fresh JWT, as each carries a single-use nonce.
+		for i, got := range upstream.bodies {
+			if got != tt.body || !strings.HasPrefix(upstream.authorizations[i], "Bearer ") || (i > 0 && upstream.authorizations[i] == upstream.authorizations[i-1]) {
+				t.Errorf("%s: attempt %d sent %q with %q, want the body with a fresh JWT", tt.name, i+1, got, upstream.authorizations[i])
+			}
+		}
+	}
+}
+
+func TestUpstreamRetryAttemptsOverride(t *testing.T) {
+	defer func(attempts map[string]int) { retryAttempts = attempts }(retryAttempts)
+
+	var err error
+	if retryAttempts, err = parseRetryAttempts("GetNetwork=1, ListBalances=5"); err != nil {
+		t.Fatalf("parseRetryAttempts() = %v", err)
+	}
+	for _, value := range []string{"GetNetwork", "GetNetwork=0", "GetNetwork=many"} {
+		if _, err := parseRetryAttempts(value); err == nil {
+			t.Errorf("parseRetryAttempts(%q) succeeded, want an error", value)
+		}
+	}
+
+	for rpc, wantCalls := range map[string]int{"GetNetwork": 1, "ListBalances": 5, "GetPool": 3} {
+		upstream := newTestUpstream(t, unavailable(""))
+		ctx := context.WithValue(context.Background(), rpcKey{}, rpc)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+		resp, err := newTestTransport(t).RoundTrip(req)
+		if err != nil {
+			t.Fatalf("RoundTrip(%s) = %v", rpc, err)
+		}
+		resp.Body.Close()
+		if upstream.calls() != wantCalls {
+			t.Errorf("RoundTrip(%s) made %d calls, want %d", rpc, upstream.calls(), wantCalls)
+		}
+	}
+}
+
+func TestUpstreamRetryInfo(t *testing.T) {
+	upstream := newTestUpstream(t, unavailable("0.2s"), upstreamOK)
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+
+	start := time.Now()
+	resp, err := newTestTransport(t).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() = %v", err)
+	}
+	resp.Body.Close()
+	if elapsed := time.Since(start); resp.StatusCode != http.StatusOK || elapsed < 200*time.Millisecond {
+		t.Errorf("RoundTrip() = %d after %v, want 200 after the 200ms RetryInfo delay", resp.StatusCode, elapsed)
+	}
+}
+
+func TestUpstreamRetryDeadline(t *testing.T) {
+	// A retry that would outlast the deadline is not attempted.
+	upstream := newTestUpstream(t, unavailable("1s"), upstreamOK)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+
+	start := time.Now()
+	resp, err := newTestTransport(t).RoundTrip(req)
+	if err != nil {
+		t.Fatalf("RoundTrip() = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if elapsed := time.Since(start); resp.StatusCode != http.StatusServiceUnavailable || upstream.calls() != 1 || elapsed > 50*time.Millisecond {
+		t.Errorf("RoundTrip() = %d after %d calls and %v, want the first 503 immediately", resp.StatusCode, upstream.calls(), elapsed)
+	}
+	// The error body read to decide on the retry is still readable.
+	if !strings.Contains(string(body), "UNAVAILABLE") {
+		t.Errorf("RoundTrip() body = %s, want the upstream error", body)
+	}
+}
+
+func TestUpstreamRetriesHeader(t *testing.T) {
+	upstream := newTestUpstream(t, unavailable(""), unavailable(""), upstreamOK)
+	client := &http.Client{Transport: newTestTransport(t)}
+
+	router := gin.New()
+	router.Use(countRetries())
+	router.GET("/pools/:poolId", func(c *gin.Context) {
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			abortWithCode(c, codes.Unavailable, err.Error())
+			return
+		}
+		resp.Body.Close()
+		c.JSON(resp.StatusCode, gin.H{"name": "pools/p"})
+	})
+	router.GET("/networks/:networkId", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{}) })
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pools/p", nil))
+	if w.Code != http.StatusOK || w.Header().Get(upstreamRetriesHeader) != "2" {
+		t.Errorf("GET /pools/p = %d with %s %q, want 200 after 2 retries", w.Code, upstreamRetriesHeader, w.Header().Get(upstreamRetriesHeader))
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/networks/n", nil))
+	if _, ok := w.Header()[upstreamRetriesHeader]; ok {
+		t.Errorf("GET /networks/n without retries sets %s", upstreamRetriesHeader)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	initial, max := *retryInitialBackoff, *retryMaxBackoff
+	defer func() { *retryInitialBackoff, *retryMaxBackoff = initial, max }()
+	*retryInitialBackoff, *retryMaxBackoff = 100*time.Millisecond, time.Second
+
+	for retry, ceiling := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 64: time.Second} {
+		for i := 0; i < 100; i++ {
+			if d := backoff(retry); d <= 0 || d > ceiling {
+				t.Fatalf("backoff(%d) = %v, want within (0, %v]", retry, d, ceiling)
+			}
+		}
+	}
+}